@@ -3,31 +3,61 @@ package main
 import (
 	"context"
 	"flag"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/rs/zerolog"
 	"rvcinemaview/internal/api"
+	"rvcinemaview/internal/cache"
 	"rvcinemaview/internal/config"
+	"rvcinemaview/internal/events"
+	"rvcinemaview/internal/logging"
 	"rvcinemaview/internal/media"
 	"rvcinemaview/internal/server"
 	"rvcinemaview/internal/storage"
+	"rvcinemaview/internal/streaming"
 )
 
+// configFiles collects the -config flags, each a path or a comma-separated
+// list of paths
+type configFiles []string
+
+func (f *configFiles) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *configFiles) Set(value string) error {
+	for _, path := range strings.Split(value, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			*f = append(*f, path)
+		}
+	}
+	return nil
+}
+
 func main() {
-	configPath := flag.String("config", "", "path to config file")
+	var configPaths configFiles
+	flag.Var(&configPaths, "config", "path to config file, repeat or separate with commas to merge several (later files win)")
 	flag.Parse()
 
 	// Load configuration
-	cfg, err := config.Load(*configPath)
+	cfg, missing, err := config.Load(configPaths...)
 	if err != nil {
 		panic("failed to load config: " + err.Error())
 	}
 
 	// Setup logger
-	logger := setupLogger(cfg.Logging)
+	logger, closeLog := setupLogger(cfg.Logging)
+	defer closeLog()
+
+	for _, path := range missing {
+		logger.Warn().Str("path", path).Msg("config file not found, skipped")
+	}
 
 	logger.Info().
 		Str("version", api.Version).
@@ -39,13 +69,33 @@ func main() {
 		logger.Fatal().Err(err).Msg("failed to initialize storage")
 	}
 	defer store.Close()
+	store.SetMaxOpenConns(cfg.Database.MaxOpenConns)
 
 	// Initialize scanner
-	scanner := media.NewScanner(store, logger)
+	var titleCleaner *media.TitleCleaner
+	if cfg.Library.CleanTitles {
+		tokens := cfg.Library.StripTokens
+		if tokens == nil {
+			tokens = media.DefaultStripTokens
+		}
+		titleCleaner = media.NewTitleCleaner(tokens, cfg.Library.StripYears)
+	}
+	scanner := media.NewScanner(store, titleCleaner, cfg.Library.ReadNFO, logger)
+	scanner.SetNice(cfg.Library.ScanNice)
+	scanner.SetScanWebhook(cfg.Library.ScanWebhook)
+	scanner.SetMountRetry(cfg.Library.MountRetries, cfg.Library.MountRetryDelay)
+	scanner.SetTrashRetention(cfg.Library.TrashRetention)
+	scanner.SetStableIDs(cfg.Library.StableIDs)
+	scanner.SetCleanupMaxMissing(cfg.Library.CleanupMaxMissing)
 
 	// Initialize metadata extractor and thumbnail generator
 	metadataExtractor := media.NewMetadataExtractor(logger)
-	thumbnailGenerator := media.NewThumbnailGenerator(cfg.Thumbnails.OutputDir, logger)
+	thumbnailGenerator := media.NewThumbnailGenerator(cfg.Thumbnails.OutputDir, cfg.Thumbnails.Strategy, logger)
+	metadataExtractor.SetNice(cfg.Library.ScanNice)
+	metadataExtractor.SetTimeout(cfg.Metadata.Timeout)
+	thumbnailGenerator.SetNice(cfg.Library.ScanNice)
+	thumbnailGenerator.SetFrameOptions(cfg.Thumbnails.SeekPercent, cfg.Thumbnails.Width, cfg.Thumbnails.Quality)
+	thumbnailGenerator.SetSprite(cfg.Thumbnails.SpriteTiles, cfg.Thumbnails.SpriteTileWidth)
 
 	// Log ffmpeg/ffprobe availability
 	if metadataExtractor.IsAvailable() {
@@ -66,18 +116,98 @@ func main() {
 		store,
 		cfg.Thumbnails.CacheCapacity,
 		cfg.Thumbnails.CacheMaxSize,
+		cfg.Thumbnails.StoreInDB,
 		logger,
 	)
 
+	// Event bus for real-time notifications
+	eventBus := events.NewBus()
+
 	// Create server
 	srv := server.New(cfg, logger, store)
 	srv.SetScanner(scanner)
+	srv.SetEventBus(eventBus)
+	scanner.SetEventBus(eventBus)
+	thumbnailService.SetEventBus(eventBus)
+	thumbnailService.SetMaxAttempts(cfg.Thumbnails.MaxAttempts)
+	thumbnailService.SetPrewarmWorkers(cfg.Thumbnails.PrewarmWorkers)
+	thumbnailService.SetMaxConcurrent(cfg.Thumbnails.MaxConcurrent)
+	thumbnailService.SetThrottle(cfg.Thumbnails.ThrottleMin, cfg.Thumbnails.ThrottleMax)
+	thumbnailService.SetFormat(cfg.Thumbnails.Format)
 	srv.SetThumbnailService(thumbnailService)
 
+	// Thumbnails generated before the thumbnail flag existed count as missing
+	// in the library stats until the flag is set from what is stored
+	go func() {
+		n, err := thumbnailService.SyncGeneratedFlags()
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to sync thumbnail flags")
+		} else if n > 0 {
+			logger.Info().Int("count", n).Msg("marked existing thumbnails as generated")
+		}
+	}()
+
+	// Subtitles are cached alongside thumbnails
+	subtitleExtractor := media.NewSubtitleExtractor(cfg.Thumbnails.OutputDir, logger)
+	srv.SetSubtitleExtractor(subtitleExtractor)
+
+	posterStore, err := media.NewPosterStore(cfg.Thumbnails.PosterDir)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to initialize poster directory")
+	}
+	srv.SetPosterStore(posterStore)
+
+	// Purged media leaves nothing behind in the data directories
+	scanner.SetPurgeHook(func(mediaID string) {
+		thumbnailService.RemoveThumbnail(mediaID)
+		if err := subtitleExtractor.Delete(mediaID); err != nil && !os.IsNotExist(err) {
+			logger.Warn().Err(err).Str("id", mediaID).Msg("failed to delete cached subtitles")
+		}
+		if err := posterStore.Delete(mediaID); err != nil {
+			logger.Warn().Err(err).Str("id", mediaID).Msg("failed to delete poster")
+		}
+	})
+
 	// Handle shutdown signals
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Scratch cache for transient artifacts, cleaned on startup and periodically
+	dirCache, err := cache.NewDirCache(cfg.Cache.Dir, cfg.Cache.MaxSize, cfg.Cache.MaxAge)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to initialize cache directory")
+	}
+	dirCache.StartJanitor(ctx, cfg.Cache.JanitorInterval, logger)
+	thumbnailService.SetFrameCache(dirCache)
+
+	// Keep the write-ahead log small between SQLite's own checkpoints
+	store.StartCheckpointer(ctx, cfg.Database.CheckpointInterval, logger)
+
+	// HLS transcodes for browsers. Segments are kept out of the scratch
+	// cache, whose janitor would delete them from under running sessions;
+	// the manager removes them itself when a session ends.
+	hlsManager := streaming.NewHLSManager(cfg.Server.HLSDir, cfg.Server.HLSIdleTimeout, logger)
+	hlsManager.Start(ctx)
+	srv.SetHLSManager(hlsManager)
+
+	// Track whether the library storage is reachable
+	if cfg.Library.Path != "" && cfg.Library.ProbeInterval > 0 {
+		libraryMonitor := media.NewLibraryMonitor(cfg.Library.Path, store, logger)
+		libraryMonitor.Start(ctx, cfg.Library.ProbeInterval)
+		srv.SetLibraryMonitor(libraryMonitor)
+		scanner.SetLibraryMonitor(libraryMonitor)
+	}
+
+	// Optional metadata enrichment webhook for newly scanned items
+	if cfg.Library.EnrichWebhook != "" {
+		enricher := media.NewEnricher(cfg.Library.EnrichWebhook, store, logger)
+		enricher.Start(ctx)
+		scanner.SetEnricher(enricher)
+		logger.Info().Str("url", cfg.Library.EnrichWebhook).Msg("enrichment webhook enabled")
+	}
+
+	watch := &libraryWatch{path: cfg.Library.Path, scanner: scanner, logger: logger}
+
 	// Initial scan if library path configured
 	if cfg.Library.Path != "" {
 		go func() {
@@ -92,9 +222,27 @@ func main() {
 				// Start background metadata/thumbnail processing after scan
 				thumbnailService.StartBackgroundProcessing(ctx, 100, 500*time.Millisecond)
 			}
+
+			// Pick up library changes without full rescans
+			watch.set(ctx, cfg.Library.Watch)
 		}()
 	}
 
+	// Apply what can change at runtime on SIGHUP
+	go func() {
+		hupCh := make(chan os.Signal, 1)
+		signal.Notify(hupCh, syscall.SIGHUP)
+		active := *cfg
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupCh:
+				reloadConfig(ctx, configPaths, &active, srv, watch, logger)
+			}
+		}
+	}()
+
 	go func() {
 		sigCh := make(chan os.Signal, 1)
 		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -116,7 +264,104 @@ func main() {
 	logger.Info().Msg("server stopped")
 }
 
-func setupLogger(cfg config.LoggingConfig) zerolog.Logger {
+// libraryWatch starts and stops the library watcher, which can be toggled
+// by a config reload
+type libraryWatch struct {
+	path    string
+	scanner *media.Scanner
+	logger  zerolog.Logger
+	cancel  context.CancelFunc // nil while not watching
+	mu      sync.Mutex
+}
+
+func (w *libraryWatch) set(ctx context.Context, enabled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !enabled {
+		if w.cancel != nil {
+			w.cancel()
+			w.cancel = nil
+			w.logger.Info().Msg("stopped watching library")
+		}
+		return
+	}
+	if w.cancel != nil {
+		return
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	watcher := media.NewLibraryWatcher(w.path, w.scanner, w.logger)
+	if err := watcher.Start(watchCtx); err != nil {
+		cancel()
+		w.logger.Error().Err(err).Msg("failed to start library watcher")
+		return
+	}
+	w.cancel = cancel
+	w.logger.Info().Str("path", w.path).Msg("watching library for changes")
+}
+
+// Settings applied by reloadConfig; other changes need a restart
+var reloadableSettings = map[string]bool{
+	"logging.level":           true,
+	"server.rate_limit.rps":   true,
+	"server.rate_limit.burst": true,
+	"library.watch":           true,
+}
+
+// reloadConfig reads the config files again and applies the reloadable
+// settings that changed since active, which is updated to match
+func reloadConfig(ctx context.Context, paths []string, active *config.Config, srv *server.Server, watch *libraryWatch, logger zerolog.Logger) {
+	if len(paths) == 0 {
+		logger.Warn().Msg("no config file to reload")
+		return
+	}
+
+	next, missing, err := config.Load(paths...)
+	if err != nil {
+		logger.Error().Err(err).Msg("config reload failed, keeping current settings")
+		return
+	}
+	for _, path := range missing {
+		logger.Warn().Str("path", path).Msg("config file not found, skipped")
+	}
+
+	var ignored []string
+	for _, setting := range config.Changed(active, next) {
+		if !reloadableSettings[setting] {
+			ignored = append(ignored, setting)
+		}
+	}
+
+	if level, err := zerolog.ParseLevel(next.Logging.Level); err == nil {
+		zerolog.SetGlobalLevel(level)
+		active.Logging.Level = next.Logging.Level
+	} else {
+		logger.Warn().Str("level", next.Logging.Level).Msg("invalid log level, keeping current level")
+	}
+
+	if next.Server.RateLimit != active.Server.RateLimit {
+		srv.SetRateLimit(next.Server.RateLimit.RPS, next.Server.RateLimit.Burst)
+		active.Server.RateLimit = next.Server.RateLimit
+	}
+
+	if next.Library.Watch != active.Library.Watch && active.Library.Path != "" {
+		watch.set(ctx, next.Library.Watch)
+		active.Library.Watch = next.Library.Watch
+	}
+
+	if len(ignored) > 0 {
+		logger.Warn().Strs("settings", ignored).Msg("changed settings need a restart to take effect")
+	}
+	logger.Info().
+		Str("log_level", active.Logging.Level).
+		Int("rate_limit_rps", active.Server.RateLimit.RPS).
+		Bool("watch", active.Library.Watch).
+		Msg("config reloaded")
+}
+
+// setupLogger returns the logger and a function closing the log file, if any
+func setupLogger(cfg config.LoggingConfig) (zerolog.Logger, func()) {
 	level, err := zerolog.ParseLevel(cfg.Level)
 	if err != nil {
 		level = zerolog.InfoLevel
@@ -124,15 +369,25 @@ func setupLogger(cfg config.LoggingConfig) zerolog.Logger {
 
 	zerolog.SetGlobalLevel(level)
 
+	var console io.Writer = os.Stdout
 	if cfg.Pretty {
-		return zerolog.New(zerolog.ConsoleWriter{Out: os.Stdout}).
-			With().
-			Timestamp().
-			Logger()
+		console = zerolog.ConsoleWriter{Out: os.Stdout}
+	}
+
+	if cfg.File == "" {
+		return zerolog.New(console).With().Timestamp().Logger(), func() {}
 	}
 
-	return zerolog.New(os.Stdout).
-		With().
-		Timestamp().
-		Logger()
+	file, err := logging.OpenRotatingFile(cfg.File, cfg.MaxSizeMB, cfg.MaxBackups)
+	if err != nil {
+		logger := zerolog.New(console).With().Timestamp().Logger()
+		logger.Error().Err(err).Str("file", cfg.File).Msg("failed to open log file, logging to stdout only")
+		return logger, func() {}
+	}
+
+	var out io.Writer = file
+	if cfg.Stdout {
+		out = zerolog.MultiLevelWriter(console, file)
+	}
+	return zerolog.New(out).With().Timestamp().Logger(), func() { file.Close() }
 }
@@ -3,7 +3,9 @@ module rvcinemaview
 go 1.22
 
 require (
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/go-chi/chi/v5 v5.0.12
+	github.com/gorilla/websocket v1.5.3
 	github.com/rs/zerolog v1.32.0
 	gopkg.in/yaml.v3 v3.0.1
 	modernc.org/sqlite v1.29.5
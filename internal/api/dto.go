@@ -1,15 +1,75 @@
 package api
 
-import "rvcinemaview/internal/storage"
+import (
+	"encoding/json"
+	"time"
+
+	mediapkg "rvcinemaview/internal/media"
+	"rvcinemaview/internal/storage"
+)
 
 type HealthResponse struct {
-	Status  string `json:"status"`
-	Version string `json:"version"`
+	Status        string                 `json:"status"` // ok, degraded or error
+	Version       string                 `json:"version"`
+	ActiveStreams int                    `json:"active_streams"`
+	LibraryOnline bool                   `json:"library_online"`
+	Checks        map[string]HealthCheck `json:"checks"` // database, ffmpeg, ffprobe, library
+}
+
+// HealthCheck is the result of one dependency check
+type HealthCheck struct {
+	Status string `json:"status"` // ok or error
+	Error  string `json:"error,omitempty"`
 }
 
 type MediaResponse struct {
-	Media     *storage.MediaItem `json:"media"`
-	StreamURL string             `json:"stream_url"`
+	Media        *storage.MediaItem `json:"media"`
+	StreamURL    string             `json:"stream_url"`
+	ThumbnailURL string             `json:"thumbnail_url,omitempty"` // Versioned, empty until generated
+	IsWatched    bool               `json:"is_watched"`
+	IsFavorite   bool               `json:"is_favorite"`
+	Artwork      map[string]string  `json:"artwork,omitempty"` // artwork type -> URL, only types that exist
+}
+
+type ProcessResponse struct {
+	MediaID           string             `json:"media_id"`
+	Status            string             `json:"status"` // processed, queued
+	MetadataExtracted bool               `json:"metadata_extracted"`
+	ThumbnailReady    bool               `json:"thumbnail_ready"`
+	Media             *storage.MediaItem `json:"media,omitempty"`
+}
+
+type MediaStatusResponse struct {
+	MediaID             string `json:"media_id"`
+	MetadataExtracted   bool   `json:"metadata_extracted"`
+	ThumbnailReady      bool   `json:"thumbnail_ready"`
+	CurrentlyProcessing bool   `json:"currently_processing"`
+	Queued              bool   `json:"queued"`
+	FailureReason       string `json:"failure_reason,omitempty"`
+}
+
+// TracksResponse lists a media item's audio and subtitle streams
+type TracksResponse struct {
+	MediaID string `json:"media_id"`
+	storage.MediaTracks
+}
+
+type ShareResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ChecksumResponse struct {
+	MediaID   string `json:"media_id"`
+	Size      int64  `json:"size"`
+	Algorithm string `json:"algorithm"`
+	Checksum  string `json:"checksum"`
+}
+
+type DeleteMediaResponse struct {
+	MediaID     string `json:"media_id"`
+	Deleted     bool   `json:"deleted"`
+	FileRemoved bool   `json:"file_removed"`
 }
 
 type ScanResponse struct {
@@ -22,39 +82,219 @@ type ErrorResponse struct {
 }
 
 type ErrorDetail struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"` // Matches the request_id in server logs
+}
+
+// IntroMarkersRequest sets manual intro markers (seconds). Both null clears
+// the override so detected markers apply again.
+type IntroMarkersRequest struct {
+	IntroStart *float64 `json:"intro_start"`
+	IntroEnd   *float64 `json:"intro_end"`
+}
+
+type IntroMarkersResponse struct {
+	MediaID    string   `json:"media_id"`
+	IntroStart *float64 `json:"intro_start"`
+	IntroEnd   *float64 `json:"intro_end"`
+}
+
+// MediaTagsRequest adds or removes tags on a media item. Tags are free-form
+// and stored lowercase.
+type MediaTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type MediaTagsResponse struct {
+	MediaID string   `json:"media_id"`
+	Tags    []string `json:"tags"`
 }
 
 // Playback DTOs
 
 type SavePlaybackRequest struct {
-	Position int64 `json:"position"` // Seconds
-	Duration int64 `json:"duration"` // Seconds
+	Position  int64      `json:"position"`             // Seconds
+	Duration  int64      `json:"duration"`             // Seconds
+	UpdatedAt *time.Time `json:"updated_at,omitempty"` // When the client recorded the position, see SavePlaybackPosition
 }
 
 type PlaybackResponse struct {
-	MediaID  string  `json:"media_id"`
-	Position int64   `json:"position"`
-	Duration int64   `json:"duration"`
-	Progress float64 `json:"progress"`
+	MediaID   string     `json:"media_id"`
+	Position  int64      `json:"position"`
+	Duration  int64      `json:"duration"`
+	Progress  float64    `json:"progress"`
+	IsWatched bool       `json:"is_watched"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"` // When the position was last saved
+}
+
+// PlaybackEventResponse is the data of a playback_updated event
+type PlaybackEventResponse struct {
+	Media    *storage.MediaItem `json:"media"`
+	Playback PlaybackResponse   `json:"playback_state"`
+}
+
+type BatchPlaybackRequest struct {
+	MediaIDs []string `json:"media_ids"`
+}
+
+type BatchPlaybackResponse struct {
+	States map[string]PlaybackResponse `json:"states"`
+}
+
+// Thumbnail DTOs
+
+type PrewarmRequest struct {
+	MediaIDs []string `json:"media_ids"`
+}
+
+type PrewarmResponse struct {
+	Cached []string `json:"cached"` // thumbnails ready to be served
+	Queued []string `json:"queued"` // thumbnails being generated
 }
 
 type ContinueWatchingResponse struct {
 	Items []storage.ContinueWatchingItem `json:"items"`
 }
 
+// SetWatchedRequest sets the watched flag; without a value it is toggled
+type SetWatchedRequest struct {
+	Watched *bool `json:"watched"`
+}
+
+type FavoriteResponse struct {
+	MediaID    string `json:"media_id"`
+	IsFavorite bool   `json:"is_favorite"`
+}
+
+// PosterResponse reports the poster of a media item after an upload or
+// removal
+type PosterResponse struct {
+	MediaID   string `json:"media_id"`
+	HasPoster bool   `json:"has_poster"`
+	PosterURL string `json:"poster_url"` // Falls back to the thumbnail without a poster
+}
+
+// FavoritesResponse is a page of favorites, most recently added first
+type FavoritesResponse struct {
+	Items  []storage.FavoriteItem `json:"items"`
+	Limit  int                    `json:"limit"`
+	Offset int                    `json:"offset"`
+}
+
+type WatchedResponse struct {
+	Items  []storage.ContinueWatchingItem `json:"items"`
+	Limit  int                            `json:"limit"`
+	Offset int                            `json:"offset"`
+}
+
+// NextUpResponse lists the next episode of each show in progress
+type NextUpResponse struct {
+	Media []storage.MediaItem `json:"media"`
+}
+
+// UpdateFolderRequest changes folder settings. Fields left out are unchanged;
+// "is_series": null removes the manual override so the flag is inferred again.
+type UpdateFolderRequest struct {
+	IsSeries json.RawMessage `json:"is_series"`
+}
+
+// RenameFolderRequest sets the name a folder is shown with. "name": null
+// goes back to the directory name.
+type RenameFolderRequest struct {
+	Name json.RawMessage `json:"name"`
+}
+
+// FolderAtlasResponse describes one page of a folder's thumbnails packed
+// into one JPEG, served separately at ImageURL. Items maps media IDs to
+// their rectangle in the image.
+type FolderAtlasResponse struct {
+	FolderID string                        `json:"folder_id"`
+	Width    int                           `json:"width"`
+	Height   int                           `json:"height"`
+	Total    int                           `json:"total"`
+	Limit    int                           `json:"limit"`
+	Offset   int                           `json:"offset"`
+	ImageURL string                        `json:"image_url"`
+	Items    map[string]mediapkg.AtlasRect `json:"items"`
+}
+
+type SearchResponse struct {
+	Query string              `json:"query"`
+	Media []storage.MediaItem `json:"media"`
+	Count int                 `json:"count"`
+}
+
+// MediaListResponse is a page of all media
+type MediaListResponse struct {
+	Media  []storage.MediaItem `json:"media"`
+	Total  int                 `json:"total"`
+	Limit  int                 `json:"limit"`
+	Offset int                 `json:"offset"`
+}
+
+// FolderMediaResponse is a page of a folder's media
+type FolderMediaResponse struct {
+	Media  []storage.MediaItem `json:"media"`
+	Total  int                 `json:"total"`
+	Limit  int                 `json:"limit"`
+	Offset int                 `json:"offset"`
+	Stats  storage.FolderStats `json:"stats"` // Totals including subfolders
+}
+
+// TagMediaResponse is a page of the media with a tag
+type TagMediaResponse struct {
+	Tag    string              `json:"tag"`
+	Media  []storage.MediaItem `json:"media"`
+	Total  int                 `json:"total"`
+	Limit  int                 `json:"limit"`
+	Offset int                 `json:"offset"`
+}
+
+// AuditLogResponse is a page of the audit log, newest first
+type AuditLogResponse struct {
+	Entries []storage.AuditEntry `json:"entries"`
+	Limit   int                  `json:"limit"`
+	Offset  int                  `json:"offset"`
+}
+
+// DuplicatesResponse lists groups of media that look like duplicates
+type DuplicatesResponse struct {
+	Groups      []storage.DuplicateGroup `json:"groups"`
+	MatchTitles bool                     `json:"match_titles"`
+	WastedBytes int64                    `json:"wasted_bytes"` // Size of every copy beyond the first
+}
+
+// FolderReportResponse is a page of the admin folder report
+type FolderReportResponse struct {
+	Folders []storage.FolderReportEntry `json:"folders"`
+	Total   int                         `json:"total"`
+	Limit   int                         `json:"limit"`
+	Offset  int                         `json:"offset"`
+}
+
+// ClearCacheResponse reports what POST /admin/cache/clear removed
+type ClearCacheResponse struct {
+	MemoryEntries int `json:"memory_entries"` // Thumbnails dropped from the memory cache
+	DiskFiles     int `json:"disk_files"`     // Files deleted from the thumbnail directory, 0 without ?disk=true
+}
+
 // Library tree - complete structure in one response
 
 type LibraryTreeResponse struct {
-	Name    string              `json:"name"`
-	Folders []FolderNode        `json:"folders"`
-	Media   []storage.MediaItem `json:"media,omitempty"`
+	Name          string                 `json:"name"`
+	LibraryOnline bool                   `json:"library_online"`
+	Folders       []FolderNode           `json:"folders"`
+	Series        []mediapkg.SeriesGroup `json:"series,omitempty"` // Shows among the root media
+	Media         []storage.MediaItem    `json:"media,omitempty"`
 }
 
 type FolderNode struct {
-	ID         string              `json:"id"`
-	Name       string              `json:"name"`
-	SubFolders []FolderNode        `json:"sub_folders,omitempty"`
-	Media      []storage.MediaItem `json:"media,omitempty"`
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name"`
+	IsSeries   bool                   `json:"is_series"`
+	Stats      storage.FolderStats    `json:"stats"` // Totals including subfolders
+	SubFolders []FolderNode           `json:"sub_folders,omitempty"`
+	Series     []mediapkg.SeriesGroup `json:"series,omitempty"` // Shows among the media of a non-series folder
+	Media      []storage.MediaItem    `json:"media,omitempty"`
 }
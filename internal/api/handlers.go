@@ -1,350 +1,2888 @@
 package api
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
-	"rvcinemaview/internal/media"
+	"rvcinemaview/internal/auth"
+	"rvcinemaview/internal/config"
+	"rvcinemaview/internal/events"
+	mediapkg "rvcinemaview/internal/media"
 	"rvcinemaview/internal/storage"
 	"rvcinemaview/internal/streaming"
 )
 
 const Version = "0.1.0"
 
+// maxBatchPlaybackIDs caps the number of media IDs per batch playback request
+const maxBatchPlaybackIDs = 500
+
+// maxPrewarmIDs caps the number of media IDs per thumbnail prewarm request
+const maxPrewarmIDs = 500
+
+// maxShareTTL caps the lifetime of signed share links
+const maxShareTTL = 7 * 24 * time.Hour
+
+// libraryStatsTTL is how long library stats are reused before they are
+// computed again, so clients polling the endpoint don't each hit the database
+const libraryStatsTTL = 10 * time.Second
+
 type Handler struct {
+	cfg              *config.Config
 	storage          *storage.SQLiteStorage
 	logger           zerolog.Logger
 	scanner          ScannerInterface
 	streamer         *streaming.Handler
-	thumbnailService *media.ThumbnailService
+	hls              *streaming.HLSManager
+	thumbnailService *mediapkg.ThumbnailService
+	subtitles        *mediapkg.SubtitleExtractor
+	posters          *mediapkg.PosterStore
+	events           *events.Bus
+	library          *mediapkg.LibraryMonitor
 	libraryPath      string
 	libraryName      string
+	camelCase        bool // rewrite JSON keys to camelCase, see marshalJSON
+
+	statsMu sync.Mutex
+	stats   *storage.LibraryStats
+	statsAt time.Time
 }
 
 type ScannerInterface interface {
 	ScanPath(path, name string) error
+	RescanFile(path, folderID string) (*storage.MediaItem, error)
 	IsScanning() bool
+	Progress() mediapkg.ScanProgress
+	LastResult() *mediapkg.ScanSummary
 }
 
-func NewHandler(store *storage.SQLiteStorage, logger zerolog.Logger, libraryPath, libraryName string) *Handler {
+func NewHandler(cfg *config.Config, store *storage.SQLiteStorage, logger zerolog.Logger) *Handler {
 	return &Handler{
+		cfg:         cfg,
+		camelCase:   cfg.Server.JSONCase == JSONCaseCamel,
 		storage:     store,
 		logger:      logger,
-		streamer:    streaming.NewHandler(),
-		libraryPath: libraryPath,
-		libraryName: libraryName,
+		streamer:    streaming.NewHandler(cfg.Server.MaxConcurrentStreams, logger),
+		libraryPath: cfg.Library.Path,
+		libraryName: cfg.Library.Name,
 	}
 }
 
-func (h *Handler) SetThumbnailService(service *media.ThumbnailService) {
+func (h *Handler) SetThumbnailService(service *mediapkg.ThumbnailService) {
 	h.thumbnailService = service
 }
 
+func (h *Handler) SetSubtitleExtractor(extractor *mediapkg.SubtitleExtractor) {
+	h.subtitles = extractor
+}
+
+func (h *Handler) SetPosterStore(store *mediapkg.PosterStore) {
+	h.posters = store
+}
+
+func (h *Handler) SetHLSManager(manager *streaming.HLSManager) {
+	h.hls = manager
+}
+
 func (h *Handler) SetScanner(scanner ScannerInterface) {
 	h.scanner = scanner
 }
 
+// SetEventBus publishes playback_updated events to the bus whenever the
+// storage changes a playback state
+func (h *Handler) SetEventBus(bus *events.Bus) {
+	h.events = bus
+	h.storage.SetPlaybackHook(h.publishPlayback)
+}
+
+func (h *Handler) SetLibraryMonitor(monitor *mediapkg.LibraryMonitor) {
+	h.library = monitor
+}
+
+// healthPingTimeout bounds the database check of the health endpoint
+const healthPingTimeout = 2 * time.Second
+
+// Health reports the status of the server and its dependencies. A failing
+// database makes the server unusable and returns 503; missing ffmpeg,
+// ffprobe or an unreachable library only mark it degraded.
 func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthPingTimeout)
+	defer cancel()
+
+	checks := map[string]HealthCheck{
+		"database": healthCheck(h.storage.Ping(ctx)),
+		"ffmpeg":   healthCheck(lookPath("ffmpeg")),
+		"ffprobe":  healthCheck(lookPath("ffprobe")),
+		"library":  healthCheck(checkLibraryPath(h.libraryPath)),
+	}
+
 	resp := HealthResponse{
-		Status:  "ok",
-		Version: Version,
+		Status:        "ok",
+		Version:       Version,
+		ActiveStreams: h.streamer.ActiveStreams(),
+		LibraryOnline: h.libraryOnline(),
+		Checks:        checks,
+	}
+	for _, check := range checks {
+		if check.Error != "" {
+			resp.Status = "degraded"
+		}
+	}
+
+	status := http.StatusOK
+	if checks["database"].Error != "" {
+		resp.Status = "error"
+		status = http.StatusServiceUnavailable
 	}
-	writeJSON(w, http.StatusOK, resp)
+	h.writeJSON(w, status, resp)
 }
 
-func (h *Handler) ScanLibrary(w http.ResponseWriter, r *http.Request) {
-	if h.scanner == nil {
-		writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Scanner not initialized")
-		return
+func healthCheck(err error) HealthCheck {
+	if err != nil {
+		return HealthCheck{Status: "error", Error: err.Error()}
 	}
+	return HealthCheck{Status: "ok"}
+}
 
-	if h.scanner.IsScanning() {
-		writeJSON(w, http.StatusOK, ScanResponse{
-			Status:  "in_progress",
-			Message: "Scan already in progress",
-		})
+func lookPath(name string) error {
+	_, err := exec.LookPath(name)
+	return err
+}
+
+// checkLibraryPath verifies the library directory exists and can be listed
+func checkLibraryPath(path string) error {
+	if path == "" {
+		return fmt.Errorf("no library path configured")
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	_, err = dir.Readdirnames(1)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func (h *Handler) ScanLibrary(w http.ResponseWriter, r *http.Request) {
+	if h.scanner == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Scanner not initialized")
 		return
 	}
 
 	if h.libraryPath == "" {
-		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "No library path configured")
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "No library path configured")
 		return
 	}
 
+	// A request during a scan queues one rescan after it
+	scanning := h.scanner.IsScanning()
+
 	go func() {
 		if err := h.scanner.ScanPath(h.libraryPath, h.libraryName); err != nil {
 			h.logger.Error().Err(err).Msg("scan failed")
 		}
 	}()
 
-	writeJSON(w, http.StatusAccepted, ScanResponse{
+	if scanning {
+		h.writeJSON(w, http.StatusAccepted, ScanResponse{
+			Status:  "queued",
+			Message: "Scan in progress, rescan queued",
+		})
+		return
+	}
+
+	h.writeJSON(w, http.StatusAccepted, ScanResponse{
 		Status:  "started",
 		Message: "Library scan started",
 	})
 }
 
+// GetScanStatus reports whether a scan is running and how far it has got
+func (h *Handler) GetScanStatus(w http.ResponseWriter, r *http.Request) {
+	if h.scanner == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Scanner not initialized")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, h.scanner.Progress())
+}
+
+// GetScanResult returns the summary of the last finished library scan
+func (h *Handler) GetScanResult(w http.ResponseWriter, r *http.Request) {
+	if h.scanner == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Scanner not initialized")
+		return
+	}
+
+	result := h.scanner.LastResult()
+	if result == nil {
+		h.writeError(w, http.StatusNotFound, "SCAN_NOT_FOUND", "No scan has finished yet")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, result)
+}
+
+// GetLibraryStats returns library-wide totals, cached for libraryStatsTTL
+func (h *Handler) GetLibraryStats(w http.ResponseWriter, r *http.Request) {
+	h.statsMu.Lock()
+	defer h.statsMu.Unlock()
+
+	if h.stats == nil || time.Since(h.statsAt) > libraryStatsTTL {
+		stats, err := h.storage.GetLibraryStats()
+		if err != nil {
+			h.logger.Error().Err(err).Msg("failed to get library stats")
+			h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get library stats")
+			return
+		}
+		h.stats = stats
+		h.statsAt = time.Now()
+	}
+
+	h.writeJSON(w, http.StatusOK, h.stats)
+}
+
+// GetAllMedia returns a page of all media regardless of folder.
+// Query params: limit, offset (see readPage), sort (title, date_added,
+// date_modified, size) and order (asc, desc).
+func (h *Handler) GetAllMedia(w http.ResponseWriter, r *http.Request) {
+	page, ok := h.readPage(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	items, total, err := h.storage.GetAllMedia(page.Limit, page.Offset, q.Get("sort"), q.Get("order"))
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to list media")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to list media")
+		return
+	}
+
+	if items == nil {
+		items = []storage.MediaItem{}
+	}
+
+	h.writeJSON(w, http.StatusOK, MediaListResponse{
+		Media:  items,
+		Total:  total,
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	})
+}
+
 func (h *Handler) GetMedia(w http.ResponseWriter, r *http.Request) {
 	mediaID := chi.URLParam(r, "id")
 
 	media, err := h.storage.GetMediaItem(mediaID)
 	if err != nil {
 		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media")
-		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
 		return
 	}
 
 	if media == nil {
-		writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, MediaResponse{
-		Media:     media,
-		StreamURL: "/api/v1/media/" + mediaID + "/stream",
+	h.writeJSON(w, http.StatusOK, MediaResponse{
+		Media:        media,
+		StreamURL:    "/api/v1/media/" + mediaID + "/stream",
+		ThumbnailURL: h.thumbnailURL(media),
+		IsWatched:    h.mediaWatched(mediaID),
+		IsFavorite:   h.mediaFavorite(mediaID),
+		Artwork:      h.artworkURLs(media),
 	})
 }
 
+// StreamMedia serves the media file. ?audio=transcode converts the audio to
+// AAC, which disables seeking via Range requests. ?download=true serves the
+// original file as an attachment named after the title.
 func (h *Handler) StreamMedia(w http.ResponseWriter, r *http.Request) {
-	mediaID := chi.URLParam(r, "id")
-
-	media, err := h.storage.GetMediaItem(mediaID)
-	if err != nil {
-		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for streaming")
-		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+	// Direct byte streaming always carries the file's default audio track
+	audio := r.URL.Query().Get("audio")
+	if audio != "" && audio != "transcode" {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Audio track selection requires stream.mp4 or stream.mkv")
 		return
 	}
 
+	media := h.streamTarget(w, r)
 	if media == nil {
-		writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	// Downloads get the file as stored, never a remux or transcode
+	if r.URL.Query().Get("download") == "true" {
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": downloadFilename(media)})
+		w.Header().Set("Content-Disposition", disposition)
+		h.streamer.ServeFile(w, r, media.Path)
+		return
+	}
+
+	// Browsers download MKV/AVI instead of playing it; with remux_mkv such
+	// files are repackaged as MP4 when their codecs play as is, at the
+	// cost of Range seeking
+	if h.cfg.Server.RemuxMKV && streaming.CanRemux(media.Path, media.VideoCodec, media.AudioCodec) {
+		h.streamer.ServeRemuxed(w, r, media.Path)
+		return
+	}
+
+	// Video plays but the audio would be silent; with transcode_audio such
+	// files get AAC audio, again without Range seeking
+	if audio == "transcode" ||
+		(h.cfg.Server.TranscodeAudio && streaming.NeedsAudioTranscode(media.Path, media.VideoCodec, media.AudioCodec)) {
+		h.streamer.ServeAudioTranscoded(w, r, media.Path)
 		return
 	}
 
 	h.streamer.ServeFile(w, r, media.Path)
 }
 
-func (h *Handler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
-	mediaID := chi.URLParam(r, "id")
+// downloadFilename names a downloaded media file after its title with the
+// file's extension. Path separators, control characters and quotes are
+// dropped so the name is safe to save as is.
+func downloadFilename(media *storage.MediaItem) string {
+	sanitize := func(name string) string {
+		name = strings.Map(func(r rune) rune {
+			if r == '/' || r == '\\' || r == '"' || unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, name)
+		return strings.Trim(name, " .")
+	}
 
-	h.logger.Info().Str("id", mediaID).Msg("thumbnail requested")
+	ext := filepath.Ext(media.Path)
+	name := sanitize(media.Title)
+	if name == "" {
+		name = sanitize(strings.TrimSuffix(filepath.Base(media.Path), ext))
+	}
+	if name == "" {
+		name = media.ID
+	}
+	return name + ext
+}
 
-	if h.thumbnailService == nil {
-		h.logger.Warn().Msg("thumbnail service is nil")
-		writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
-		return
+// StreamMediaAs serves the stream.mp4 / stream.mkv aliases. When the source
+// is in a different container it is remuxed rather than mislabelled.
+// ?audio=<index> picks an audio stream (0-based among audio streams), which
+// forces a remux and so disables seeking via Range requests.
+func (h *Handler) StreamMediaAs(container string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		media := h.streamTarget(w, r)
+		if media == nil {
+			return
+		}
+
+		audioTrack := -1
+		if v := r.URL.Query().Get("audio"); v != "" {
+			index, err := strconv.Atoi(v)
+			if err != nil || index < 0 || (media.AudioTracks != nil && index >= *media.AudioTracks) {
+				h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Invalid audio track")
+				return
+			}
+			audioTrack = index
+		}
+
+		h.streamer.ServeAs(w, r, media.Path, container, audioTrack, media.VideoCodec)
 	}
+}
 
-	data, err := h.thumbnailService.GetThumbnail(mediaID)
-	if err != nil {
-		h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to get thumbnail")
-		writeError(w, http.StatusNotFound, "THUMBNAIL_NOT_FOUND", "Thumbnail not available")
+// GetHLSPlaylist serves an HLS playlist for browsers that can't play the
+// file directly. ffmpeg is started on the first request; browser-safe
+// streams are copied and the rest transcoded to H.264/AAC.
+func (h *Handler) GetHLSPlaylist(w http.ResponseWriter, r *http.Request) {
+	if h.hls == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "HLS not available")
 		return
 	}
 
-	h.logger.Info().Str("id", mediaID).Int("size", len(data)).Msg("thumbnail served")
+	media := h.streamTarget(w, r)
+	if media == nil {
+		return
+	}
 
-	w.Header().Set("Content-Type", "image/jpeg")
-	w.Header().Set("Cache-Control", "public, max-age=86400") // Cache for 24 hours
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
+	h.hls.ServePlaylist(w, r, media.ID, media.Path, media.VideoCodec, media.AudioCodec, segmentQuery(r))
 }
 
-func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
+// segmentQuery returns the credentials of a playlist request for the segment
+// URLs, so players that can't set headers can fetch the segments too
+func segmentQuery(r *http.Request) string {
+	query := url.Values{}
+	for _, name := range []string{"token", "expires", "api_key"} {
+		if value := r.URL.Query().Get(name); value != "" {
+			query.Set(name, value)
+		}
+	}
+	return query.Encode()
 }
 
-func writeError(w http.ResponseWriter, status int, code, message string) {
-	writeJSON(w, status, ErrorResponse{
-		Error: ErrorDetail{
-			Code:    code,
-			Message: message,
-		},
-	})
+// GetHLSSegment serves a segment of a running HLS session. Segment URLs carry
+// the share token or API key of the playlist request.
+func (h *Handler) GetHLSSegment(w http.ResponseWriter, r *http.Request) {
+	if h.hls == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "HLS not available")
+		return
+	}
+
+	name := chi.URLParam(r, "segment")
+	index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "segment"), ".ts"))
+	if err != nil || index < 0 || !strings.HasPrefix(name, "segment") || !strings.HasSuffix(name, ".ts") {
+		h.writeError(w, http.StatusNotFound, "FILE_NOT_FOUND", "Segment not found")
+		return
+	}
+
+	if !h.checkShare(w, r) {
+		return
+	}
+
+	h.hls.ServeSegment(w, r, chi.URLParam(r, "id"), index)
 }
 
-// Playback handlers
+// checkShare verifies the share token of a request, if it carries one.
+// Writes the error response and returns false on failure.
+func (h *Handler) checkShare(w http.ResponseWriter, r *http.Request) bool {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return true
+	}
+	if h.cfg.Auth.ShareSecret == "" {
+		h.writeError(w, http.StatusForbidden, "FORBIDDEN", "Sharing is disabled")
+		return false
+	}
+	if err := auth.VerifyShare(h.cfg.Auth.ShareSecret, chi.URLParam(r, "id"), token, r.URL.Query().Get("expires")); err != nil {
+		h.writeError(w, http.StatusForbidden, "FORBIDDEN", err.Error())
+		return false
+	}
+	return true
+}
 
-func (h *Handler) SavePlaybackPosition(w http.ResponseWriter, r *http.Request) {
+// streamTarget checks share tokens and loads the media item to stream.
+// Writes the error response and returns nil on failure.
+func (h *Handler) streamTarget(w http.ResponseWriter, r *http.Request) *storage.MediaItem {
 	mediaID := chi.URLParam(r, "id")
 
-	// Check if media exists
+	// Signed share links carry a token and expiry
+	if !h.checkShare(w, r) {
+		return nil
+	}
+
 	media, err := h.storage.GetMediaItem(mediaID)
 	if err != nil {
-		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for playback")
-		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
-		return
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for streaming")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return nil
 	}
 
 	if media == nil {
-		writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
-		return
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return nil
 	}
 
-	var req SavePlaybackRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Invalid request body")
-		return
+	// A missing file on an unreachable library is a storage problem, not a
+	// 404. Re-probe right away rather than trusting the last periodic check.
+	if h.library != nil {
+		if _, err := os.Stat(media.Path); err != nil || !h.library.Online() {
+			if !h.library.Check() {
+				h.writeError(w, http.StatusServiceUnavailable, "LIBRARY_OFFLINE", "Library storage is unavailable")
+				return nil
+			}
+		}
 	}
 
-	// Validate
-	if req.Duration <= 0 {
-		writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Duration must be positive")
+	return media
+}
+
+// libraryOnline reports the library monitor state (online when not monitored)
+func (h *Handler) libraryOnline() bool {
+	return h.library == nil || h.library.Online()
+}
+
+// RescanMedia re-evaluates a single file: size and mtime are refreshed and
+// metadata and thumbnail are regenerated. If the file has been removed the
+// media item is deleted and 404 is returned.
+func (h *Handler) RescanMedia(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	if h.scanner == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Scanner not initialized")
 		return
 	}
 
-	if req.Position < 0 {
-		req.Position = 0
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for rescan")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
 	}
 
-	if req.Position > req.Duration {
-		req.Position = req.Duration
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
 	}
 
-	// Calculate progress
-	progress := float64(req.Position) / float64(req.Duration)
+	if !h.withinLibrary(media.Path) {
+		h.writeError(w, http.StatusForbidden, "FORBIDDEN", "Media is outside the library")
+		return
+	}
 
-	state := &storage.PlaybackState{
-		MediaID:  mediaID,
-		Position: req.Position,
-		Duration: req.Duration,
-		Progress: progress,
+	media, err = h.scanner.RescanFile(media.Path, media.FolderID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to rescan media")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to rescan media")
+		return
 	}
 
-	if err := h.storage.SavePlaybackState(state); err != nil {
-		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to save playback state")
-		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to save position")
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media file no longer exists")
 		return
 	}
 
-	h.logger.Debug().
-		Str("media_id", mediaID).
-		Int64("position", req.Position).
-		Float64("progress", progress).
-		Msg("playback position saved")
+	if h.thumbnailService != nil {
+		h.thumbnailService.RemoveThumbnail(mediaID)
+		if err := h.thumbnailService.ProcessMediaItem(r.Context(), media); err != nil {
+			h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to process rescanned media")
+		}
+		if updated, err := h.storage.GetMediaItem(mediaID); err == nil && updated != nil {
+			media = updated
+		}
+	}
 
-	writeJSON(w, http.StatusOK, PlaybackResponse{
-		MediaID:  mediaID,
-		Position: req.Position,
-		Duration: req.Duration,
-		Progress: progress,
+	h.writeJSON(w, http.StatusOK, MediaResponse{
+		Media:        media,
+		StreamURL:    "/api/v1/media/" + mediaID + "/stream",
+		ThumbnailURL: h.thumbnailURL(media),
+		IsWatched:    h.mediaWatched(mediaID),
+		IsFavorite:   h.mediaFavorite(mediaID),
+		Artwork:      h.artworkURLs(media),
 	})
 }
 
-func (h *Handler) GetPlaybackPosition(w http.ResponseWriter, r *http.Request) {
+// DeleteMedia removes a media item along with its playback state, tags,
+// favorite, thumbnail, poster and cached subtitles. With ?remove_file=true the
+// video file is deleted from disk as well, which is only allowed inside the
+// library path. Without it the file stays, so the next scan adds it back
+// under the same ID, as a new item without any of that state.
+func (h *Handler) DeleteMedia(w http.ResponseWriter, r *http.Request) {
 	mediaID := chi.URLParam(r, "id")
+	removeFile := r.URL.Query().Get("remove_file") == "true"
 
-	state, err := h.storage.GetPlaybackState(mediaID)
+	media, err := h.storage.GetMediaItem(mediaID)
 	if err != nil {
-		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get playback state")
-		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get position")
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for delete")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
 		return
 	}
 
-	if state == nil {
-		// No saved position, return zeros
-		writeJSON(w, http.StatusOK, PlaybackResponse{
-			MediaID:  mediaID,
-			Position: 0,
-			Duration: 0,
-			Progress: 0,
-		})
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, PlaybackResponse{
-		MediaID:  state.MediaID,
-		Position: state.Position,
-		Duration: state.Duration,
-		Progress: state.Progress,
-	})
-}
+	// Remove the file first so a failure leaves the library unchanged
+	fileRemoved := false
+	if removeFile {
+		if !h.withinLibrary(media.Path) {
+			h.writeError(w, http.StatusForbidden, "FORBIDDEN", "Media is outside the library")
+			return
+		}
+		if err := os.Remove(media.Path); err != nil && !os.IsNotExist(err) {
+			h.logger.Error().Err(err).Str("id", mediaID).Str("path", media.Path).Msg("failed to remove media file")
+			h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to remove media file")
+			return
+		}
+		fileRemoved = true
+	}
 
-func (h *Handler) GetContinueWatching(w http.ResponseWriter, r *http.Request) {
-	items, err := h.storage.GetContinueWatching(20) // Limit to 20 items
-	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to get continue watching")
-		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get continue watching")
+	if err := h.storage.DeleteMediaItem(mediaID); err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to delete media")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to delete media")
 		return
 	}
 
-	if items == nil {
-		items = []storage.ContinueWatchingItem{}
+	detail := "removed from the library"
+	if fileRemoved {
+		detail = "removed from the library and deleted from disk"
+	}
+	if err := h.storage.AppendAudit(storage.AuditMediaDeleted, media.Path, detail); err != nil {
+		h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to write audit log")
 	}
 
-	writeJSON(w, http.StatusOK, ContinueWatchingResponse{
-		Items: items,
+	if h.thumbnailService != nil {
+		h.thumbnailService.RemoveThumbnail(mediaID)
+	}
+	if h.subtitles != nil {
+		if err := h.subtitles.Delete(mediaID); err != nil && !os.IsNotExist(err) {
+			h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to delete cached subtitles")
+		}
+	}
+	if h.posters != nil {
+		if err := h.posters.Delete(mediaID); err != nil {
+			h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to delete poster")
+		}
+	}
+
+	h.logger.Info().Str("id", mediaID).Str("path", media.Path).Bool("file_removed", fileRemoved).Msg("media deleted")
+
+	h.writeJSON(w, http.StatusOK, DeleteMediaResponse{
+		MediaID:     mediaID,
+		Deleted:     true,
+		FileRemoved: fileRemoved,
 	})
 }
 
-// GetLibraryTree returns the complete library structure in one response
-func (h *Handler) GetLibraryTree(w http.ResponseWriter, r *http.Request) {
-	// Get all root folders
-	rootFolders, err := h.storage.GetRootFolders()
+// mediaWatched reports whether a media item's saved progress counts as watched
+func (h *Handler) mediaWatched(mediaID string) bool {
+	state, err := h.storage.GetPlaybackState(mediaID)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("failed to get root folders")
-		writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get library")
-		return
+		h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to get playback state for media")
+		return false
 	}
+	return state != nil && h.isWatched(*state)
+}
 
-	// Get root-level media (media in the library root directory)
-	rootMedia, err := h.storage.GetRootMedia()
+// mediaFavorite reports whether a media item is a favorite, logging lookup
+// failures as not a favorite
+func (h *Handler) mediaFavorite(mediaID string) bool {
+	favorite, err := h.storage.IsFavorite(mediaID)
 	if err != nil {
-		h.logger.Warn().Err(err).Msg("failed to get root media")
-		rootMedia = []storage.MediaItem{}
+		h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to get favorite state for media")
+		return false
 	}
+	return favorite
+}
 
-	// Build tree recursively
-	var folderNodes []FolderNode
-	for _, folder := range rootFolders {
-		node := h.buildFolderNode(folder)
-		folderNodes = append(folderNodes, node)
+// withinLibrary reports whether path lies inside the configured library root
+func (h *Handler) withinLibrary(path string) bool {
+	if h.libraryPath == "" {
+		return false
 	}
+	rel, err := filepath.Rel(filepath.Clean(h.libraryPath), filepath.Clean(path))
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
 
-	// If there's exactly one root folder and no root media,
-	// return the contents of that folder directly (unwrap it)
-	// This provides a better UX - user sees content immediately
-	if len(folderNodes) == 1 && len(rootMedia) == 0 {
-		singleFolder := folderNodes[0]
-		writeJSON(w, http.StatusOK, LibraryTreeResponse{
-			Name:    h.libraryName,
-			Folders: singleFolder.SubFolders,
-			Media:   singleFolder.Media,
-		})
+// GetChecksum returns the size and hash of the original file so clients
+// can verify a completed download. ?algo= picks sha256 (default) or md5.
+// The hash is computed lazily and cached until the file's size or mtime
+// changes.
+func (h *Handler) GetChecksum(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	algorithm := r.URL.Query().Get("algo")
+	if algorithm == "" {
+		algorithm = mediapkg.ChecksumSHA256
+	}
+	if !mediapkg.IsChecksumAlgorithm(algorithm) {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "algo must be sha256 or md5")
 		return
 	}
 
-	if folderNodes == nil {
-		folderNodes = []FolderNode{}
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for checksum")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
 	}
 
-	writeJSON(w, http.StatusOK, LibraryTreeResponse{
-		Name:    h.libraryName,
-		Folders: folderNodes,
-		Media:   rootMedia,
-	})
-}
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
 
-func (h *Handler) buildFolderNode(folder storage.Folder) FolderNode {
-	node := FolderNode{
-		ID:   folder.ID,
-		Name: folder.Name,
+	info, err := os.Stat(media.Path)
+	if err != nil {
+		h.writeError(w, http.StatusNotFound, "FILE_NOT_FOUND", "Media file not found on disk")
+		return
 	}
 
-	// Get subfolders
-	subFolders, err := h.storage.GetSubFolders(folder.ID)
-	if err == nil && len(subFolders) > 0 {
-		for _, sub := range subFolders {
-			subNode := h.buildFolderNode(sub)
-			node.SubFolders = append(node.SubFolders, subNode)
+	checksum, size, mtime, err := h.storage.GetMediaChecksum(mediaID, algorithm)
+	if err != nil {
+		h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to read cached checksum")
+	}
+
+	if checksum == "" || size != info.Size() || !mtime.Equal(info.ModTime()) {
+		start := time.Now()
+		checksum, err = mediapkg.FileChecksum(media.Path, algorithm)
+		if err != nil {
+			h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to compute checksum")
+			h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to compute checksum")
+			return
+		}
+
+		h.logger.Debug().
+			Str("id", mediaID).
+			Str("algorithm", algorithm).
+			Dur("duration", time.Since(start)).
+			Msg("checksum computed")
+
+		if err := h.storage.SetMediaChecksum(mediaID, algorithm, checksum, info.Size(), info.ModTime()); err != nil {
+			h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to cache checksum")
+		}
+	}
+
+	h.writeJSON(w, http.StatusOK, ChecksumResponse{
+		MediaID:   mediaID,
+		Size:      info.Size(),
+		Algorithm: algorithm,
+		Checksum:  checksum,
+	})
+}
+
+// SetMediaMarkers manually sets the intro skip markers of a media item.
+// Manual markers always win over ones detected from chapter titles.
+func (h *Handler) SetMediaMarkers(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for markers")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	var req IntroMarkersRequest
+	if !h.readJSON(w, r, &req) {
+		return
+	}
+
+	if (req.IntroStart == nil) != (req.IntroEnd == nil) {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "intro_start and intro_end must be set together")
+		return
+	}
+
+	if req.IntroStart != nil {
+		if *req.IntroStart < 0 || *req.IntroEnd <= *req.IntroStart {
+			h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "intro_end must be after intro_start")
+			return
+		}
+		if media.Duration != nil && *media.Duration > 0 && *req.IntroEnd > float64(*media.Duration) {
+			h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "intro_end is past the end of the media")
+			return
+		}
+	}
+
+	if err := h.storage.SetIntroMarkers(mediaID, req.IntroStart, req.IntroEnd); err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to save intro markers")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to save markers")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, IntroMarkersResponse{
+		MediaID:    mediaID,
+		IntroStart: req.IntroStart,
+		IntroEnd:   req.IntroEnd,
+	})
+}
+
+// AddMediaTags adds the tags in the request body to a media item
+func (h *Handler) AddMediaTags(w http.ResponseWriter, r *http.Request) {
+	h.updateMediaTags(w, r, h.storage.AddTag)
+}
+
+// RemoveMediaTags removes the tags in the request body from a media item
+func (h *Handler) RemoveMediaTags(w http.ResponseWriter, r *http.Request) {
+	h.updateMediaTags(w, r, h.storage.RemoveTag)
+}
+
+// updateMediaTags applies update to each requested tag and responds with
+// the media item's tags afterwards
+func (h *Handler) updateMediaTags(w http.ResponseWriter, r *http.Request, update func(mediaID, tag string) error) {
+	mediaID := chi.URLParam(r, "id")
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for tags")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	var req MediaTagsRequest
+	if !h.readJSON(w, r, &req) {
+		return
+	}
+
+	if len(req.Tags) == 0 {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "tags is required")
+		return
+	}
+	for _, tag := range req.Tags {
+		if storage.NormalizeTag(tag) == "" {
+			h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Tags must not be empty")
+			return
+		}
+	}
+
+	for _, tag := range req.Tags {
+		if err := update(mediaID, tag); err != nil {
+			h.logger.Error().Err(err).Str("id", mediaID).Str("tag", tag).Msg("failed to update media tags")
+			h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update tags")
+			return
+		}
+	}
+
+	media, err = h.storage.GetMediaItem(mediaID)
+	if err != nil || media == nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media after updating tags")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	tags := media.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	h.writeJSON(w, http.StatusOK, MediaTagsResponse{
+		MediaID: mediaID,
+		Tags:    tags,
+	})
+}
+
+// GetTagMedia returns a page of the media with a tag, ordered by title
+func (h *Handler) GetTagMedia(w http.ResponseWriter, r *http.Request) {
+	tag := storage.NormalizeTag(chi.URLParam(r, "tag"))
+
+	page, ok := h.readPage(w, r)
+	if !ok {
+		return
+	}
+
+	items, total, err := h.storage.GetMediaByTag(tag, page.Limit, page.Offset)
+	if err != nil {
+		h.logger.Error().Err(err).Str("tag", tag).Msg("failed to get media by tag")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get tagged media")
+		return
+	}
+
+	if items == nil {
+		items = []storage.MediaItem{}
+	}
+
+	h.writeJSON(w, http.StatusOK, TagMediaResponse{
+		Tag:    tag,
+		Media:  items,
+		Total:  total,
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	})
+}
+
+// ShareMedia returns a signed, expiring stream URL for a media item.
+// The lifetime can be set with ?ttl= (Go duration), capped at 7 days.
+// Like every endpoint it needs the API key; the returned URL doesn't.
+func (h *Handler) ShareMedia(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	if h.cfg.Auth.ShareSecret == "" {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Sharing is not configured")
+		return
+	}
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for sharing")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	ttl := h.cfg.Auth.ShareTTL
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		parsed, err := time.ParseDuration(v)
+		if err != nil || parsed <= 0 {
+			h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Invalid ttl")
+			return
+		}
+		ttl = parsed
+	}
+	if ttl > maxShareTTL {
+		ttl = maxShareTTL
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	expires := expiresAt.Unix()
+	token := auth.SignShare(h.cfg.Auth.ShareSecret, mediaID, expires)
+
+	h.writeJSON(w, http.StatusOK, ShareResponse{
+		URL:       fmt.Sprintf("/api/v1/media/%s/stream?token=%s&expires=%d", mediaID, token, expires),
+		ExpiresAt: expiresAt.UTC(),
+	})
+}
+
+func (h *Handler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	h.logger.Info().Str("id", mediaID).Msg("thumbnail requested")
+
+	if h.thumbnailService == nil {
+		h.logger.Warn().Msg("thumbnail service is nil")
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return
+	}
+
+	// A ?v= other than the current version means the client saw a newer
+	// thumbnail than the one held in memory
+	requested := r.URL.Query().Get("v")
+	if requested != "" && requested != strconv.Itoa(h.thumbnailService.Version(mediaID)) {
+		h.thumbnailService.Evict(mediaID)
+	}
+
+	data, err := h.thumbnailService.GetThumbnail(mediaID)
+	if errors.Is(err, mediapkg.ErrBusy) {
+		h.writeThumbnailsBusy(w)
+		return
+	}
+	if err != nil {
+		h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to get thumbnail")
+		h.writeError(w, http.StatusNotFound, "THUMBNAIL_NOT_FOUND", "Thumbnail not available")
+		return
+	}
+
+	h.logger.Info().Str("id", mediaID).Int("size", len(data)).Msg("thumbnail served")
+
+	// Versioned URLs never change content; If-None-Match is answered with
+	// 304 Not Modified
+	if requested != "" && requested == strconv.Itoa(h.thumbnailService.Version(mediaID)) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400") // Cache for 24 hours
+	}
+	h.serveThumbnail(w, r, mediaID, mediaID, data)
+}
+
+// serveThumbnail serves the JPEG thumbnail data of a media item as name,
+// or its WebP copy when WebP is enabled and the client accepts it. JPEG is
+// served whenever the WebP copy can't be had.
+func (h *Handler) serveThumbnail(w http.ResponseWriter, r *http.Request, name, mediaID string, data []byte) {
+	etag := h.thumbnailService.ETag(mediaID, data)
+	ext, contentType := ".jpg", "image/jpeg"
+
+	if h.thumbnailService.WebPEnabled() {
+		// The same URL serves either format, caches must key on Accept
+		w.Header().Add("Vary", "Accept")
+		if strings.Contains(r.Header.Get("Accept"), "image/webp") {
+			webp, err := h.thumbnailService.WebP(mediaID, data)
+			if err == nil {
+				data = webp
+				ext, contentType = ".webp", "image/webp"
+				etag = strings.TrimSuffix(etag, `"`) + `-webp"`
+			} else if !errors.Is(err, mediapkg.ErrWebPUnsupported) {
+				h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to get WebP thumbnail, serving JPEG")
+			}
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	h.streamer.ServeCachedContent(w, r, name+ext, time.Time{}, bytes.NewReader(data), contentType)
+}
+
+// GetSpriteImage serves the scrubbing preview sprite sheet, generating it
+// on first request
+func (h *Handler) GetSpriteImage(w http.ResponseWriter, r *http.Request) {
+	imagePath, _, ok := h.sprite(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	h.streamer.ServeCachedFile(w, r, imagePath, "image/jpeg")
+}
+
+// GetSpriteVTT serves the WebVTT cues mapping time ranges to sprite tiles
+func (h *Handler) GetSpriteVTT(w http.ResponseWriter, r *http.Request) {
+	_, vttPath, ok := h.sprite(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	h.streamer.ServeCachedFile(w, r, vttPath, "text/vtt; charset=utf-8")
+}
+
+// sprite looks up the media item and returns its sprite files.
+// Writes the error response and returns false on failure.
+func (h *Handler) sprite(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	mediaID := chi.URLParam(r, "id")
+
+	if h.thumbnailService == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return "", "", false
+	}
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return "", "", false
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return "", "", false
+	}
+
+	imagePath, vttPath, err := h.thumbnailService.Sprite(media)
+	if errors.Is(err, mediapkg.ErrBusy) {
+		h.writeThumbnailsBusy(w)
+		return "", "", false
+	}
+	if err != nil {
+		h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to get sprite")
+		h.writeError(w, http.StatusNotFound, "THUMBNAIL_NOT_FOUND", "Sprite not available")
+		return "", "", false
+	}
+
+	return imagePath, vttPath, true
+}
+
+// frameCacheAge is how long clients may cache extracted frames
+const frameCacheAge = time.Hour
+
+// GetFrame serves the frame at ?t= seconds as a JPEG, scaled down to ?w=
+// pixels wide (default and maximum MaxFrameWidth). Positions past the end
+// are clamped to the last second.
+func (h *Handler) GetFrame(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	if h.thumbnailService == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return
+	}
+
+	seconds, err := strconv.ParseFloat(r.URL.Query().Get("t"), 64)
+	if err != nil || seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "t must be a non-negative number of seconds")
+		return
+	}
+
+	width := mediapkg.MaxFrameWidth
+	if v := r.URL.Query().Get("w"); v != "" {
+		width, err = strconv.Atoi(v)
+		if err != nil || width <= 0 {
+			h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "w must be a positive number of pixels")
+			return
+		}
+		width = min(width, mediapkg.MaxFrameWidth)
+	}
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	framePath, err := h.thumbnailService.Frame(media, seconds, width)
+	if errors.Is(err, mediapkg.ErrBusy) {
+		h.writeThumbnailsBusy(w)
+		return
+	}
+	if err != nil {
+		h.logger.Warn().Err(err).Str("id", mediaID).Float64("t", seconds).Msg("failed to extract frame")
+		h.writeError(w, http.StatusNotFound, "THUMBNAIL_NOT_FOUND", "Frame not available")
+		return
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(frameCacheAge.Seconds())))
+	h.streamer.ServeCachedFile(w, r, framePath, "image/jpeg")
+}
+
+// writeThumbnailsBusy answers 503 when no ffmpeg slot frees up in time
+func (h *Handler) writeThumbnailsBusy(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "5")
+	h.writeError(w, http.StatusServiceUnavailable, "THUMBNAILS_BUSY", "Too many thumbnails being generated, retry later")
+}
+
+// UpdateFolder changes folder settings. Setting is_series overrides the
+// flag inferred from episode filenames during scans.
+func (h *Handler) UpdateFolder(w http.ResponseWriter, r *http.Request) {
+	folderID := chi.URLParam(r, "id")
+
+	folder, err := h.storage.GetFolder(folderID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to get folder")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder")
+		return
+	}
+
+	if folder == nil {
+		h.writeError(w, http.StatusNotFound, "FOLDER_NOT_FOUND", "Folder not found")
+		return
+	}
+
+	var req UpdateFolderRequest
+	if !h.readJSON(w, r, &req) {
+		return
+	}
+
+	if len(req.IsSeries) > 0 {
+		var isSeries *bool
+		if err := json.Unmarshal(req.IsSeries, &isSeries); err != nil {
+			h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "is_series must be a boolean or null")
+			return
+		}
+		if err := h.storage.OverrideFolderSeries(folderID, isSeries); err != nil {
+			h.logger.Error().Err(err).Str("id", folderID).Msg("failed to update folder")
+			h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update folder")
+			return
+		}
+	}
+
+	folder, err = h.storage.GetFolder(folderID)
+	if err != nil || folder == nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to reload folder")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, folder)
+}
+
+// maxFolderNameLength caps folder display names, in characters
+const maxFolderNameLength = 255
+
+// RenameFolder changes the name a folder is shown with. The directory on
+// disk keeps its name, so rescans and stored paths are unaffected.
+func (h *Handler) RenameFolder(w http.ResponseWriter, r *http.Request) {
+	folderID := chi.URLParam(r, "id")
+
+	folder, err := h.storage.GetFolder(folderID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to get folder")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder")
+		return
+	}
+
+	if folder == nil {
+		h.writeError(w, http.StatusNotFound, "FOLDER_NOT_FOUND", "Folder not found")
+		return
+	}
+
+	var req RenameFolderRequest
+	if !h.readJSON(w, r, &req) {
+		return
+	}
+
+	if len(req.Name) == 0 {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "name is required")
+		return
+	}
+	var name *string
+	if err := json.Unmarshal(req.Name, &name); err != nil {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "name must be a string or null")
+		return
+	}
+	if name != nil {
+		trimmed := strings.TrimSpace(*name)
+		if trimmed == "" {
+			h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "name must not be empty")
+			return
+		}
+		if utf8.RuneCountInString(trimmed) > maxFolderNameLength {
+			h.writeError(w, http.StatusBadRequest, "BAD_REQUEST",
+				fmt.Sprintf("name must be at most %d characters", maxFolderNameLength))
+			return
+		}
+		name = &trimmed
+	}
+
+	if err := h.storage.RenameFolder(folderID, name); err != nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to rename folder")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to rename folder")
+		return
+	}
+
+	folder, err = h.storage.GetFolder(folderID)
+	if err != nil || folder == nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to reload folder")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, folder)
+}
+
+// defaultSearchLimit is the number of search results returned without ?limit
+const defaultSearchLimit = 50
+
+// SearchMedia finds media by title. Query params: q (at least 2 characters)
+// and limit (default 50, clamped to server.max_page_size).
+func (h *Handler) SearchMedia(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len([]rune(query)) < 2 {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Search query must be at least 2 characters")
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultSearchLimit)
+	if err != nil || limit < 1 {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Invalid limit")
+		return
+	}
+	if max := h.cfg.Server.MaxPageSize; max > 0 && limit > max {
+		limit = max
+	}
+
+	items, err := h.storage.SearchMedia(query, limit)
+	if err != nil {
+		h.logger.Error().Err(err).Str("query", query).Msg("search failed")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Search failed")
+		return
+	}
+
+	if items == nil {
+		items = []storage.MediaItem{}
+	}
+
+	h.writeJSON(w, http.StatusOK, SearchResponse{
+		Query: query,
+		Media: items,
+		Count: len(items),
+	})
+}
+
+// GetFolderMedia returns a page of a folder's media, listed like the
+// library tree: series folders in episode order unless a sort is given,
+// others by title. Query params: limit, offset (see readPage), hide_watched,
+// sort, order (see mediaListOptions).
+func (h *Handler) GetFolderMedia(w http.ResponseWriter, r *http.Request) {
+	folderID := chi.URLParam(r, "id")
+
+	page, ok := h.readPage(w, r)
+	if !ok {
+		return
+	}
+
+	folder, err := h.storage.GetFolder(folderID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to get folder")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder")
+		return
+	}
+
+	if folder == nil {
+		h.writeError(w, http.StatusNotFound, "FOLDER_NOT_FOUND", "Folder not found")
+		return
+	}
+
+	items, total, err := h.folderMediaPage(folder, h.mediaListOptions(r), page)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to get folder media")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder media")
+		return
+	}
+
+	if items == nil {
+		items = []storage.MediaItem{}
+	}
+
+	stats, err := h.storage.GetFolderStats(folderID)
+	if err != nil {
+		h.logger.Warn().Err(err).Str("id", folderID).Msg("failed to get folder stats")
+	}
+
+	h.writeJSON(w, http.StatusOK, FolderMediaResponse{
+		Media:  items,
+		Total:  total,
+		Limit:  page.Limit,
+		Offset: page.Offset,
+		Stats:  stats,
+	})
+}
+
+// folderMediaPage returns one page of a folder's media and the number of
+// items matching opts. Episode order isn't known to the database, so series
+// folders without an explicit sort are ordered in full and then paged.
+func (h *Handler) folderMediaPage(folder *storage.Folder, opts storage.MediaListOptions, page Page) ([]storage.MediaItem, int, error) {
+	if !folder.IsSeries || opts.Sort != "" {
+		return h.storage.GetMediaItemsByFolderPaged(folder.ID, opts, page.Limit, page.Offset)
+	}
+
+	items, err := h.storage.GetMediaItemsByFolder(folder.ID, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	mediapkg.SortEpisodes(items)
+
+	total := len(items)
+	start := min(page.Offset, total)
+	end := min(start+page.Limit, total)
+	return items[start:end], total, nil
+}
+
+// GetFolderAtlas describes one page of a folder's thumbnails packed into a
+// single JPEG: the position of each item and the URL of the image, so a
+// grid can be drawn from two requests. Pages hold at most
+// mediapkg.MaxAtlasItems items. Items without a thumbnail yet are left out.
+func (h *Handler) GetFolderAtlas(w http.ResponseWriter, r *http.Request) {
+	folderID := chi.URLParam(r, "id")
+
+	atlas, page, total, ok := h.folderAtlas(w, r, folderID)
+	if !ok {
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, FolderAtlasResponse{
+		FolderID: folderID,
+		Width:    atlas.Width,
+		Height:   atlas.Height,
+		Total:    total,
+		Limit:    page.Limit,
+		Offset:   page.Offset,
+		ImageURL: fmt.Sprintf("/api/v1/folders/%s/thumbnails/atlas.jpg?limit=%d&offset=%d", folderID, page.Limit, page.Offset),
+		Items:    atlas.Rects,
+	})
+}
+
+// GetFolderAtlasImage serves the JPEG of an atlas page described by
+// GetFolderAtlas, taking the same limit and offset
+func (h *Handler) GetFolderAtlasImage(w http.ResponseWriter, r *http.Request) {
+	folderID := chi.URLParam(r, "id")
+
+	atlas, page, _, ok := h.folderAtlas(w, r, folderID)
+	if !ok {
+		return
+	}
+
+	name := fmt.Sprintf("atlas-%s-%d.jpg", folderID, page.Offset)
+	w.Header().Set("ETag", atlas.ETag)
+	h.streamer.ServeCachedContent(w, r, name, time.Time{}, bytes.NewReader(atlas.Image), "image/jpeg")
+}
+
+// folderAtlas builds the atlas for the page of a folder's media requested
+// by limit and offset, writing an error response when it can't
+func (h *Handler) folderAtlas(w http.ResponseWriter, r *http.Request, folderID string) (*mediapkg.Atlas, Page, int, bool) {
+	if h.thumbnailService == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return nil, Page{}, 0, false
+	}
+
+	page, ok := h.readPage(w, r)
+	if !ok {
+		return nil, Page{}, 0, false
+	}
+	page.Limit = min(page.Limit, mediapkg.MaxAtlasItems)
+
+	folder, err := h.storage.GetFolder(folderID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to get folder")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder")
+		return nil, Page{}, 0, false
+	}
+
+	if folder == nil {
+		h.writeError(w, http.StatusNotFound, "FOLDER_NOT_FOUND", "Folder not found")
+		return nil, Page{}, 0, false
+	}
+
+	items, total, err := h.folderMediaPage(folder, storage.MediaListOptions{}, page)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to get folder media")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder media")
+		return nil, Page{}, 0, false
+	}
+
+	atlas, err := h.thumbnailService.FolderAtlas(folderID, page.Offset, items)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to build thumbnail atlas")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to build thumbnail atlas")
+		return nil, Page{}, 0, false
+	}
+
+	return atlas, page, total, true
+}
+
+// GetFolderThumbnail serves the thumbnail of the first media item in a
+// folder or its subfolders that has one
+func (h *Handler) GetFolderThumbnail(w http.ResponseWriter, r *http.Request) {
+	folderID := chi.URLParam(r, "id")
+
+	if h.thumbnailService == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return
+	}
+
+	folder, err := h.storage.GetFolder(folderID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to get folder")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder")
+		return
+	}
+
+	if folder == nil {
+		h.writeError(w, http.StatusNotFound, "FOLDER_NOT_FOUND", "Folder not found")
+		return
+	}
+
+	mediaID, data, err := h.thumbnailService.FolderThumbnail(folderID, func() ([]string, error) {
+		return h.storage.GetFolderMediaIDs(folderID)
+	})
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", folderID).Msg("failed to get folder media")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder media")
+		return
+	}
+
+	if mediaID == "" {
+		h.writeError(w, http.StatusNotFound, "THUMBNAIL_NOT_FOUND", "No media in this folder has a thumbnail")
+		return
+	}
+
+	// The representative item can change, so this is cached briefly
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	h.serveThumbnail(w, r, folderID, mediaID, data)
+}
+
+// GetSubtitles serves the first embedded subtitle track as WebVTT. The
+// track is extracted with ffmpeg on first request and cached.
+func (h *Handler) GetSubtitles(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	if h.subtitles == nil || !h.subtitles.IsAvailable() {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Subtitle extraction not available")
+		return
+	}
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for subtitles")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	if !media.HasSubtitles {
+		h.writeError(w, http.StatusNotFound, "SUBTITLE_NOT_FOUND", "Media has no subtitle track")
+		return
+	}
+
+	path, err := h.subtitles.Extract(media.Path, mediaID, media.ModifiedAt)
+	if err != nil {
+		// Usually an image-based track (PGS/VobSub) that can't become text
+		h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to extract subtitles")
+		h.writeError(w, http.StatusNotFound, "SUBTITLE_NOT_FOUND", "Subtitle track could not be converted")
+		return
+	}
+
+	h.streamer.ServeCachedFile(w, r, path, "text/vtt; charset=utf-8")
+}
+
+// GetArtwork serves one artwork type of a media item. The thumb is the
+// generated video frame; poster and backdrop come from sidecar images, an
+// uploaded poster taking precedence.
+func (h *Handler) GetArtwork(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+	artworkType := chi.URLParam(r, "type")
+
+	if !mediapkg.IsArtworkType(artworkType) {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Unknown artwork type")
+		return
+	}
+
+	if artworkType == mediapkg.ArtworkThumb {
+		h.GetThumbnail(w, r)
+		return
+	}
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for artwork")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	// An uploaded poster wins over sidecar images
+	path := ""
+	if artworkType == mediapkg.ArtworkPoster {
+		path = h.uploadedPoster(media)
+	}
+	if path == "" {
+		path = mediapkg.FindSidecarArtwork(media.Path, artworkType)
+	}
+	if path == "" {
+		h.writeError(w, http.StatusNotFound, "ARTWORK_NOT_FOUND", "Artwork not available")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	h.streamer.ServeCachedFile(w, r, path, "")
+}
+
+// GetPoster serves the uploaded poster of a media item, or its thumbnail if
+// it has none
+func (h *Handler) GetPoster(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for poster")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	path := h.uploadedPoster(media)
+	if path == "" {
+		h.GetThumbnail(w, r)
+		return
+	}
+
+	// Revalidated on every use so a new upload shows up immediately
+	w.Header().Set("Cache-Control", "no-cache")
+	h.streamer.ServeCachedFile(w, r, path, "")
+}
+
+// SetPoster stores an uploaded JPEG or PNG as the poster of a media item,
+// replacing any previous one. The image is the request body, or the
+// "poster" field of a multipart form.
+func (h *Handler) SetPoster(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	if h.posters == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Poster storage not available")
+		return
+	}
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for poster")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.cfg.Thumbnails.PosterMaxSize)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("poster")
+		if err != nil {
+			h.writePosterReadError(w, err)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		h.writePosterReadError(w, err)
+		return
+	}
+
+	if err := h.posters.Save(mediaID, data); err != nil {
+		if errors.Is(err, mediapkg.ErrInvalidPoster) {
+			h.writeError(w, http.StatusBadRequest, "INVALID_POSTER", err.Error())
+			return
+		}
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to save poster")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to save poster")
+		return
+	}
+
+	if err := h.storage.SetHasPoster(mediaID, true); err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to mark poster")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to save poster")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, PosterResponse{
+		MediaID:   mediaID,
+		HasPoster: true,
+		PosterURL: "/api/v1/media/" + mediaID + "/poster",
+	})
+}
+
+// DeletePoster removes the uploaded poster of a media item
+func (h *Handler) DeletePoster(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	if h.posters == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Poster storage not available")
+		return
+	}
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for poster")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	if err := h.posters.Delete(mediaID); err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to delete poster")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to delete poster")
+		return
+	}
+	if err := h.storage.SetHasPoster(mediaID, false); err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to unmark poster")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to delete poster")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, PosterResponse{
+		MediaID:   mediaID,
+		HasPoster: false,
+		PosterURL: "/api/v1/media/" + mediaID + "/poster",
+	})
+}
+
+// writePosterReadError reports a poster upload that couldn't be read,
+// distinguishing uploads over thumbnails.poster_max_size
+func (h *Handler) writePosterReadError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		h.writeError(w, http.StatusRequestEntityTooLarge, "POSTER_TOO_LARGE",
+			fmt.Sprintf("Poster exceeds %d bytes", tooLarge.Limit))
+		return
+	}
+	h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Failed to read poster upload")
+}
+
+// uploadedPoster returns the path of a media item's uploaded poster, "" if
+// it has none
+func (h *Handler) uploadedPoster(media *storage.MediaItem) string {
+	if !media.HasPoster || h.posters == nil {
+		return ""
+	}
+	return h.posters.Path(media.ID)
+}
+
+// artworkURLs maps the artwork types available for an item to their URLs
+func (h *Handler) artworkURLs(media *storage.MediaItem) map[string]string {
+	hasThumb := h.thumbnailService != nil && h.thumbnailService.HasThumbnail(media.ID)
+
+	urls := make(map[string]string)
+	for _, t := range mediapkg.AvailableArtwork(media.Path, hasThumb) {
+		urls[t] = "/api/v1/media/" + media.ID + "/artwork/" + t
+		if t == mediapkg.ArtworkThumb {
+			urls[t] += "?v=" + strconv.Itoa(media.ThumbVersion)
+		}
+	}
+	if media.HasPoster {
+		urls[mediapkg.ArtworkPoster] = "/api/v1/media/" + media.ID + "/artwork/" + mediapkg.ArtworkPoster
+	}
+	return urls
+}
+
+// thumbnailURL returns the versioned thumbnail URL of a media item, or ""
+// if it has no thumbnail yet
+func (h *Handler) thumbnailURL(media *storage.MediaItem) string {
+	if h.thumbnailService == nil || !h.thumbnailService.HasThumbnail(media.ID) {
+		return ""
+	}
+	return "/api/v1/media/" + media.ID + "/thumbnail?v=" + strconv.Itoa(media.ThumbVersion)
+}
+
+// ProcessMedia extracts metadata and generates the thumbnail for a single
+// item ahead of the background batch. With ?async=true the item is only
+// queued and the current status is returned immediately.
+func (h *Handler) ProcessMedia(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	if h.thumbnailService == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return
+	}
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for processing")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	status := "processed"
+	if r.URL.Query().Get("async") == "true" {
+		h.thumbnailService.Prioritize(mediaID)
+		status = "queued"
+	} else {
+		if err := h.thumbnailService.ProcessMediaItem(r.Context(), media); err != nil {
+			h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to process media")
+			h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to process media")
+			return
+		}
+
+		// Reload to pick up freshly extracted metadata
+		if updated, err := h.storage.GetMediaItem(mediaID); err == nil && updated != nil {
+			media = updated
+		}
+	}
+
+	httpStatus := http.StatusOK
+	if status == "queued" {
+		httpStatus = http.StatusAccepted
+	}
+
+	h.writeJSON(w, httpStatus, ProcessResponse{
+		MediaID:           mediaID,
+		Status:            status,
+		MetadataExtracted: media.Duration != nil,
+		ThumbnailReady:    h.thumbnailService.HasThumbnail(mediaID),
+		Media:             media,
+	})
+}
+
+// RegenerateThumbnail deletes a media item's thumbnail, clears its failed
+// attempts and generates it again. Generation errors are reported in the
+// status rather than as an error response.
+func (h *Handler) RegenerateThumbnail(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	if h.thumbnailService == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return
+	}
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for thumbnail regeneration")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	if err := h.thumbnailService.Regenerate(mediaID); err != nil {
+		if errors.Is(err, mediapkg.ErrBusy) {
+			h.writeThumbnailsBusy(w)
+			return
+		}
+		h.logger.Warn().Err(err).Str("id", mediaID).Msg("thumbnail regeneration failed")
+	}
+
+	resp := MediaStatusResponse{
+		MediaID:           mediaID,
+		MetadataExtracted: media.Duration != nil,
+		ThumbnailReady:    h.thumbnailService.HasThumbnail(mediaID),
+	}
+	resp.CurrentlyProcessing, resp.Queued, resp.FailureReason = h.thumbnailService.ProcessingState(mediaID)
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// PrewarmThumbnails makes sure thumbnails for a list of media IDs are ready,
+// e.g. for a grid about to be shown. Missing thumbnails are generated in the
+// background; clients fetch them individually once ready.
+func (h *Handler) PrewarmThumbnails(w http.ResponseWriter, r *http.Request) {
+	if h.thumbnailService == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return
+	}
+
+	var req PrewarmRequest
+	if !h.readJSON(w, r, &req) {
+		return
+	}
+
+	if len(req.MediaIDs) == 0 {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "media_ids must not be empty")
+		return
+	}
+
+	if len(req.MediaIDs) > maxPrewarmIDs {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("At most %d media_ids per request", maxPrewarmIDs))
+		return
+	}
+
+	// Deduplicate and drop empty IDs
+	seen := make(map[string]bool, len(req.MediaIDs))
+	ids := make([]string, 0, len(req.MediaIDs))
+	for _, id := range req.MediaIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	cached, queued := h.thumbnailService.Prewarm(ids)
+
+	status := http.StatusOK
+	if len(queued) > 0 {
+		status = http.StatusAccepted
+	}
+	h.writeJSON(w, status, PrewarmResponse{Cached: cached, Queued: queued})
+}
+
+// GetMediaTracks lists the audio and subtitle streams found by ffprobe, so
+// players can offer track selection before playback starts
+func (h *Handler) GetMediaTracks(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for tracks")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	tracks, err := h.storage.GetMediaTracks(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get tracks")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get tracks")
+		return
+	}
+
+	// Items probed before tracks were recorded need a rescan
+	if tracks == nil {
+		h.writeError(w, http.StatusNotFound, "TRACKS_NOT_FOUND", "Tracks are not known until the media is probed")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, TracksResponse{
+		MediaID:     mediaID,
+		MediaTracks: *tracks,
+	})
+}
+
+// GetMediaStatus reports the background processing state of a media item
+func (h *Handler) GetMediaStatus(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for status")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	resp := MediaStatusResponse{
+		MediaID:           mediaID,
+		MetadataExtracted: media.Duration != nil,
+	}
+
+	if h.thumbnailService != nil {
+		resp.ThumbnailReady = h.thumbnailService.HasThumbnail(mediaID)
+		resp.CurrentlyProcessing, resp.Queued, resp.FailureReason = h.thumbnailService.ProcessingState(mediaID)
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	body, err := h.marshalJSON(data)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+// writeError writes an error body. The request ID comes from the response
+// header set by the server's request ID middleware.
+func (h *Handler) writeError(w http.ResponseWriter, status int, code, message string) {
+	h.writeJSON(w, status, ErrorResponse{
+		Error: ErrorDetail{
+			Code:      code,
+			Message:   message,
+			RequestID: w.Header().Get("X-Request-ID"),
+		},
+	})
+}
+
+// readJSON decodes a request body strictly: unknown fields are rejected so
+// client typos surface as errors instead of silently zeroed values. On
+// failure it writes a 400 naming the offending field and returns false.
+func (h *Handler) readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := h.decodeJSON(r.Body, v); err != nil {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", err.Error())
+		return false
+	}
+	return true
+}
+
+// Playback handlers
+
+// isWatched reports whether a playback state counts as watched: marked
+// explicitly or with progress past the threshold. This is independent of
+// the continue-watching range.
+func (h *Handler) isWatched(state storage.PlaybackState) bool {
+	return state.Watched || state.Progress >= h.cfg.Playback.WatchedAt
+}
+
+// SavePlaybackPosition saves the playback position of a media item.
+//
+// Conflicts between devices are resolved by the time the position was
+// recorded: a request carrying "updated_at" older than the stored position
+// is stale (e.g. a paused tablet syncing after the TV moved on) and is
+// rejected with 409 and the stored state, which the client should adopt.
+// Timestamps in the future count as now. Requests without "updated_at"
+// are stamped with the server time and always win.
+func (h *Handler) SavePlaybackPosition(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	// Check if media exists
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for playback")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	var req SavePlaybackRequest
+	if !h.readJSON(w, r, &req) {
+		return
+	}
+
+	// Validate
+	if req.Duration <= 0 {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Duration must be positive")
+		return
+	}
+
+	if req.Position < 0 {
+		req.Position = 0
+	}
+
+	if req.Position > req.Duration {
+		req.Position = req.Duration
+	}
+
+	// Calculate progress
+	progress := float64(req.Position) / float64(req.Duration)
+
+	now := time.Now()
+	state := &storage.PlaybackState{
+		MediaID:   mediaID,
+		Position:  req.Position,
+		Duration:  req.Duration,
+		Progress:  progress,
+		UpdatedAt: now,
+	}
+	if req.UpdatedAt != nil && req.UpdatedAt.Before(now) {
+		// Stored in the server's zone like other timestamps, so they sort
+		state.UpdatedAt = req.UpdatedAt.Local()
+	}
+
+	saved, current, err := h.storage.SavePlaybackStateIfNewer(state)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to save playback state")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to save position")
+		return
+	}
+
+	if !saved {
+		h.logger.Debug().
+			Str("media_id", mediaID).
+			Time("client_time", state.UpdatedAt).
+			Time("stored_time", current.UpdatedAt).
+			Msg("stale playback position ignored")
+
+		h.writeJSON(w, http.StatusConflict, PlaybackResponse{
+			MediaID:   mediaID,
+			Position:  current.Position,
+			Duration:  current.Duration,
+			Progress:  current.Progress,
+			IsWatched: h.isWatched(*current),
+			UpdatedAt: &current.UpdatedAt,
+		})
+		return
+	}
+
+	h.logger.Debug().
+		Str("media_id", mediaID).
+		Int64("position", req.Position).
+		Float64("progress", progress).
+		Msg("playback position saved")
+
+	h.writeJSON(w, http.StatusOK, PlaybackResponse{
+		MediaID:   mediaID,
+		Position:  req.Position,
+		Duration:  req.Duration,
+		Progress:  progress,
+		IsWatched: h.isWatched(*state),
+		UpdatedAt: &state.UpdatedAt,
+	})
+}
+
+func (h *Handler) GetPlaybackPosition(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	state, err := h.storage.GetPlaybackState(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get playback state")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get position")
+		return
+	}
+
+	if state == nil {
+		// No saved position, return zeros
+		h.writeJSON(w, http.StatusOK, PlaybackResponse{
+			MediaID:  mediaID,
+			Position: 0,
+			Duration: 0,
+			Progress: 0,
+		})
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, PlaybackResponse{
+		MediaID:   state.MediaID,
+		Position:  state.Position,
+		Duration:  state.Duration,
+		Progress:  state.Progress,
+		IsWatched: h.isWatched(*state),
+		UpdatedAt: &state.UpdatedAt,
+	})
+}
+
+// DeletePlaybackPosition removes a saved position, e.g. to dismiss an item
+// from continue watching. It succeeds whether or not a position was saved.
+func (h *Handler) DeletePlaybackPosition(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	if err := h.storage.DeletePlaybackState(mediaID); err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to delete playback state")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to delete position")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, PlaybackResponse{MediaID: mediaID})
+}
+
+// GetPlaybackBatch returns playback state for many media IDs in one query.
+// Unknown IDs (or IDs without saved progress) are returned with zeros.
+func (h *Handler) GetPlaybackBatch(w http.ResponseWriter, r *http.Request) {
+	var req BatchPlaybackRequest
+	if !h.readJSON(w, r, &req) {
+		return
+	}
+
+	if len(req.MediaIDs) == 0 {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "media_ids must not be empty")
+		return
+	}
+
+	if len(req.MediaIDs) > maxBatchPlaybackIDs {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", fmt.Sprintf("At most %d media_ids per request", maxBatchPlaybackIDs))
+		return
+	}
+
+	// Deduplicate and drop empty IDs
+	seen := make(map[string]bool, len(req.MediaIDs))
+	ids := make([]string, 0, len(req.MediaIDs))
+	for _, id := range req.MediaIDs {
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	states, err := h.storage.GetPlaybackStates(ids)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to get playback states")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get positions")
+		return
+	}
+
+	resp := BatchPlaybackResponse{
+		States: make(map[string]PlaybackResponse, len(ids)),
+	}
+	for _, id := range ids {
+		state := states[id]
+		resp.States[id] = PlaybackResponse{
+			MediaID:   id,
+			Position:  state.Position,
+			Duration:  state.Duration,
+			Progress:  state.Progress,
+			IsWatched: h.isWatched(state),
+		}
+	}
+
+	h.writeJSON(w, http.StatusOK, resp)
+}
+
+// Continue-watching list size
+const (
+	defaultContinueLimit = 20
+	maxContinueLimit     = 100
+)
+
+// GetContinueWatching returns in-progress media.
+// Query params: limit (default 20, max 100; invalid values use the default)
+// and order (recent, progress or title; default recent).
+func (h *Handler) GetContinueWatching(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultContinueLimit)
+	if err != nil || limit < 1 {
+		limit = defaultContinueLimit
+	}
+	if limit > maxContinueLimit {
+		limit = maxContinueLimit
+	}
+
+	items, err := h.storage.GetContinueWatching(
+		limit,
+		r.URL.Query().Get("order"),
+		h.cfg.Playback.ContinueMin,
+		h.cfg.Playback.ContinueMax,
+	)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to get continue watching")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get continue watching")
+		return
+	}
+
+	if items == nil {
+		items = []storage.ContinueWatchingItem{}
+	}
+
+	for i := range items {
+		items[i].PlaybackState.IsWatched = h.isWatched(items[i].PlaybackState)
+	}
+
+	h.writeJSON(w, http.StatusOK, ContinueWatchingResponse{
+		Items: items,
+	})
+}
+
+// GetNextUp lists the next unstarted episode of each show with a finished
+// episode, shows watched most recently first. Specials (season 0) are only
+// included with ?specials=true.
+func (h *Handler) GetNextUp(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r, "limit", defaultContinueLimit)
+	if err != nil || limit < 1 {
+		limit = defaultContinueLimit
+	}
+	if limit > maxContinueLimit {
+		limit = maxContinueLimit
+	}
+
+	includeSpecials := r.URL.Query().Get("specials") == "true"
+	media, err := h.storage.GetNextUp(h.cfg.Playback.WatchedAt, limit, includeSpecials)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to get next up")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get next up")
+		return
+	}
+
+	if media == nil {
+		media = []storage.MediaItem{}
+	}
+
+	h.writeJSON(w, http.StatusOK, NextUpResponse{Media: media})
+}
+
+// SetWatched marks a media item as watched or unwatched. The body
+// {"watched": bool} is optional; without it the current state is toggled.
+func (h *Handler) SetWatched(w http.ResponseWriter, r *http.Request) {
+	mediaID := chi.URLParam(r, "id")
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for watched flag")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	var req SetWatchedRequest
+	if r.ContentLength != 0 && !h.readJSON(w, r, &req) {
+		return
+	}
+
+	watched := !h.mediaWatched(mediaID)
+	if req.Watched != nil {
+		watched = *req.Watched
+	}
+
+	if err := h.storage.SetWatched(mediaID, watched); err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to set watched flag")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to set watched")
+		return
+	}
+
+	state, err := h.storage.GetPlaybackState(mediaID)
+	if err != nil || state == nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get playback state")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get position")
+		return
+	}
+	h.writeJSON(w, http.StatusOK, PlaybackResponse{
+		MediaID:   mediaID,
+		Position:  state.Position,
+		Duration:  state.Duration,
+		Progress:  state.Progress,
+		IsWatched: h.isWatched(*state),
+	})
+}
+
+// GetWatched lists watched media, most recently watched first, paginated
+// with limit and offset
+func (h *Handler) GetWatched(w http.ResponseWriter, r *http.Request) {
+	page, ok := h.readPage(w, r)
+	if !ok {
+		return
+	}
+
+	items, err := h.storage.GetWatched(h.cfg.Playback.WatchedAt, page.Limit, page.Offset)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to get watched media")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get watched media")
+		return
+	}
+
+	if items == nil {
+		items = []storage.ContinueWatchingItem{}
+	}
+
+	for i := range items {
+		items[i].PlaybackState.IsWatched = true
+	}
+
+	h.writeJSON(w, http.StatusOK, WatchedResponse{
+		Items:  items,
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	})
+}
+
+// AddFavorite marks a media item as a favorite
+func (h *Handler) AddFavorite(w http.ResponseWriter, r *http.Request) {
+	h.setFavorite(w, r, true)
+}
+
+// RemoveFavorite unmarks a favorite media item
+func (h *Handler) RemoveFavorite(w http.ResponseWriter, r *http.Request) {
+	h.setFavorite(w, r, false)
+}
+
+func (h *Handler) setFavorite(w http.ResponseWriter, r *http.Request, favorite bool) {
+	mediaID := chi.URLParam(r, "id")
+
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Msg("failed to get media for favorite")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get media")
+		return
+	}
+
+	if media == nil {
+		h.writeError(w, http.StatusNotFound, "MEDIA_NOT_FOUND", "Media not found")
+		return
+	}
+
+	if favorite {
+		err = h.storage.AddFavorite(mediaID)
+	} else {
+		err = h.storage.RemoveFavorite(mediaID)
+	}
+	if err != nil {
+		h.logger.Error().Err(err).Str("id", mediaID).Bool("favorite", favorite).Msg("failed to update favorite")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to update favorite")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, FavoriteResponse{
+		MediaID:    mediaID,
+		IsFavorite: favorite,
+	})
+}
+
+// GetFavorites returns favorite media, most recently added first
+func (h *Handler) GetFavorites(w http.ResponseWriter, r *http.Request) {
+	page, ok := h.readPage(w, r)
+	if !ok {
+		return
+	}
+
+	items, err := h.storage.GetFavorites(page.Limit, page.Offset)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to get favorites")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get favorites")
+		return
+	}
+
+	if items == nil {
+		items = []storage.FavoriteItem{}
+	}
+
+	h.writeJSON(w, http.StatusOK, FavoritesResponse{
+		Items:  items,
+		Limit:  page.Limit,
+		Offset: page.Offset,
+	})
+}
+
+// publishPlayback announces the current playback state of a media item,
+// run by the storage after every playback change
+func (h *Handler) publishPlayback(mediaID string) {
+	media, err := h.storage.GetMediaItem(mediaID)
+	if err != nil || media == nil {
+		return
+	}
+	state, err := h.storage.GetPlaybackState(mediaID)
+	if err != nil {
+		h.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to get playback state for event")
+		return
+	}
+
+	playback := PlaybackResponse{MediaID: mediaID}
+	if state != nil {
+		playback = PlaybackResponse{
+			MediaID:   mediaID,
+			Position:  state.Position,
+			Duration:  state.Duration,
+			Progress:  state.Progress,
+			IsWatched: h.isWatched(*state),
+			UpdatedAt: &state.UpdatedAt,
+		}
+	}
+
+	h.events.Publish(events.Event{
+		Type: events.PlaybackUpdated,
+		ID:   mediaID,
+		Data: PlaybackEventResponse{Media: media, Playback: playback},
+	})
+}
+
+// PlaybackEvents streams playback updates as Server-Sent Events.
+// Rapid updates for the same media item are coalesced so only the
+// latest state is sent per flush interval.
+func (h *Handler) PlaybackEvents(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Events not available")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Streaming not supported")
+		return
+	}
+
+	sub, unsubscribe := h.events.Subscribe(64)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	flushTicker := time.NewTicker(500 * time.Millisecond)
+	defer flushTicker.Stop()
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	// Pending events keyed by media ID, preserving first-seen order
+	pending := make(map[string]events.Event)
+	var order []string
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub:
+			if !ok {
+				return
+			}
+			if e.Type != events.PlaybackUpdated {
+				continue
+			}
+			if _, exists := pending[e.ID]; !exists {
+				order = append(order, e.ID)
+			}
+			pending[e.ID] = e
+		case <-flushTicker.C:
+			if len(order) == 0 {
+				continue
+			}
+			for _, id := range order {
+				data, err := h.marshalJSON(pending[id].Data)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", events.PlaybackUpdated, data)
+			}
+			flusher.Flush()
+			pending = make(map[string]events.Event)
+			order = nil
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// wsUpgrader accepts any origin, matching the API's CORS policy
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// WebSocket keepalive: pings every wsPingInterval, and a client that hasn't
+// answered within wsPongWait is dropped. Writes are bounded by wsWriteTimeout.
+const (
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsWriteTimeout = 10 * time.Second
+)
+
+// LibraryEvents streams bus events (scan progress, new folders and media,
+// ready thumbnails, playback updates) over a WebSocket as JSON messages.
+// Events are dropped for clients that fall behind rather than blocking
+// the scanner.
+func (h *Handler) LibraryEvents(w http.ResponseWriter, r *http.Request) {
+	if h.events == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Events not available")
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an error status
+		h.logger.Debug().Err(err).Msg("websocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+
+	sub, unsubscribe := h.events.Subscribe(64)
+	defer unsubscribe()
+
+	// The server's read timeout still applies to the hijacked connection,
+	// so replace it with the pong-based deadline
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	// Clients don't send anything, but reading is needed to handle control
+	// frames and to notice when the connection goes away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case e, ok := <-sub:
+			if !ok {
+				return
+			}
+			data, err := h.marshalJSON(e)
+			if err != nil {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// GetLibraryTree returns the complete library structure in one response.
+// With ?hide_watched=true, media past the watched threshold is left out.
+// Media order follows ?sort= and ?order= (see mediaListOptions); folders
+// are always listed by name.
+func (h *Handler) GetLibraryTree(w http.ResponseWriter, r *http.Request) {
+	opts := h.mediaListOptions(r)
+
+	// Get all root folders
+	rootFolders, err := h.storage.GetRootFolders()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to get root folders")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get library")
+		return
+	}
+
+	// Get root-level media (media in the library root directory)
+	rootMedia, err := h.storage.GetRootMedia(opts)
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("failed to get root media")
+		rootMedia = []storage.MediaItem{}
+	}
+	rootSeries, rootMedia := mediapkg.GroupSeries(rootMedia)
+
+	// Per-folder totals are summed up the tree while it is built
+	directStats, err := h.storage.GetDirectFolderStats()
+	if err != nil {
+		h.logger.Warn().Err(err).Msg("failed to get folder stats")
+		directStats = map[string]storage.FolderStats{}
+	}
+
+	// Build tree recursively
+	var folderNodes []FolderNode
+	for _, folder := range rootFolders {
+		node := h.buildFolderNode(folder, opts, directStats)
+		folderNodes = append(folderNodes, node)
+	}
+
+	// If there's exactly one root folder and no root media,
+	// return the contents of that folder directly (unwrap it)
+	// This provides a better UX - user sees content immediately
+	if len(folderNodes) == 1 && len(rootMedia) == 0 && len(rootSeries) == 0 {
+		singleFolder := folderNodes[0]
+		h.writeJSON(w, http.StatusOK, LibraryTreeResponse{
+			Name:          h.libraryName,
+			LibraryOnline: h.libraryOnline(),
+			Folders:       singleFolder.SubFolders,
+			Series:        singleFolder.Series,
+			Media:         singleFolder.Media,
+		})
+		return
+	}
+
+	if folderNodes == nil {
+		folderNodes = []FolderNode{}
+	}
+
+	h.writeJSON(w, http.StatusOK, LibraryTreeResponse{
+		Name:          h.libraryName,
+		LibraryOnline: h.libraryOnline(),
+		Folders:       folderNodes,
+		Series:        rootSeries,
+		Media:         rootMedia,
+	})
+}
+
+// GetAuditLog returns recorded library changes (scans, removed and
+// deleted media), newest first. Query params: limit, offset (see readPage).
+func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	page, ok := h.readPage(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := h.storage.GetAuditLog(page.Limit, page.Offset)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to get audit log")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get audit log")
+		return
+	}
+
+	if entries == nil {
+		entries = []storage.AuditEntry{}
+	}
+
+	h.writeJSON(w, http.StatusOK, AuditLogResponse{
+		Entries: entries,
+		Limit:   page.Limit,
+		Offset:  page.Offset,
+	})
+}
+
+// GetDuplicates lists media that share a file size and so may be the same
+// file imported twice. ?titles=true also requires matching titles. Nothing
+// is deleted; see DeleteMedia.
+func (h *Handler) GetDuplicates(w http.ResponseWriter, r *http.Request) {
+	matchTitles := r.URL.Query().Get("titles") == "true"
+
+	groups, err := h.storage.FindDuplicates(matchTitles)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to find duplicates")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to find duplicates")
+		return
+	}
+
+	if groups == nil {
+		groups = []storage.DuplicateGroup{}
+	}
+
+	var wasted int64
+	for _, g := range groups {
+		wasted += g.Size * int64(len(g.Media)-1)
+	}
+
+	h.writeJSON(w, http.StatusOK, DuplicatesResponse{
+		Groups:      groups,
+		MatchTitles: matchTitles,
+		WastedBytes: wasted,
+	})
+}
+
+// GetFolderReport returns a flat, paginated report of all folders with media
+// counts, total size and last modification, for library housekeeping.
+// Query params: limit, offset (see readPage), sort (see storage.GetFolderReport).
+func (h *Handler) GetFolderReport(w http.ResponseWriter, r *http.Request) {
+	page, ok := h.readPage(w, r)
+	if !ok {
+		return
+	}
+
+	folders, err := h.storage.GetFolderReport(page.Limit, page.Offset, r.URL.Query().Get("sort"))
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to get folder report")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder report")
+		return
+	}
+
+	total, err := h.storage.CountFolders()
+	if err != nil {
+		h.logger.Error().Err(err).Msg("failed to count folders")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to get folder report")
+		return
+	}
+
+	if folders == nil {
+		folders = []storage.FolderReportEntry{}
+	}
+
+	h.writeJSON(w, http.StatusOK, FolderReportResponse{
+		Folders: folders,
+		Total:   total,
+		Limit:   page.Limit,
+		Offset:  page.Offset,
+	})
+}
+
+// RepairLibrary fixes dangling references in the database (orphaned
+// folders, media in missing folders, playback/thumbnail rows for missing
+// media) and returns what was found. With ?dry_run=true nothing is changed.
+func (h *Handler) RepairLibrary(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	report, err := h.storage.RepairConsistency(dryRun)
+	if err != nil {
+		h.logger.Error().Err(err).Msg("library repair failed")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Repair failed")
+		return
+	}
+
+	h.logger.Info().
+		Bool("dry_run", dryRun).
+		Int("orphaned_folders", report.OrphanedFolders).
+		Int("orphaned_media", report.OrphanedMedia).
+		Int("orphaned_playback", report.OrphanedPlayback).
+		Int("orphaned_thumbnails", report.OrphanedThumbnails).
+		Int("orphaned_tags", report.OrphanedTags).
+		Int("orphaned_favorites", report.OrphanedFavorites).
+		Msg("library repair completed")
+
+	h.writeJSON(w, http.StatusOK, report)
+}
+
+// GetCacheStats reports thumbnail cache usage and hit ratio, for tuning
+// thumbnails.cache_capacity and cache_max_size
+func (h *Handler) GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	if h.thumbnailService == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, h.thumbnailService.CacheStats())
+}
+
+// ResetCacheStats zeroes the thumbnail cache hit and miss counters and
+// returns the resulting stats
+func (h *Handler) ResetCacheStats(w http.ResponseWriter, r *http.Request) {
+	if h.thumbnailService == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return
+	}
+
+	h.thumbnailService.ResetCacheStats()
+	h.writeJSON(w, http.StatusOK, h.thumbnailService.CacheStats())
+}
+
+// ClearCache empties the thumbnail memory cache. With ?disk=true the files
+// in the thumbnail directory and thumbnails stored in the database are
+// deleted too, so every thumbnail is generated again.
+func (h *Handler) ClearCache(w http.ResponseWriter, r *http.Request) {
+	if h.thumbnailService == nil {
+		h.writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "Thumbnail service not available")
+		return
+	}
+
+	disk := r.URL.Query().Get("disk") == "true"
+	entries, files, err := h.thumbnailService.ClearCache(disk)
+	if err != nil {
+		h.logger.Error().Err(err).Int("files", files).Msg("failed to clear thumbnail cache")
+		h.writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "Failed to clear thumbnail cache")
+		return
+	}
+
+	h.logger.Info().Int("entries", entries).Int("files", files).Bool("disk", disk).Msg("thumbnail cache cleared")
+	h.writeJSON(w, http.StatusOK, ClearCacheResponse{MemoryEntries: entries, DiskFiles: files})
+}
+
+// Page is a validated limit/offset pair for paginated endpoints
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// readPage parses limit and offset for paginated endpoints. A missing limit
+// uses server.default_page_size and larger ones are clamped to
+// server.max_page_size. Malformed or negative values get a 400 and false.
+func (h *Handler) readPage(w http.ResponseWriter, r *http.Request) (Page, bool) {
+	limit, err := queryInt(r, "limit", h.cfg.Server.DefaultPageSize)
+	if err != nil || limit < 1 {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Invalid limit")
+		return Page{}, false
+	}
+	if max := h.cfg.Server.MaxPageSize; max > 0 && limit > max {
+		limit = max
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		h.writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Invalid offset")
+		return Page{}, false
+	}
+
+	return Page{Limit: limit, Offset: offset}, true
+}
+
+// queryInt reads an integer query parameter, returning def when it's absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// mediaListOptions reads listing filters and ordering from the query string:
+// hide_watched, sort (title, date_added, date_modified, size) and order
+// (asc, desc). Invalid values fall back to title ascending.
+func (h *Handler) mediaListOptions(r *http.Request) storage.MediaListOptions {
+	q := r.URL.Query()
+	return storage.MediaListOptions{
+		HideWatched: q.Get("hide_watched") == "true",
+		WatchedAt:   h.cfg.Playback.WatchedAt,
+		Sort:        q.Get("sort"),
+		Desc:        q.Get("order") == "desc",
+	}
+}
+
+func (h *Handler) buildFolderNode(folder storage.Folder, opts storage.MediaListOptions, directStats map[string]storage.FolderStats) FolderNode {
+	node := FolderNode{
+		ID:       folder.ID,
+		Name:     folder.Name,
+		IsSeries: folder.IsSeries,
+		Stats:    directStats[folder.ID],
+	}
+
+	// Get subfolders
+	subFolders, err := h.storage.GetSubFolders(folder.ID)
+	if err == nil && len(subFolders) > 0 {
+		for _, sub := range subFolders {
+			subNode := h.buildFolderNode(sub, opts, directStats)
+			node.Stats.Add(subNode.Stats)
+			node.SubFolders = append(node.SubFolders, subNode)
 		}
 	}
 
 	// Get media items
-	mediaItems, err := h.storage.GetMediaItemsByFolder(folder.ID)
+	mediaItems, err := h.storage.GetMediaItemsByFolder(folder.ID, opts)
 	if err == nil && len(mediaItems) > 0 {
+		// Series play in episode order unless the client picked a sort;
+		// episodes of shows in other folders are grouped per show
+		if folder.IsSeries {
+			if opts.Sort == "" {
+				mediapkg.SortEpisodes(mediaItems)
+			}
+		} else {
+			node.Series, mediaItems = mediapkg.GroupSeries(mediaItems)
+		}
 		node.Media = mediaItems
 	}
 
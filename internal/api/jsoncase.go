@@ -0,0 +1,307 @@
+package api
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// JSON key naming styles for API responses
+const (
+	JSONCaseSnake = "snake"
+	JSONCaseCamel = "camel"
+)
+
+// marshalJSON encodes a response value. DTOs are declared with snake_case
+// tags; in camelCase mode their keys are rewritten on the way out.
+func (h *Handler) marshalJSON(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || !h.camelCase {
+		return data, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	if err := camelValue(dec, &buf, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// camelValue copies the next JSON value from dec to buf, renaming the keys
+// of objects encoded from structs. v is the Go value the JSON was encoded
+// from; map keys (media IDs, check names, event data) are data and kept.
+func camelValue(dec *json.Decoder, buf *bytes.Buffer, v reflect.Value) error {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || implementsMarshaler(v.Type()) {
+		return copyValue(dec, buf)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := jsonFields(v.Type())
+		return rewriteObject(dec, buf, func(key string) (string, func() error) {
+			field, ok := fields[key]
+			if !ok {
+				return key, func() error { return copyValue(dec, buf) }
+			}
+			value, err := v.FieldByIndexErr(field.Index)
+			if err != nil {
+				value = reflect.Value{}
+			}
+			return snakeToCamel(key), func() error { return camelValue(dec, buf, value) }
+		})
+	case reflect.Map:
+		return rewriteObject(dec, buf, func(key string) (string, func() error) {
+			var value reflect.Value
+			if v.Type().Key().Kind() == reflect.String {
+				value = v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+			}
+			return key, func() error { return camelValue(dec, buf, value) }
+		})
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return copyValue(dec, buf) // []byte is a base64 string
+		}
+		i := 0
+		return rewriteArray(dec, buf, func() error {
+			var elem reflect.Value
+			if i < v.Len() {
+				elem = v.Index(i)
+			}
+			i++
+			return camelValue(dec, buf, elem)
+		})
+	}
+	return copyValue(dec, buf)
+}
+
+// snakeType copies the next JSON value from dec to buf, renaming camelCase
+// keys of objects decoded into structs of type t back to their tags
+func snakeType(dec *json.Decoder, buf *bytes.Buffer, t reflect.Type) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if implementsMarshaler(t) {
+		return copyValue(dec, buf)
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := jsonFields(t)
+		byCamel := make(map[string]string, len(fields))
+		for name := range fields {
+			byCamel[snakeToCamel(name)] = name
+		}
+		return rewriteObject(dec, buf, func(key string) (string, func() error) {
+			name, ok := byCamel[key]
+			if !ok {
+				// Unknown fields are left for the strict decoder to reject
+				return key, func() error { return copyValue(dec, buf) }
+			}
+			return name, func() error { return snakeType(dec, buf, t.FieldByIndex(fields[name].Index).Type) }
+		})
+	case reflect.Map:
+		return rewriteObject(dec, buf, func(key string) (string, func() error) {
+			return key, func() error { return snakeType(dec, buf, t.Elem()) }
+		})
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return copyValue(dec, buf)
+		}
+		return rewriteArray(dec, buf, func() error { return snakeType(dec, buf, t.Elem()) })
+	}
+	return copyValue(dec, buf)
+}
+
+// rewriteObject copies a JSON object, letting member return the key to
+// write for each key read and a function copying its value. Anything but
+// an object is copied unchanged.
+func rewriteObject(dec *json.Decoder, buf *bytes.Buffer, member func(key string) (string, func() error)) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('{') {
+		return writeToken(buf, tok)
+	}
+
+	buf.WriteByte('{')
+	for first := true; dec.More(); first = false {
+		if !first {
+			buf.WriteByte(',')
+		}
+		keyTok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, value := member(keyTok.(string))
+		encoded, _ := json.Marshal(key)
+		buf.Write(encoded)
+		buf.WriteByte(':')
+		if err := value(); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+	// Consume the closing delimiter
+	_, err = dec.Token()
+	return err
+}
+
+// rewriteArray copies a JSON array, calling elem for each element. Anything
+// but an array is copied unchanged.
+func rewriteArray(dec *json.Decoder, buf *bytes.Buffer, elem func() error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		return writeToken(buf, tok)
+	}
+
+	buf.WriteByte('[')
+	for first := true; dec.More(); first = false {
+		if !first {
+			buf.WriteByte(',')
+		}
+		if err := elem(); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte(']')
+	_, err = dec.Token()
+	return err
+}
+
+// copyValue copies the next JSON value from dec to buf as is
+func copyValue(dec *json.Decoder, buf *bytes.Buffer) error {
+	var raw json.RawMessage
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	buf.Write(raw)
+	return nil
+}
+
+// writeToken writes a scalar token read from a decoder using UseNumber
+func writeToken(buf *bytes.Buffer, tok json.Token) error {
+	if n, ok := tok.(json.Number); ok {
+		buf.WriteString(n.String())
+		return nil
+	}
+	encoded, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	buf.Write(encoded)
+	return nil
+}
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// implementsMarshaler reports whether values of t encode themselves, such
+// as time.Time or json.RawMessage; their output is never rewritten
+func implementsMarshaler(t reflect.Type) bool {
+	for _, m := range []reflect.Type{jsonMarshalerType, textMarshalerType} {
+		if t.Implements(m) || reflect.PointerTo(t).Implements(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonFieldCache holds jsonFields results per struct type
+var jsonFieldCache sync.Map
+
+// jsonFields maps the JSON keys of a struct type to its fields, including
+// fields promoted from embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	if cached, ok := jsonFieldCache.Load(t); ok {
+		return cached.(map[string]reflect.StructField)
+	}
+
+	fields := make(map[string]reflect.StructField)
+	for _, f := range reflect.VisibleFields(t) {
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			continue // promoted, its fields are listed separately
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, taken := fields[name]; !taken || len(f.Index) < len(fields[name].Index) {
+			fields[name] = f
+		}
+	}
+
+	jsonFieldCache.Store(t, fields)
+	return fields
+}
+
+// decodeJSON strictly decodes a request body into v. In camelCase mode
+// the keys of request DTOs are converted back to their snake_case tags
+// first.
+func (h *Handler) decodeJSON(body io.Reader, v interface{}) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return errors.New("Failed to read request body")
+	}
+
+	if h.camelCase {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var buf bytes.Buffer
+		if err := snakeType(dec, &buf, reflect.TypeOf(v)); err != nil {
+			return errors.New("Invalid request body")
+		}
+		data = buf.Bytes()
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("Unknown field %s", field)
+		case errors.As(err, &typeErr) && typeErr.Field != "":
+			return fmt.Errorf("Invalid value for field %q", typeErr.Field)
+		default:
+			return errors.New("Invalid request body")
+		}
+	}
+	return nil
+}
+
+// snakeToCamel converts "video_codec" to "videoCodec"
+func snakeToCamel(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
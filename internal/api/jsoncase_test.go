@@ -0,0 +1,81 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"rvcinemaview/internal/events"
+)
+
+func TestMarshalJSONCamelCase(t *testing.T) {
+	h := &Handler{camelCase: true}
+
+	tests := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{
+			"struct keys",
+			PlaybackResponse{MediaID: "abc", IsWatched: true},
+			`{"mediaId":"abc","position":0,"duration":0,"progress":0,"isWatched":true}`,
+		},
+		{
+			"map keys are data",
+			HealthResponse{Status: "ok", Checks: map[string]HealthCheck{"disk_space": {Status: "ok"}}},
+			`{"status":"ok","version":"","activeStreams":0,"libraryOnline":false,"checks":{"disk_space":{"status":"ok"}}}`,
+		},
+		{
+			"event data kept",
+			events.Event{Type: "scan_started", Data: map[string]string{"library_path": "/media"}},
+			`{"type":"scan_started","data":{"library_path":"/media"}}`,
+		},
+		{
+			"structs inside slices",
+			[]ErrorDetail{{Code: "X", RequestID: "1"}},
+			`[{"code":"X","message":"","requestId":"1"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := h.marshalJSON(tt.v)
+			if err != nil {
+				t.Fatalf("marshalJSON: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshalJSONSnakeCase(t *testing.T) {
+	h := &Handler{}
+	got, err := h.marshalJSON(PlaybackResponse{MediaID: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(got), `"media_id":"abc"`) {
+		t.Errorf("snake case output rewritten: %s", got)
+	}
+}
+
+func TestDecodeJSONCamelCase(t *testing.T) {
+	h := &Handler{camelCase: true}
+
+	var req SavePlaybackRequest
+	body := `{"position":10,"duration":100,"updatedAt":"2026-01-02T03:04:05Z"}`
+	if err := h.decodeJSON(strings.NewReader(body), &req); err != nil {
+		t.Fatalf("decodeJSON: %v", err)
+	}
+	want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if req.Position != 10 || req.UpdatedAt == nil || !req.UpdatedAt.Equal(want) {
+		t.Errorf("decoded %+v", req)
+	}
+
+	if err := h.decodeJSON(strings.NewReader(`{"position":1,"bogusField":2}`), &req); err == nil {
+		t.Error("unknown camelCase field accepted")
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrShareExpired = errors.New("share link expired")
+	ErrShareInvalid = errors.New("share token invalid")
+)
+
+// SignShare returns an HMAC token over the media ID and expiry (unix seconds)
+func SignShare(secret, mediaID string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(mediaID))
+	mac.Write([]byte{':'})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyShare checks a share token and expiry for a media ID
+func VerifyShare(secret, mediaID, token, expires string) error {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrShareInvalid
+	}
+
+	expected := SignShare(secret, mediaID, exp)
+	if !hmac.Equal([]byte(expected), []byte(token)) {
+		return ErrShareInvalid
+	}
+
+	if time.Now().Unix() > exp {
+		return ErrShareExpired
+	}
+
+	return nil
+}
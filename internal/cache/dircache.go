@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// DirCache manages a scratch directory for transient generated artifacts
+// (storyboards, extracted frames...). Entries are
+// evicted by age and, when over the size cap, least-recently-used first.
+// Access time is tracked through the file mtime (see Touch).
+type DirCache struct {
+	dir     string
+	maxSize int64         // bytes, 0 = unlimited
+	maxAge  time.Duration // 0 = no age limit
+	mu      sync.Mutex
+}
+
+type dirEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// NewDirCache creates the cache directory if needed
+func NewDirCache(dir string, maxSizeBytes int64, maxAge time.Duration) (*DirCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DirCache{
+		dir:     dir,
+		maxSize: maxSizeBytes,
+		maxAge:  maxAge,
+	}, nil
+}
+
+// Dir returns the root cache directory
+func (c *DirCache) Dir() string {
+	return c.dir
+}
+
+// Path returns a path inside the cache, creating its parent directory
+func (c *DirCache) Path(elem ...string) (string, error) {
+	path := filepath.Join(append([]string{c.dir}, elem...)...)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Touch marks an entry as recently used
+func (c *DirCache) Touch(path string) {
+	now := time.Now()
+	os.Chtimes(path, now, now)
+}
+
+// Clean evicts expired entries, then the least recently used ones until the
+// cache fits within its size cap. Returns the number of files removed and bytes freed.
+func (c *DirCache) Clean() (removed int, freed int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var entries []dirEntry
+	var total int64
+	err = filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		entries = append(entries, dirEntry{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Oldest first
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	cutoff := time.Now().Add(-c.maxAge)
+	for _, e := range entries {
+		expired := c.maxAge > 0 && e.modTime.Before(cutoff)
+		overSize := c.maxSize > 0 && total > c.maxSize
+		if !expired && !overSize {
+			break
+		}
+		if err := os.Remove(e.path); err != nil {
+			continue
+		}
+		removed++
+		freed += e.size
+		total -= e.size
+	}
+
+	removeEmptyDirs(c.dir)
+	return removed, freed, nil
+}
+
+// StartJanitor cleans the cache immediately and then on every interval
+func (c *DirCache) StartJanitor(ctx context.Context, interval time.Duration, logger zerolog.Logger) {
+	clean := func() {
+		removed, freed, err := c.Clean()
+		if err != nil {
+			logger.Warn().Err(err).Str("dir", c.dir).Msg("cache cleanup failed")
+			return
+		}
+		if removed > 0 {
+			logger.Info().
+				Str("dir", c.dir).
+				Int("files", removed).
+				Int64("bytes", freed).
+				Msg("cache cleanup completed")
+		}
+	}
+
+	clean()
+
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				clean()
+			}
+		}
+	}()
+}
+
+// removeEmptyDirs deletes empty subdirectories below root (root itself is kept).
+// Recently created directories are left alone since a producer may be about
+// to write into them.
+func removeEmptyDirs(root string) {
+	cutoff := time.Now().Add(-time.Minute)
+	var dirs []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() || path == root {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.ModTime().Before(cutoff) {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	// Deepest first so parents become empty after their children
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i]) // fails harmlessly if not empty
+	}
+}
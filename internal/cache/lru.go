@@ -3,6 +3,7 @@ package cache
 import (
 	"container/list"
 	"sync"
+	"sync/atomic"
 )
 
 // LRUCache is a thread-safe LRU cache for thumbnail data
@@ -13,6 +14,9 @@ type LRUCache struct {
 	items    map[string]*list.Element
 	order    *list.List
 	mu       sync.RWMutex
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
 }
 
 type cacheEntry struct {
@@ -37,8 +41,10 @@ func (c *LRUCache) Get(key string) ([]byte, bool) {
 
 	if elem, ok := c.items[key]; ok {
 		c.order.MoveToFront(elem)
+		c.hits.Add(1)
 		return elem.Value.(*cacheEntry).data, true
 	}
+	c.misses.Add(1)
 	return nil, false
 }
 
@@ -110,6 +116,22 @@ func (c *LRUCache) Size() int64 {
 	return c.size
 }
 
+// Stats returns the number of Get hits and misses since creation or the
+// last ResetStats, and the share of hits (0 without lookups)
+func (c *LRUCache) Stats() (hits, misses uint64, hitRatio float64) {
+	hits, misses = c.hits.Load(), c.misses.Load()
+	if total := hits + misses; total > 0 {
+		hitRatio = float64(hits) / float64(total)
+	}
+	return hits, misses, hitRatio
+}
+
+// ResetStats zeroes the hit and miss counters
+func (c *LRUCache) ResetStats() {
+	c.hits.Store(0)
+	c.misses.Store(0)
+}
+
 func (c *LRUCache) evictOldest() {
 	elem := c.order.Back()
 	if elem != nil {
@@ -0,0 +1,72 @@
+// Package clientip identifies the client behind a request, so rate limits
+// and stream slots are counted per client
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+type contextKey int
+
+const (
+	clientKey contextKey = iota
+	connKey
+)
+
+// connIDs numbers connections for ConnContext
+var connIDs atomic.Uint64
+
+// ConnContext tags every connection with an ID, telling apart clients
+// that have no address of their own such as Unix socket peers. Use it as
+// http.Server.ConnContext.
+func ConnContext(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, connKey, connIDs.Add(1))
+}
+
+// Middleware resolves the client of each request once for FromRequest.
+// With trustProxy the client is taken from X-Forwarded-For, so only enable
+// it behind a proxy that sets the header.
+func Middleware(trustProxy bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientKey, resolve(r, trustProxy))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// FromRequest returns the client resolved by Middleware. Requests that
+// didn't pass through it are resolved without trusting proxy headers.
+func FromRequest(r *http.Request) string {
+	if client, ok := r.Context().Value(clientKey).(string); ok {
+		return client
+	}
+	return resolve(r, false)
+}
+
+// resolve returns the client IP, preferring the first X-Forwarded-For
+// entry when the proxy is trusted. Connections without a remote address
+// get a key of their own.
+func resolve(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil && host != "" {
+		return host
+	}
+	if id, ok := r.Context().Value(connKey).(uint64); ok {
+		return "conn-" + strconv.FormatUint(id, 10)
+	}
+	return r.RemoteAddr
+}
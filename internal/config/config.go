@@ -1,7 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -13,76 +17,303 @@ type Config struct {
 	Database   DatabaseConfig   `yaml:"database"`
 	Thumbnails ThumbnailsConfig `yaml:"thumbnails"`
 	Logging    LoggingConfig    `yaml:"logging"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Playback   PlaybackConfig   `yaml:"playback"`
+	Cache      CacheConfig      `yaml:"cache"`
+	Metadata   MetadataConfig   `yaml:"metadata"`
 }
 
 type ServerConfig struct {
 	Host         string        `yaml:"host"`
 	Port         int           `yaml:"port"`
+	UnixSocket   string        `yaml:"unix_socket"` // listen on this socket path instead of host:port
 	ReadTimeout  time.Duration `yaml:"read_timeout"`
 	WriteTimeout time.Duration `yaml:"write_timeout"`
+	JSONCase     string        `yaml:"json_case"` // snake or camel
+
+	MaxConcurrentStreams int           `yaml:"max_concurrent_streams"` // 0 = unlimited
+	HLSIdleTimeout       time.Duration `yaml:"hls_idle_timeout"`       // stop HLS transcodes not requested for this long
+	HLSDir               string        `yaml:"hls_dir"`                // segments of running HLS transcodes, emptied on startup
+	RemuxMKV             bool          `yaml:"remux_mkv"`              // stream browser-safe MKV/AVI as MP4 from /stream
+	TranscodeAudio       bool          `yaml:"transcode_audio"`        // convert audio browsers can't decode to AAC in /stream
+
+	DefaultPageSize int `yaml:"default_page_size"` // limit used when a paginated request has none
+	MaxPageSize     int `yaml:"max_page_size"`     // larger limits are clamped to this
+
+	TrustProxy bool            `yaml:"trust_proxy"` // take the client IP from X-Forwarded-For
+	RateLimit  RateLimitConfig `yaml:"rate_limit"`
+
+	CompressionLevel int `yaml:"compression_level"` // gzip level for JSON responses, 1 (fastest) - 9 (smallest), 0 = off
+}
+
+// RateLimitConfig limits requests per client IP with a token bucket
+type RateLimitConfig struct {
+	RPS   int `yaml:"rps"`   // sustained requests per second, 0 = disabled
+	Burst int `yaml:"burst"` // requests allowed in a burst, 0 = same as rps
 }
 
 type LibraryConfig struct {
-	Path string `yaml:"path"`
-	Name string `yaml:"name"`
+	Path        string   `yaml:"path"`
+	Name        string   `yaml:"name"`
+	CleanTitles bool     `yaml:"clean_titles"` // strip release tags from filename-derived titles
+	StripTokens []string `yaml:"strip_tokens"` // tokens that mark the end of the title
+	StripYears  bool     `yaml:"strip_years"`  // also remove release years from titles
+	ReadNFO     bool     `yaml:"read_nfo"`     // read title/year/plot/genre from .nfo sidecars
+	ScanNice    int      `yaml:"scan_nice"`    // nice level (1-19) for scans and background ffmpeg/ffprobe, 0 = off
+
+	EnrichWebhook string `yaml:"enrich_webhook"` // URL called with each newly scanned item
+	ScanWebhook   string `yaml:"scan_webhook"`   // URL receiving a summary after each scan
+
+	MountRetries    int           `yaml:"mount_retries"`     // retries when the library mount drops mid-scan
+	MountRetryDelay time.Duration `yaml:"mount_retry_delay"` // first retry delay, doubled on each retry
+	ProbeInterval   time.Duration `yaml:"probe_interval"`    // how often to check the library is reachable, 0 = off
+
+	Watch bool `yaml:"watch"` // scan added/removed videos as they change on disk
+
+	TrashRetention    time.Duration `yaml:"trash_retention"`     // how long missing media is kept soft-deleted before it is purged
+	StableIDs         bool          `yaml:"stable_ids"`          // keep IDs of moved files, recognized by a partial content hash
+	CleanupMaxMissing float64       `yaml:"cleanup_max_missing"` // share of media that may go missing in one scan before cleanup is skipped
 }
 
 type DatabaseConfig struct {
-	Path string `yaml:"path"`
+	Path               string        `yaml:"path"`
+	MaxOpenConns       int           `yaml:"max_open_conns"`      // connections for parallel reads, writes still go one at a time
+	CheckpointInterval time.Duration `yaml:"checkpoint_interval"` // how often the WAL is truncated, 0 = leave it to SQLite
 }
 
 type ThumbnailsConfig struct {
 	OutputDir     string `yaml:"output_dir"`
 	CacheCapacity int    `yaml:"cache_capacity"`
 	CacheMaxSize  int64  `yaml:"cache_max_size"` // bytes
+	Strategy      string `yaml:"strategy"`       // fixed, thumbnail_filter, scene
+	StoreInDB     bool   `yaml:"store_in_db"`    // persist thumbnail bytes in the database
+
+	SeekPercent float64 `yaml:"seek_percent"` // how far into the video thumbnails are taken (0 - 100)
+	Width       int     `yaml:"width"`        // thumbnail width in pixels
+	Quality     int     `yaml:"quality"`      // ffmpeg JPEG quality, 1 (best) - 31
+	Format      string  `yaml:"format"`       // jpeg, or webp for clients that accept it
+
+	SpriteTiles     int `yaml:"sprite_tiles"`      // frames in a scrubbing sprite sheet
+	SpriteTileWidth int `yaml:"sprite_tile_width"` // width of each sprite tile in pixels
+
+	MaxAttempts    int `yaml:"max_attempts"`    // failed generations before a file is skipped, 0 = unlimited
+	PrewarmWorkers int `yaml:"prewarm_workers"` // thumbnails generated in parallel for prewarm requests
+	MaxConcurrent  int `yaml:"max_concurrent"`  // ffmpeg processes for thumbnails and sprites at once
+
+	ThrottleMin time.Duration `yaml:"throttle_min"` // shortest pause between background items
+	ThrottleMax time.Duration `yaml:"throttle_max"` // longest pause, reached while ffmpeg is slow
+
+	PosterDir     string `yaml:"poster_dir"`      // uploaded posters, keyed by media ID
+	PosterMaxSize int64  `yaml:"poster_max_size"` // bytes, larger uploads are rejected
+}
+
+type AuthConfig struct {
+	APIKey      string        `yaml:"api_key"`      // key required on all API requests except health (empty = no authentication)
+	ShareSecret string        `yaml:"share_secret"` // HMAC secret for signed share links (empty = sharing disabled, needs api_key)
+	ShareTTL    time.Duration `yaml:"share_ttl"`    // default share link lifetime
+}
+
+// CacheConfig configures scratch space for transient generated artifacts.
+// Thumbnails keep their own directory.
+type CacheConfig struct {
+	Dir             string        `yaml:"dir"`
+	MaxSize         int64         `yaml:"max_size"`         // bytes, 0 = unlimited
+	MaxAge          time.Duration `yaml:"max_age"`          // 0 = no age limit
+	JanitorInterval time.Duration `yaml:"janitor_interval"` // 0 = clean on startup only
+}
+
+type MetadataConfig struct {
+	Timeout time.Duration `yaml:"timeout"` // max time for one ffprobe run, 0 = no limit
+}
+
+type PlaybackConfig struct {
+	ContinueMin float64 `yaml:"continue_min"` // progress above which an item appears in continue watching
+	ContinueMax float64 `yaml:"continue_max"` // progress from which an item leaves continue watching
+	WatchedAt   float64 `yaml:"watched_at"`   // progress from which an item counts as watched
 }
 
 type LoggingConfig struct {
 	Level  string `yaml:"level"`
-	Pretty bool   `yaml:"pretty"`
+	Pretty bool   `yaml:"pretty"` // human-readable output on stdout, files always get JSON
+
+	File       string `yaml:"file"`        // also write logs to this file, rotated by size
+	MaxSizeMB  int    `yaml:"max_size_mb"` // rotate the file when it would grow past this, 0 = never
+	MaxBackups int    `yaml:"max_backups"` // rotated files kept next to it (file.1, file.2, ...)
+	Stdout     bool   `yaml:"stdout"`      // keep logging to stdout when a file is set
+
+	DebugRequests bool `yaml:"debug_requests"` // log request headers and small JSON bodies at debug level
 }
 
-func Load(path string) (*Config, error) {
-	cfg := &Config{
+// Load returns the defaults overlaid with the YAML files at paths, in order,
+// and then with RVCINEMA_* environment variables, see EnvPrefix. Each file
+// only overrides the settings it contains, so a later file can change a
+// single value of an earlier one. Files that don't exist are skipped and
+// returned as missing.
+func Load(paths ...string) (cfg *Config, missing []string, err error) {
+	cfg = &Config{
 		Server: ServerConfig{
 			Host:         "0.0.0.0",
 			Port:         6540,
 			ReadTimeout:  30 * time.Second,
 			WriteTimeout: 0,
+			JSONCase:     "snake",
+
+			HLSIdleTimeout: time.Minute,
+			HLSDir:         "data/hls",
+
+			DefaultPageSize: 100,
+			MaxPageSize:     500,
+
+			CompressionLevel: 5,
 		},
 		Library: LibraryConfig{
-			Path: "",
-			Name: "Media Library",
+			Path:        "",
+			Name:        "Media Library",
+			CleanTitles: true,
+
+			MountRetries:    5,
+			MountRetryDelay: 5 * time.Second,
+			ProbeInterval:   30 * time.Second,
+
+			TrashRetention:    7 * 24 * time.Hour,
+			CleanupMaxMissing: 0.5,
 		},
 		Database: DatabaseConfig{
-			Path: "data/library.db",
+			Path:               "data/library.db",
+			MaxOpenConns:       4,
+			CheckpointInterval: 5 * time.Minute,
 		},
 		Thumbnails: ThumbnailsConfig{
 			OutputDir:     "data/thumbnails",
 			CacheCapacity: 1000,
 			CacheMaxSize:  512 * 1024 * 1024, // 512 MB
+			Strategy:      "fixed",
+			SeekPercent:   10,
+			Width:         320,
+			Quality:       2,
+			Format:        "webp",
+
+			SpriteTiles:     100,
+			SpriteTileWidth: 160,
+
+			MaxAttempts:    3,
+			PrewarmWorkers: 2,
+			MaxConcurrent:  2,
+
+			ThrottleMin: 50 * time.Millisecond,
+			ThrottleMax: 5 * time.Second,
+
+			PosterDir:     "data/posters",
+			PosterMaxSize: 10 * 1024 * 1024, // 10 MB
 		},
 		Logging: LoggingConfig{
 			Level:  "info",
 			Pretty: true,
-		},
-	}
 
-	if path == "" {
-		return cfg, nil
+			MaxSizeMB:  100,
+			MaxBackups: 3,
+			Stdout:     true,
+		},
+		Auth: AuthConfig{
+			ShareTTL: 24 * time.Hour,
+		},
+		Cache: CacheConfig{
+			Dir:             "data/cache",
+			MaxSize:         2 * 1024 * 1024 * 1024, // 2 GB
+			MaxAge:          24 * time.Hour,
+			JanitorInterval: 10 * time.Minute,
+		},
+		Metadata: MetadataConfig{
+			Timeout: 30 * time.Second,
+		},
+		Playback: PlaybackConfig{
+			ContinueMin: 0.02,
+			ContinueMax: 0.95,
+			WatchedAt:   0.95,
+		},
 	}
 
-	data, err := os.ReadFile(path)
-	if err != nil {
+	// Precedence: environment variables, then the files from last to
+	// first, then the defaults above. A missing file is not an error.
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
 		if os.IsNotExist(err) {
-			return cfg, nil
+			missing = append(missing, path)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
 		}
-		return nil, err
+		// Unmarshaling into the loaded config keeps what the file leaves out
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, nil, err
 	}
 
-	if err := yaml.Unmarshal(data, cfg); err != nil {
-		return nil, err
+	return cfg, missing, nil
+}
+
+// Changed returns the yaml paths (e.g. "server.port") of the settings that
+// differ between two configs
+func Changed(old, next *Config) []string {
+	return changedFields(reflect.ValueOf(*old), reflect.ValueOf(*next), "")
+}
+
+func changedFields(a, b reflect.Value, prefix string) []string {
+	var changed []string
+	for i := 0; i < a.NumField(); i++ {
+		field := a.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if field.Type.Kind() == reflect.Struct {
+			changed = append(changed, changedFields(a.Field(i), b.Field(i), name)...)
+			continue
+		}
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
 	}
+	return changed
+}
 
-	return cfg, nil
+// validate rejects values that would break components at runtime
+func (c *Config) validate() error {
+	if c.Server.CompressionLevel < 0 || c.Server.CompressionLevel > 9 {
+		return fmt.Errorf("server.compression_level must be between 0 and 9, got %d", c.Server.CompressionLevel)
+	}
+	if c.Server.JSONCase != "snake" && c.Server.JSONCase != "camel" {
+		return fmt.Errorf("server.json_case must be snake or camel, got %q", c.Server.JSONCase)
+	}
+	if c.Library.CleanupMaxMissing <= 0 || c.Library.CleanupMaxMissing > 1 {
+		return fmt.Errorf("library.cleanup_max_missing must be above 0 and at most 1, got %v", c.Library.CleanupMaxMissing)
+	}
+	if rel, err := filepath.Rel(c.Cache.Dir, c.Server.HLSDir); err == nil && !strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("server.hls_dir must be outside cache.dir, whose cleanup would delete segments of running transcodes")
+	}
+	if c.Auth.ShareSecret != "" && c.Auth.APIKey == "" {
+		return fmt.Errorf("auth.share_secret needs auth.api_key: without an API key every stream is public and share links add nothing")
+	}
+	if c.Thumbnails.SeekPercent < 0 || c.Thumbnails.SeekPercent > 100 {
+		return fmt.Errorf("thumbnails.seek_percent must be between 0 and 100, got %v", c.Thumbnails.SeekPercent)
+	}
+	if c.Thumbnails.Width <= 0 {
+		return fmt.Errorf("thumbnails.width must be positive, got %d", c.Thumbnails.Width)
+	}
+	if c.Thumbnails.Quality < 1 || c.Thumbnails.Quality > 31 {
+		return fmt.Errorf("thumbnails.quality must be between 1 and 31, got %d", c.Thumbnails.Quality)
+	}
+	if c.Thumbnails.Format != "jpeg" && c.Thumbnails.Format != "webp" {
+		return fmt.Errorf("thumbnails.format must be jpeg or webp, got %q", c.Thumbnails.Format)
+	}
+	return nil
 }
@@ -0,0 +1,68 @@
+package events
+
+import "sync"
+
+// Event types
+const (
+	PlaybackUpdated = "playback_updated"
+	ScanStarted     = "scan_started"
+	ScanCompleted   = "scan_completed"
+	FolderAdded     = "folder_added"
+	MediaAdded      = "media_added"
+	ThumbnailReady  = "thumbnail_ready"
+)
+
+// Event is a single notification published on the bus
+type Event struct {
+	Type string      `json:"type"`
+	ID   string      `json:"id,omitempty"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Bus is a minimal in-process pub-sub. Publishing never blocks:
+// events are dropped for subscribers whose buffer is full.
+type Bus struct {
+	subs map[chan Event]struct{}
+	mu   sync.RWMutex
+}
+
+// NewBus creates an empty event bus
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[chan Event]struct{}),
+	}
+}
+
+// Subscribe registers a new subscriber with the given buffer size.
+// The returned function must be called to unsubscribe.
+func (b *Bus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends an event to all subscribers without blocking
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// Slow subscriber, drop the event
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is an io.Writer appending to a log file that is rotated once
+// it would grow past a size limit: app.log becomes app.log.1, app.log.1
+// becomes app.log.2 and so on, and the oldest backup beyond the limit is
+// removed.
+type RotatingFile struct {
+	path       string
+	maxSize    int64 // bytes, 0 = never rotate
+	maxBackups int   // rotated files kept, 0 = none
+
+	file *os.File
+	size int64
+	mu   sync.Mutex
+}
+
+// OpenRotatingFile opens (or creates) the log file at path, creating its
+// directory if needed. Writes continue an existing file.
+func OpenRotatingFile(path string, maxSizeMB, maxBackups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past its limit.
+// A single write is never split across files.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups up by one and starts a new file
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if f.maxBackups > 0 {
+		os.Remove(f.backup(f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			if err := os.Rename(f.backup(i), f.backup(i+1)); err != nil && !os.IsNotExist(err) {
+				return f.reopen(err)
+			}
+		}
+		if err := os.Rename(f.path, f.backup(1)); err != nil {
+			return f.reopen(err)
+		}
+	} else if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return f.reopen(err)
+	}
+
+	return f.open()
+}
+
+// reopen continues the current file after a failed rotation and returns
+// the rotation error
+func (f *RotatingFile) reopen(rotateErr error) error {
+	if err := f.open(); err != nil {
+		return err
+	}
+	return rotateErr
+}
+
+func (f *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+// Close closes the log file; later writes fail
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,81 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Artwork types served per media item
+const (
+	ArtworkThumb    = "thumb"    // landscape video frame, generated by ffmpeg
+	ArtworkPoster   = "poster"   // portrait cover, from a sidecar image
+	ArtworkBackdrop = "backdrop" // landscape background, from a sidecar image
+)
+
+// ArtworkTypes lists all artwork types in display order
+var ArtworkTypes = []string{ArtworkPoster, ArtworkBackdrop, ArtworkThumb}
+
+// sidecarNames are the file name stems checked for each sidecar artwork type,
+// following common Kodi/Plex naming ("fanart" is Kodi's name for a backdrop)
+var sidecarNames = map[string][]string{
+	ArtworkPoster:   {"poster", "cover", "folder"},
+	ArtworkBackdrop: {"backdrop", "fanart", "background"},
+}
+
+var sidecarExtensions = []string{".jpg", ".jpeg", ".png", ".webp"}
+
+// IsArtworkType reports whether t is a known artwork type
+func IsArtworkType(t string) bool {
+	for _, known := range ArtworkTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// FindSidecarArtwork looks for a sidecar image of the given type next to a
+// video. File-specific names ("Movie-poster.jpg", "Movie.poster.jpg") win
+// over folder-wide ones ("poster.jpg"). Returns "" if none exists.
+func FindSidecarArtwork(videoPath, artworkType string) string {
+	names, ok := sidecarNames[artworkType]
+	if !ok {
+		return ""
+	}
+
+	dir := filepath.Dir(videoPath)
+	base := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+
+	var candidates []string
+	for _, name := range names {
+		candidates = append(candidates, base+"-"+name, base+"."+name)
+	}
+	candidates = append(candidates, names...)
+
+	for _, stem := range candidates {
+		for _, ext := range sidecarExtensions {
+			path := filepath.Join(dir, stem+ext)
+			if info, err := os.Stat(path); err == nil && !info.IsDir() {
+				return path
+			}
+		}
+	}
+	return ""
+}
+
+// AvailableArtwork returns the artwork types that can be served for a video
+// without generating anything; hasThumb reports whether its thumb exists
+func AvailableArtwork(videoPath string, hasThumb bool) []string {
+	var types []string
+	for _, t := range ArtworkTypes {
+		if t == ArtworkThumb {
+			if hasThumb {
+				types = append(types, t)
+			}
+		} else if FindSidecarArtwork(videoPath, t) != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
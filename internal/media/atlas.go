@@ -0,0 +1,177 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png" // decode PNG thumbnails as well
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"rvcinemaview/internal/storage"
+)
+
+// atlasColumns is the maximum number of thumbnails per atlas row
+const atlasColumns = 8
+
+// MaxAtlasItems is the most media items packed into one atlas; larger
+// folders are split into pages
+const MaxAtlasItems = 64
+
+// maxAtlasEntries is how many atlases are cached, least recently used
+// ones are dropped first
+const maxAtlasEntries = 32
+
+// AtlasRect is the position of one thumbnail within an atlas image
+type AtlasRect struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// Atlas is a single JPEG packing the thumbnails of several media items
+type Atlas struct {
+	Image  []byte
+	Width  int
+	Height int
+	Rects  map[string]AtlasRect // media ID -> position
+	ETag   string               // changes with the items or their thumbnails
+}
+
+// atlasCache holds up to maxAtlasEntries atlases, one per folder page. An
+// entry is valid while its key, built from the page's media IDs and the
+// thumbnail version, is unchanged.
+type atlasCache struct {
+	entries map[string]*atlasEntry
+	mu      sync.Mutex
+}
+
+type atlasEntry struct {
+	key      string
+	atlas    *Atlas
+	lastUsed time.Time
+}
+
+// get returns the cached atlas of a page if its key still matches
+func (c *atlasCache) get(page, key string) (*Atlas, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[page]
+	if !ok || entry.key != key {
+		return nil, false
+	}
+	entry.lastUsed = time.Now()
+	return entry.atlas, true
+}
+
+// set caches the atlas of a page, dropping the least recently used entry
+// when the cache is full
+func (c *atlasCache) set(page, key string, atlas *Atlas) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[page]; !ok && len(c.entries) >= maxAtlasEntries {
+		var oldest string
+		var oldestUsed time.Time
+		for p, entry := range c.entries {
+			if oldest == "" || entry.lastUsed.Before(oldestUsed) {
+				oldest, oldestUsed = p, entry.lastUsed
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[page] = &atlasEntry{key: key, atlas: atlas, lastUsed: time.Now()}
+}
+
+// FolderAtlas returns the thumbnail atlas for one page of a folder's media
+// items, starting at offset; callers pass at most MaxAtlasItems. Only
+// thumbnails that already exist are included; missing ones are not
+// generated. The atlas is cached until the page's items or any thumbnail
+// change.
+func (s *ThumbnailService) FolderAtlas(folderID string, offset int, items []storage.MediaItem) (*Atlas, error) {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	page := folderID + "@" + strconv.Itoa(offset)
+	key := strings.Join(ids, ",") + "@" + s.version()
+
+	if atlas, ok := s.atlases.get(page, key); ok {
+		return atlas, nil
+	}
+
+	thumbs := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		if data, ok := s.storedThumbnail(id); ok {
+			thumbs[id] = data
+		}
+	}
+
+	atlas, err := buildAtlas(ids, thumbs)
+	if err != nil {
+		return nil, err
+	}
+	atlas.ETag = fmt.Sprintf(`"atlas-%08x"`, crc32.ChecksumIEEE([]byte(page+"/"+key)))
+
+	s.atlases.set(page, key, atlas)
+	return atlas, nil
+}
+
+// buildAtlas lays the thumbnails out on a grid of equally sized cells, in
+// the order of ids. Undecodable thumbnails are skipped.
+func buildAtlas(ids []string, thumbs map[string][]byte) (*Atlas, error) {
+	type decoded struct {
+		id  string
+		img image.Image
+	}
+
+	var images []decoded
+	cellW, cellH := 0, 0
+	for _, id := range ids {
+		data, ok := thumbs[id]
+		if !ok {
+			continue
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		b := img.Bounds()
+		cellW = max(cellW, b.Dx())
+		cellH = max(cellH, b.Dy())
+		images = append(images, decoded{id: id, img: img})
+	}
+
+	atlas := &Atlas{Rects: make(map[string]AtlasRect, len(images))}
+	if len(images) == 0 {
+		return atlas, nil
+	}
+
+	cols := min(len(images), atlasColumns)
+	rows := (len(images) + cols - 1) / cols
+	atlas.Width = cols * cellW
+	atlas.Height = rows * cellH
+
+	canvas := image.NewRGBA(image.Rect(0, 0, atlas.Width, atlas.Height))
+	for i, d := range images {
+		b := d.img.Bounds()
+		x, y := (i%cols)*cellW, (i/cols)*cellH
+		draw.Draw(canvas, image.Rect(x, y, x+b.Dx(), y+b.Dy()), d.img, b.Min, draw.Src)
+		atlas.Rects[d.id] = AtlasRect{X: x, Y: y, Width: b.Dx(), Height: b.Dy()}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, canvas, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	atlas.Image = buf.Bytes()
+
+	return atlas, nil
+}
@@ -0,0 +1,86 @@
+package media
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Checksum algorithms supported by FileChecksum
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumMD5    = "md5"
+)
+
+var checksumHashes = map[string]func() hash.Hash{
+	ChecksumSHA256: sha256.New,
+	ChecksumMD5:    md5.New,
+}
+
+// IsChecksumAlgorithm reports whether FileChecksum supports an algorithm
+func IsChecksumAlgorithm(algorithm string) bool {
+	_, ok := checksumHashes[algorithm]
+	return ok
+}
+
+// FileChecksum streams a file through the given algorithm (ChecksumSHA256
+// or ChecksumMD5) and returns the hex digest
+func FileChecksum(path, algorithm string) (string, error) {
+	newHash, ok := checksumHashes[algorithm]
+	if !ok {
+		return "", fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := newHash()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// contentHashChunk is how much of each end of a file ContentHash reads
+const contentHashChunk = 64 * 1024
+
+// ContentHash fingerprints a file from its size and its first and last
+// 64 KiB. It reads at most 128 KiB, so unlike FileChecksum it is cheap
+// enough to run for every new file during a scan.
+func ContentHash(path string, size int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\n", size)
+	if _, err := io.CopyN(hash, file, contentHashChunk); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if size > contentHashChunk {
+		// The tail starts after the head for files under two chunks
+		tail := size - contentHashChunk
+		if tail < contentHashChunk {
+			tail = contentHashChunk
+		}
+		if _, err := file.Seek(tail, io.SeekStart); err != nil {
+			return "", err
+		}
+		if _, err := io.CopyN(hash, file, size-tail); err != nil && err != io.EOF {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,121 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+	"rvcinemaview/internal/storage"
+)
+
+// enrichQueueSize bounds pending webhook calls; newer items are dropped when full
+const enrichQueueSize = 1000
+
+// Enricher posts newly scanned items to an external metadata webhook and
+// applies the overrides it returns. Calls are queued and made by a single
+// worker so scanning never waits on the webhook.
+type Enricher struct {
+	url     string
+	storage *storage.SQLiteStorage
+	client  *http.Client
+	queue   chan storage.MediaItem
+	logger  zerolog.Logger
+}
+
+type enrichRequest struct {
+	MediaID string `json:"media_id"`
+	Title   string `json:"title"`
+	Path    string `json:"path"`
+}
+
+type enrichResponse struct {
+	Title     *string  `json:"title"`
+	PosterURL *string  `json:"poster_url"`
+	Tags      []string `json:"tags"`
+}
+
+// NewEnricher creates an enricher for the given webhook URL
+func NewEnricher(url string, store *storage.SQLiteStorage, logger zerolog.Logger) *Enricher {
+	return &Enricher{
+		url:     url,
+		storage: store,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan storage.MediaItem, enrichQueueSize),
+		logger:  logger,
+	}
+}
+
+// Enqueue schedules a webhook call for a new media item without blocking
+func (e *Enricher) Enqueue(item storage.MediaItem) {
+	select {
+	case e.queue <- item:
+	default:
+		e.logger.Warn().Str("id", item.ID).Msg("enrichment queue full, skipping item")
+	}
+}
+
+// Start runs the webhook worker until the context is cancelled
+func (e *Enricher) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case item := <-e.queue:
+				if err := e.enrich(ctx, item); err != nil {
+					e.logger.Warn().Err(err).Str("id", item.ID).Msg("enrichment webhook failed")
+				}
+			}
+		}
+	}()
+}
+
+func (e *Enricher) enrich(ctx context.Context, item storage.MediaItem) error {
+	body, err := json.Marshal(enrichRequest{
+		MediaID: item.ID,
+		Title:   item.Title,
+		Path:    item.Path,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	var overrides enrichResponse
+	if err := json.NewDecoder(resp.Body).Decode(&overrides); err != nil {
+		return fmt.Errorf("invalid webhook response: %w", err)
+	}
+
+	if overrides.Title != nil && *overrides.Title == "" {
+		overrides.Title = nil
+	}
+
+	if err := e.storage.ApplyEnrichment(item.ID, overrides.Title, overrides.PosterURL, overrides.Tags); err != nil {
+		return err
+	}
+
+	e.logger.Debug().Str("id", item.ID).Msg("enrichment applied")
+	return nil
+}
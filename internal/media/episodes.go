@@ -0,0 +1,173 @@
+package media
+
+import (
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"rvcinemaview/internal/storage"
+)
+
+// episodeRe matches "S01E02" / "s1e2" and "1x02" episode markers
+var episodeRe = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:s(\d{1,2})[ ._-]?e(\d{1,3})|(\d{1,2})x(\d{2,3}))(?:[^0-9]|$)`)
+
+// minSeriesEpisodes is how many episode-named files a folder needs before
+// it is inferred to be a series
+const minSeriesEpisodes = 2
+
+// ParseEpisode extracts the season and episode numbers from a filename
+func ParseEpisode(name string) (season, episode int, ok bool) {
+	m := episodeRe.FindStringSubmatch(name)
+	if m == nil {
+		return 0, 0, false
+	}
+	if m[1] != "" {
+		season, _ = strconv.Atoi(m[1])
+		episode, _ = strconv.Atoi(m[2])
+	} else {
+		season, _ = strconv.Atoi(m[3])
+		episode, _ = strconv.Atoi(m[4])
+	}
+	return season, episode, true
+}
+
+// ParseSeriesName returns the show name in front of the episode marker of
+// a filename, e.g. "The Office" for "The.Office.S02E05.1080p.mkv", or ""
+// if there is no marker or nothing before it
+func ParseSeriesName(name string) string {
+	loc := episodeRe.FindStringIndex(name)
+	if loc == nil {
+		return ""
+	}
+	show := name[:loc[0]]
+	if !strings.Contains(show, " ") {
+		show = strings.NewReplacer(".", " ", "_", " ").Replace(show)
+	}
+	show = spacesRe.ReplaceAllString(show, " ")
+	return strings.Trim(show, " -[(")
+}
+
+// LooksLikeSeries reports whether a set of video filenames is a series.
+// Inference is conservative: every file must carry an episode marker and
+// there must be at least minSeriesEpisodes of them.
+func LooksLikeSeries(names []string) bool {
+	if len(names) < minSeriesEpisodes {
+		return false
+	}
+	for _, name := range names {
+		if _, _, ok := ParseEpisode(name); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// SortEpisodes fills in season/episode numbers for items scanned before
+// they were stored and orders items by season, episode, then natural
+// filename order for anything without a marker
+func SortEpisodes(items []storage.MediaItem) {
+	for i := range items {
+		if items[i].Season != nil {
+			continue
+		}
+		if season, episode, ok := ParseEpisode(filepath.Base(items[i].Path)); ok {
+			items[i].Season = &season
+			items[i].Episode = &episode
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		switch {
+		case a.Season != nil && b.Season != nil:
+			if *a.Season != *b.Season {
+				return *a.Season < *b.Season
+			}
+			if *a.Episode != *b.Episode {
+				return *a.Episode < *b.Episode
+			}
+		case a.Season != nil:
+			return true
+		case b.Season != nil:
+			return false
+		}
+		return naturalLess(a.FileName, b.FileName)
+	})
+}
+
+// SeriesGroup is the episodes of one show found in a folder of mixed videos
+type SeriesGroup struct {
+	Name  string              `json:"name"`
+	Media []storage.MediaItem `json:"media"`
+}
+
+// GroupSeries splits items into shows with at least minSeriesEpisodes
+// episodes (matched on series name, ignoring case) and the remaining items
+// in their original order. Shows are ordered by name, episodes by SortEpisodes.
+func GroupSeries(items []storage.MediaItem) ([]SeriesGroup, []storage.MediaItem) {
+	byName := make(map[string][]storage.MediaItem)
+	for _, item := range items {
+		if item.SeriesName != nil {
+			key := strings.ToLower(*item.SeriesName)
+			byName[key] = append(byName[key], item)
+		}
+	}
+
+	var groups []SeriesGroup
+	grouped := make(map[string]bool)
+	for key, episodes := range byName {
+		if len(episodes) < minSeriesEpisodes {
+			continue
+		}
+		grouped[key] = true
+		SortEpisodes(episodes)
+		groups = append(groups, SeriesGroup{Name: *episodes[0].SeriesName, Media: episodes})
+	}
+	if len(groups) == 0 {
+		return nil, items
+	}
+	sort.Slice(groups, func(i, j int) bool { return naturalLess(groups[i].Name, groups[j].Name) })
+
+	rest := make([]storage.MediaItem, 0, len(items))
+	for _, item := range items {
+		if item.SeriesName == nil || !grouped[strings.ToLower(*item.SeriesName)] {
+			rest = append(rest, item)
+		}
+	}
+	return groups, rest
+}
+
+// naturalLess compares strings case-insensitively, treating digit runs as
+// numbers so "Part 2" sorts before "Part 10"
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		ra, rb := rune(a[0]), rune(b[0])
+		if unicode.IsDigit(ra) && unicode.IsDigit(rb) {
+			na, restA := leadingNumber(a)
+			nb, restB := leadingNumber(b)
+			if na != nb {
+				return na < nb
+			}
+			a, b = restA, restB
+			continue
+		}
+		if ra != rb {
+			return ra < rb
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingNumber(s string) (uint64, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, _ := strconv.ParseUint(s[:i], 10, 64)
+	return n, s[i:]
+}
@@ -0,0 +1,43 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// MaxFrameWidth caps the width of frames extracted with ExtractFrame
+const MaxFrameWidth = 1920
+
+// ExtractFrame writes the frame at the given position (seconds) as a JPEG
+// to outputPath, scaled down to at most width pixels wide. Smaller videos
+// keep their own width.
+func (t *ThumbnailGenerator) ExtractFrame(ctx context.Context, videoPath, outputPath string, seconds float64, width int) error {
+	// Written next to the target and renamed so concurrent requests for the
+	// same frame never serve a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), "frame-*.jpg")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := []string{
+		"-ss", fmt.Sprintf("%.3f", seconds),
+		"-i", videoPath,
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", width),
+		"-vframes", "1",
+		"-q:v", strconv.Itoa(t.quality),
+		"-y",
+		tmp.Name(),
+	}
+	if err := t.run(ctx, args, videoPath); err != nil {
+		return err
+	}
+	if !fileNotEmpty(tmp.Name()) {
+		return fmt.Errorf("no frame at %.3fs", seconds)
+	}
+	return os.Rename(tmp.Name(), outputPath)
+}
@@ -0,0 +1,91 @@
+package media
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+	"rvcinemaview/internal/storage"
+)
+
+// LibraryMonitor periodically probes the library root so the API can tell
+// "storage unavailable" apart from "file missing"
+type LibraryMonitor struct {
+	path    string
+	storage *storage.SQLiteStorage
+	logger  zerolog.Logger
+	online  atomic.Bool
+}
+
+// NewLibraryMonitor creates a monitor for the library root. It reports the
+// library as online until the first probe says otherwise.
+func NewLibraryMonitor(path string, store *storage.SQLiteStorage, logger zerolog.Logger) *LibraryMonitor {
+	m := &LibraryMonitor{
+		path:    path,
+		storage: store,
+		logger:  logger,
+	}
+	m.online.Store(true)
+	return m
+}
+
+// Online reports the result of the latest probe
+func (m *LibraryMonitor) Online() bool {
+	return m.online.Load()
+}
+
+// Start probes immediately and then on every interval until ctx is done
+func (m *LibraryMonitor) Start(ctx context.Context, interval time.Duration) {
+	m.Check()
+
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.Check()
+			}
+		}
+	}()
+}
+
+// Check probes the library root now, logs state changes and returns the result
+func (m *LibraryMonitor) Check() bool {
+	online := m.probe()
+	if m.online.Swap(online) != online {
+		if online {
+			m.logger.Info().Str("path", m.path).Msg("library is back online")
+		} else {
+			m.logger.Warn().Str("path", m.path).Msg("library is offline")
+		}
+	}
+	return online
+}
+
+// probe treats an unreadable root as offline. An empty root is offline too
+// when the database has media, since that's what an unmounted mount point
+// looks like.
+func (m *LibraryMonitor) probe() bool {
+	entries, err := os.ReadDir(m.path)
+	if err != nil {
+		return false
+	}
+	if len(entries) > 0 {
+		return true
+	}
+
+	hasMedia, err := m.storage.HasMediaItems()
+	if err != nil {
+		return true // can't tell, assume the library is genuinely empty
+	}
+	return !hasMedia
+}
@@ -0,0 +1,18 @@
+package media
+
+import (
+	"regexp"
+)
+
+// introChapterRe matches chapter titles commonly used for opening sequences
+var introChapterRe = regexp.MustCompile(`(?i)^\s*(intro|introduction|opening|opening credits|opening titles|op|title sequence)\s*$`)
+
+// DetectIntro returns the span of the first chapter titled like an intro
+func DetectIntro(chapters []Chapter) (start, end float64, ok bool) {
+	for _, ch := range chapters {
+		if ch.End > ch.Start && introChapterRe.MatchString(ch.Title) {
+			return ch.Start, ch.End, true
+		}
+	}
+	return 0, 0, false
+}
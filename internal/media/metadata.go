@@ -1,26 +1,56 @@
 package media
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
+	"rvcinemaview/internal/storage"
 )
 
 type Metadata struct {
-	Duration      int64  // seconds
+	Duration      int64 // seconds, 0 if unknown
 	Width         int
 	Height        int
 	VideoCodec    string
 	AudioCodec    string
 	AudioChannels int // number of audio channels (2 = stereo, 6 = 5.1, etc.)
+	AudioTracks   int // number of audio streams
+	HasSubtitles  bool
 	Bitrate       int64
+	Chapters      []Chapter
+	Tracks        storage.MediaTracks // audio and subtitle streams
+
+	// DurationUnknown is set when no plausible duration could be read from
+	// the container or streams; CountDuration can still find it by decoding
+	DurationUnknown bool
+}
+
+// Chapter is a named section of a media file, in seconds
+type Chapter struct {
+	Title string
+	Start float64
+	End   float64
 }
 
+// ErrMetadataTimeout is returned when ffprobe doesn't finish within the
+// configured timeout, typically on corrupt files or stalled network mounts
+var ErrMetadataTimeout = errors.New("ffprobe timed out")
+
+// maxPlausibleDuration is the longest duration (seconds) taken at face value.
+// Broken timestamps in TS/VOB captures often report close to the 26.5h MPEG
+// timestamp wraparound instead of the real length.
+const maxPlausibleDuration = 24 * 60 * 60
+
 type MetadataExtractor struct {
 	ffprobePath string
+	nice        int
+	timeout     time.Duration // 0 = no timeout
 	logger      zerolog.Logger
 }
 
@@ -37,22 +67,45 @@ func NewMetadataExtractor(logger zerolog.Logger) *MetadataExtractor {
 	}
 }
 
+// SetNice runs background ffprobe work with a lowered CPU/IO priority
+// (0 = unchanged), see background
+func (m *MetadataExtractor) SetNice(nice int) {
+	m.nice = nice
+}
+
+// SetTimeout limits how long a single ffprobe run may take (0 = no limit)
+func (m *MetadataExtractor) SetTimeout(timeout time.Duration) {
+	m.timeout = timeout
+}
+
 func (m *MetadataExtractor) IsAvailable() bool {
 	_, err := exec.LookPath(m.ffprobePath)
 	return err == nil
 }
 
-func (m *MetadataExtractor) Extract(filePath string) (*Metadata, error) {
+// Extract probes a file with ffprobe; ctx cancels the run
+func (m *MetadataExtractor) Extract(ctx context.Context, filePath string) (*Metadata, error) {
 	args := []string{
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_format",
 		"-show_streams",
+		"-show_chapters",
 		filePath,
 	}
 
-	cmd := exec.Command(m.ffprobePath, args...)
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	cmd := niceCommand(ctx, m.nice, m.ffprobePath, args...)
 	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		m.logger.Warn().Str("file", filePath).Dur("timeout", m.timeout).Msg("ffprobe timed out")
+		return nil, ErrMetadataTimeout
+	}
 	if err != nil {
 		m.logger.Debug().Err(err).Str("file", filePath).Msg("ffprobe failed")
 		return nil, err
@@ -62,16 +115,27 @@ func (m *MetadataExtractor) Extract(filePath string) (*Metadata, error) {
 }
 
 type ffprobeOutput struct {
-	Streams []ffprobeStream `json:"streams"`
-	Format  ffprobeFormat   `json:"format"`
+	Streams  []ffprobeStream  `json:"streams"`
+	Format   ffprobeFormat    `json:"format"`
+	Chapters []ffprobeChapter `json:"chapters"`
+}
+
+type ffprobeChapter struct {
+	StartTime string            `json:"start_time"`
+	EndTime   string            `json:"end_time"`
+	Tags      map[string]string `json:"tags"`
 }
 
 type ffprobeStream struct {
-	CodecType string `json:"codec_type"`
-	CodecName string `json:"codec_name"`
-	Width     int    `json:"width"`
-	Height    int    `json:"height"`
-	Channels  int    `json:"channels"`
+	CodecType string            `json:"codec_type"`
+	CodecName string            `json:"codec_name"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Channels  int               `json:"channels"`
+	Duration  string            `json:"duration"`
+	NbFrames  string            `json:"nb_frames"`
+	FrameRate string            `json:"r_frame_rate"`
+	Tags      map[string]string `json:"tags"`
 }
 
 type ffprobeFormat struct {
@@ -85,7 +149,12 @@ func (m *MetadataExtractor) parseOutput(output []byte) (*Metadata, error) {
 		return nil, err
 	}
 
-	meta := &Metadata{}
+	meta := &Metadata{
+		Tracks: storage.MediaTracks{
+			Audio:     []storage.MediaTrack{},
+			Subtitles: []storage.MediaTrack{},
+		},
+	}
 
 	// Parse duration
 	if probe.Format.Duration != "" {
@@ -94,7 +163,7 @@ func (m *MetadataExtractor) parseOutput(output []byte) (*Metadata, error) {
 		}
 	}
 
-	// Parse bitrate
+	// Parse bitrate; ffprobe leaves it empty or "N/A" for some containers
 	if probe.Format.BitRate != "" {
 		if br, err := strconv.ParseInt(probe.Format.BitRate, 10, 64); err == nil {
 			meta.Bitrate = br
@@ -102,15 +171,26 @@ func (m *MetadataExtractor) parseOutput(output []byte) (*Metadata, error) {
 	}
 
 	// Parse streams
+	var streamDuration, frameDuration, longestStream int64
 	for _, stream := range probe.Streams {
+		if dur := parseStreamDuration(stream); plausibleDuration(dur) && dur > longestStream {
+			longestStream = dur
+		}
 		switch stream.CodecType {
 		case "video":
 			if meta.VideoCodec == "" {
 				meta.VideoCodec = strings.ToUpper(stream.CodecName)
 				meta.Width = stream.Width
 				meta.Height = stream.Height
+				streamDuration = parseStreamDuration(stream)
+				frameDuration = parseFrameDuration(stream)
 			}
+		case "subtitle":
+			meta.HasSubtitles = true
+			meta.Tracks.Subtitles = append(meta.Tracks.Subtitles, streamTrack(stream, len(meta.Tracks.Subtitles)))
 		case "audio":
+			meta.Tracks.Audio = append(meta.Tracks.Audio, streamTrack(stream, meta.AudioTracks))
+			meta.AudioTracks++
 			if meta.AudioCodec == "" {
 				meta.AudioCodec = strings.ToUpper(stream.CodecName)
 				meta.AudioChannels = stream.Channels
@@ -118,5 +198,166 @@ func (m *MetadataExtractor) parseOutput(output []byte) (*Metadata, error) {
 		}
 	}
 
+	// Some containers (MKV, TS) only report duration on the video stream,
+	// and streaming captures may report none or a bogus one. Fall back to
+	// the video stream, then its frame count, then the longest stream.
+	for _, dur := range []int64{streamDuration, frameDuration, longestStream} {
+		if plausibleDuration(meta.Duration) {
+			break
+		}
+		meta.Duration = dur
+	}
+	if !plausibleDuration(meta.Duration) {
+		meta.Duration = 0
+		meta.DurationUnknown = true
+	}
+
+	for _, ch := range probe.Chapters {
+		start, err1 := strconv.ParseFloat(ch.StartTime, 64)
+		end, err2 := strconv.ParseFloat(ch.EndTime, 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		meta.Chapters = append(meta.Chapters, Chapter{
+			Title: ch.Tags["title"],
+			Start: start,
+			End:   end,
+		})
+	}
+
 	return meta, nil
 }
+
+// streamTrack describes an audio or subtitle stream at the given index
+// among streams of its type
+func streamTrack(stream ffprobeStream, index int) storage.MediaTrack {
+	language := stream.Tags["language"]
+	if language == "" {
+		language = "und"
+	}
+	return storage.MediaTrack{
+		Index:    index,
+		Codec:    stream.CodecName,
+		Language: language,
+		Title:    stream.Tags["title"],
+	}
+}
+
+// parseStreamDuration reads a stream's duration field, falling back to
+// the Matroska-style DURATION tag ("01:23:45.678000000")
+func parseStreamDuration(stream ffprobeStream) int64 {
+	if stream.Duration != "" {
+		if dur, err := strconv.ParseFloat(stream.Duration, 64); err == nil && dur > 0 {
+			return int64(dur)
+		}
+	}
+
+	for key, value := range stream.Tags {
+		if strings.EqualFold(key, "DURATION") {
+			return parseTimecode(value)
+		}
+	}
+
+	return 0
+}
+
+// plausibleDuration reports whether a duration in seconds can be trusted
+func plausibleDuration(dur int64) bool {
+	return dur > 0 && dur <= maxPlausibleDuration
+}
+
+// parseFrameDuration derives a stream's duration from its frame count and
+// frame rate, 0 if either is missing
+func parseFrameDuration(stream ffprobeStream) int64 {
+	frames, err := strconv.ParseInt(stream.NbFrames, 10, 64)
+	if err != nil || frames <= 0 {
+		return 0
+	}
+	rate := parseFrameRate(stream.FrameRate)
+	if rate <= 0 {
+		return 0
+	}
+	return int64(float64(frames) / rate)
+}
+
+// parseFrameRate parses an ffprobe rate such as "30000/1001" or "25"
+func parseFrameRate(value string) float64 {
+	num, den, found := strings.Cut(value, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !found {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// CountDuration finds the duration of a file whose headers don't report
+// one by decoding its first video stream and counting frames. This reads the
+// whole file, so it is only meant for items flagged DurationUnknown. The
+// probe timeout doesn't apply; ctx cancels it.
+func (m *MetadataExtractor) CountDuration(ctx context.Context, filePath string) (int64, error) {
+	args := []string{
+		"-v", "quiet",
+		"-print_format", "json",
+		"-count_frames",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=nb_read_frames,r_frame_rate",
+		filePath,
+	}
+
+	output, err := niceCommand(ctx, m.nice, m.ffprobePath, args...).Output()
+	if err != nil {
+		return 0, err
+	}
+
+	var probe struct {
+		Streams []struct {
+			NbReadFrames string `json:"nb_read_frames"`
+			FrameRate    string `json:"r_frame_rate"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(output, &probe); err != nil {
+		return 0, err
+	}
+	if len(probe.Streams) == 0 {
+		return 0, errors.New("no video stream")
+	}
+
+	dur := parseFrameDuration(ffprobeStream{
+		NbFrames:  probe.Streams[0].NbReadFrames,
+		FrameRate: probe.Streams[0].FrameRate,
+	})
+	if !plausibleDuration(dur) {
+		return 0, errors.New("no frames decoded")
+	}
+	return dur, nil
+}
+
+// parseTimecode parses "HH:MM:SS(.fraction)" into whole seconds
+func parseTimecode(value string) int64 {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return 0
+	}
+
+	hours, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0
+	}
+	minutes, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0
+	}
+
+	return hours*3600 + minutes*60 + int64(seconds)
+}
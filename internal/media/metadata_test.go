@@ -0,0 +1,87 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// parseFixture parses an ffprobe JSON fixture from testdata
+func parseFixture(t *testing.T, name string) *Metadata {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := NewMetadataExtractor(zerolog.Nop()).parseOutput(data)
+	if err != nil {
+		t.Fatalf("parseOutput: %v", err)
+	}
+	return meta
+}
+
+func TestParseOutputStreamDurationOnly(t *testing.T) {
+	meta := parseFixture(t, "ffprobe_stream_duration.json")
+
+	// No format duration: taken from the video stream's DURATION tag
+	if meta.Duration != 6733 {
+		t.Errorf("Duration = %d, want 6733", meta.Duration)
+	}
+	if meta.DurationUnknown {
+		t.Error("DurationUnknown set although the stream reports a duration")
+	}
+	if meta.Bitrate != 0 {
+		t.Errorf("Bitrate = %d, want 0 for N/A", meta.Bitrate)
+	}
+	if meta.VideoCodec != "H264" || meta.Width != 1920 || meta.Height != 1080 {
+		t.Errorf("video = %s %dx%d, want H264 1920x1080", meta.VideoCodec, meta.Width, meta.Height)
+	}
+	if meta.AudioCodec != "AC3" || meta.AudioChannels != 6 || meta.AudioTracks != 1 {
+		t.Errorf("audio = %s %d channels %d tracks, want AC3 6 channels 1 track", meta.AudioCodec, meta.AudioChannels, meta.AudioTracks)
+	}
+	if !meta.HasSubtitles || len(meta.Tracks.Subtitles) != 1 {
+		t.Fatalf("subtitles = %v %v, want one track", meta.HasSubtitles, meta.Tracks.Subtitles)
+	}
+	if sub := meta.Tracks.Subtitles[0]; sub.Language != "ger" || sub.Title != "Forced" {
+		t.Errorf("subtitle track = %+v, want ger \"Forced\"", sub)
+	}
+}
+
+func TestParseOutputFormatDuration(t *testing.T) {
+	meta := parseFixture(t, "ffprobe_format_duration.json")
+
+	if meta.Duration != 5400 {
+		t.Errorf("Duration = %d, want 5400", meta.Duration)
+	}
+	if meta.Bitrate != 8000000 {
+		t.Errorf("Bitrate = %d, want 8000000", meta.Bitrate)
+	}
+	if meta.HasSubtitles {
+		t.Error("HasSubtitles set without subtitle streams")
+	}
+}
+
+func TestParseStreamDuration(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream ffprobeStream
+		want   int64
+	}{
+		{"duration field", ffprobeStream{Duration: "125.7"}, 125},
+		{"matroska tag", ffprobeStream{Tags: map[string]string{"DURATION": "00:02:05.700000000"}}, 125},
+		{"lowercase tag", ffprobeStream{Tags: map[string]string{"duration": "01:00:00.000"}}, 3600},
+		{"field wins over tag", ffprobeStream{Duration: "10", Tags: map[string]string{"DURATION": "00:00:20"}}, 10},
+		{"unparsable field falls back to tag", ffprobeStream{Duration: "N/A", Tags: map[string]string{"DURATION": "00:00:20"}}, 20},
+		{"nothing", ffprobeStream{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseStreamDuration(tt.stream); got != tt.want {
+				t.Errorf("parseStreamDuration = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
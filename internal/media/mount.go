@@ -0,0 +1,112 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+	"time"
+)
+
+// mountErrnos are errors typically returned by an SMB/NFS mount that has dropped
+var mountErrnos = []error{
+	syscall.ENOTCONN, // transport endpoint is not connected
+	syscall.ESTALE,
+	syscall.EHOSTDOWN,
+	syscall.ETIMEDOUT,
+	syscall.ENODEV,
+	syscall.EIO,
+}
+
+// ErrLibraryUnavailable is returned when the library storage stays
+// unreachable; it aborts the running scan
+var ErrLibraryUnavailable = errors.New("library storage unavailable")
+
+// SetMountRetry retries filesystem operations that fail because the library
+// mount went away, waiting delay before the first retry and doubling it for
+// each following one (retries = 0 disables)
+func (s *Scanner) SetMountRetry(retries int, delay time.Duration) {
+	s.mountRetries = retries
+	s.mountRetryDelay = delay
+}
+
+// isMountError reports whether err looks like the library storage became
+// unavailable rather than a single file being missing. A missing path only
+// counts when the library root is gone or empty as well, which is what an
+// unmounted mount point looks like.
+func (s *Scanner) isMountError(err error) bool {
+	for _, errno := range mountErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+
+	if root := s.libraryRoot(); errors.Is(err, fs.ErrNotExist) && root != "" {
+		entries, rootErr := os.ReadDir(root)
+		return rootErr != nil || len(entries) == 0
+	}
+
+	return false
+}
+
+// libraryRoot returns the library root of the current scan
+func (s *Scanner) libraryRoot() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.root
+}
+
+// withMountRetry runs op, retrying with backoff while it fails with a mount
+// error. The scan resumes where it left off once the mount is back; if it
+// doesn't come back in time the error wraps ErrLibraryUnavailable.
+func (s *Scanner) withMountRetry(path string, op func() error) error {
+	delay := s.mountRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !s.isMountError(err) {
+			return err
+		}
+		if attempt > s.mountRetries {
+			return fmt.Errorf("%w: %w", ErrLibraryUnavailable, err)
+		}
+
+		s.logger.Warn().
+			Err(err).
+			Str("path", path).
+			Int("attempt", attempt).
+			Int("max_attempts", s.mountRetries).
+			Dur("delay", delay).
+			Msg("library storage unavailable, retrying")
+		s.record(func(sum *ScanSummary) {
+			sum.Retries++
+			sum.Degraded = true
+		})
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// readDir is os.ReadDir with mount retries
+func (s *Scanner) readDir(path string) ([]os.DirEntry, error) {
+	var entries []os.DirEntry
+	err := s.withMountRetry(path, func() error {
+		var err error
+		entries, err = os.ReadDir(path)
+		return err
+	})
+	return entries, err
+}
+
+// entryInfo is DirEntry.Info with mount retries
+func (s *Scanner) entryInfo(path string, entry os.DirEntry) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := s.withMountRetry(path, func() error {
+		var err error
+		info, err = entry.Info()
+		return err
+	})
+	return info, err
+}
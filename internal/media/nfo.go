@@ -0,0 +1,94 @@
+package media
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// NFO holds the fields read from a Kodi-style .nfo sidecar
+type NFO struct {
+	Title    string   `xml:"title"`
+	YearText string   `xml:"year"`
+	Plot     string   `xml:"plot"`
+	Outline  string   `xml:"outline"` // short summary, used when plot is missing
+	Genres   []string `xml:"genre"`
+	Year     int      `xml:"-"`
+}
+
+// ReadNFO looks for "<basename>.nfo" next to the video, then "movie.nfo"
+// in the same directory. movie.nfo describes a single film, so it is only
+// used when the video is the only one in its directory. Returns nil without
+// error if no sidecar applies.
+func ReadNFO(videoPath string) (*NFO, error) {
+	dir := filepath.Dir(videoPath)
+	base := strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath))
+
+	data, err := os.ReadFile(filepath.Join(dir, base+".nfo"))
+	if err == nil {
+		return parseNFO(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "movie.nfo"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sole, err := soleVideo(dir)
+	if err != nil || !sole {
+		return nil, err
+	}
+	return parseNFO(data)
+}
+
+// soleVideo reports whether a directory holds exactly one supported video
+func soleVideo(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	videos := 0
+	for _, entry := range entries {
+		if !entry.IsDir() && IsSupportedVideo(entry.Name()) {
+			videos++
+		}
+	}
+	return videos == 1, nil
+}
+
+// parseNFO decodes NFO XML; the root element (movie, episodedetails, ...) is not checked
+func parseNFO(data []byte) (*NFO, error) {
+	var nfo NFO
+	if err := xml.Unmarshal(data, &nfo); err != nil {
+		return nil, err
+	}
+
+	nfo.Title = strings.TrimSpace(nfo.Title)
+	nfo.Plot = strings.TrimSpace(nfo.Plot)
+	if nfo.Plot == "" {
+		nfo.Plot = strings.TrimSpace(nfo.Outline)
+	}
+	// Tolerate empty or non-numeric years instead of failing the whole file
+	if year, err := strconv.Atoi(strings.TrimSpace(nfo.YearText)); err == nil && year > 0 {
+		nfo.Year = year
+	}
+
+	genres := nfo.Genres[:0]
+	for _, g := range nfo.Genres {
+		if g = strings.TrimSpace(g); g != "" {
+			genres = append(genres, g)
+		}
+	}
+	nfo.Genres = genres
+
+	return &nfo, nil
+}
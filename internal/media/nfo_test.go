@@ -0,0 +1,52 @@
+package media
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const movieNFO = `<movie><title>The Matrix</title><year>1999</year></movie>`
+
+// writeFiles creates empty files, or files with the given content, in dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadNFOMovieNFO(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string // expected title, "" = no sidecar applies
+	}{
+		{"sole video", map[string]string{"a.mkv": "", "movie.nfo": movieNFO}, "The Matrix"},
+		{"other files don't count", map[string]string{"a.mkv": "", "a.srt": "", "movie.nfo": movieNFO}, "The Matrix"},
+		{"several videos", map[string]string{"a.mkv": "", "b.mkv": "", "movie.nfo": movieNFO}, ""},
+		{"own nfo wins", map[string]string{"a.mkv": "", "b.mkv": "", "a.nfo": "<episodedetails><title>Pilot</title></episodedetails>", "movie.nfo": movieNFO}, "Pilot"},
+		{"no sidecar", map[string]string{"a.mkv": ""}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			nfo, err := ReadNFO(filepath.Join(dir, "a.mkv"))
+			if err != nil {
+				t.Fatalf("ReadNFO: %v", err)
+			}
+			got := ""
+			if nfo != nil {
+				got = nfo.Title
+			}
+			if got != tt.want {
+				t.Errorf("title = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register decoders for DecodeConfig
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Limits for uploaded posters, in pixels
+const (
+	posterMinSize = 100
+	posterMaxSize = 8000
+)
+
+// ErrInvalidPoster is returned for uploads that aren't a usable JPEG or PNG
+var ErrInvalidPoster = errors.New("invalid poster image")
+
+// posterExtensions maps the accepted content types to the stored extension
+var posterExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+// PosterStore keeps user-uploaded posters on disk keyed by media ID
+type PosterStore struct {
+	dir string
+}
+
+func NewPosterStore(dir string) (*PosterStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &PosterStore{dir: dir}, nil
+}
+
+// validatePoster checks that data is a JPEG or PNG of sensible dimensions
+// and returns its content type. Errors wrap ErrInvalidPoster.
+func validatePoster(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	if _, ok := posterExtensions[contentType]; !ok {
+		return "", fmt.Errorf("%w: content type %s, want JPEG or PNG", ErrInvalidPoster, contentType)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidPoster, err)
+	}
+	if cfg.Width < posterMinSize || cfg.Height < posterMinSize ||
+		cfg.Width > posterMaxSize || cfg.Height > posterMaxSize {
+		return "", fmt.Errorf("%w: %dx%d, each side must be %d-%d pixels",
+			ErrInvalidPoster, cfg.Width, cfg.Height, posterMinSize, posterMaxSize)
+	}
+	return contentType, nil
+}
+
+// Save validates and stores the poster of a media item, replacing any
+// previous one
+func (p *PosterStore) Save(mediaID string, data []byte) error {
+	contentType, err := validatePoster(data)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(p.dir, mediaID+posterExtensions[contentType])
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// A poster of the other type would shadow or outlive this one
+	for _, ext := range posterExtensions {
+		if other := filepath.Join(p.dir, mediaID+ext); other != path {
+			os.Remove(other)
+		}
+	}
+	return nil
+}
+
+// Path returns the stored poster of a media item, "" if it has none
+func (p *PosterStore) Path(mediaID string) string {
+	for _, ext := range posterExtensions {
+		path := filepath.Join(p.dir, mediaID+ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Delete removes the poster of a media item, if any
+func (p *PosterStore) Delete(mediaID string) error {
+	for _, ext := range posterExtensions {
+		if err := os.Remove(filepath.Join(p.dir, mediaID+ext)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
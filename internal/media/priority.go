@@ -0,0 +1,24 @@
+package media
+
+import "context"
+
+// backgroundKey marks contexts of background work, see background
+type backgroundKey struct{}
+
+// background marks ctx as background work. Only ffmpeg and ffprobe runs
+// under such a context get the configured nice level; anything a client
+// is waiting on runs at normal priority.
+func background(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, true)
+}
+
+// interactive undoes background for work a client is waiting on
+func interactive(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundKey{}, false)
+}
+
+// isBackground reports whether ctx was marked by background
+func isBackground(ctx context.Context) bool {
+	marked, _ := ctx.Value(backgroundKey{}).(bool)
+	return marked
+}
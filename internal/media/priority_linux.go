@@ -0,0 +1,51 @@
+//go:build linux
+
+package media
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// runWithNice runs fn on a dedicated OS thread with its nice value raised.
+// The thread is never unlocked, so it is discarded when fn returns instead
+// of going back to the runtime pool with a lowered priority.
+func runWithNice(nice int, fn func() error) error {
+	if nice <= 0 {
+		return fn()
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		// Ignore failures (e.g. missing permission): scanning still works
+		_ = syscall.Setpriority(syscall.PRIO_PROCESS, syscall.Gettid(), nice)
+		result <- fn()
+	}()
+	return <-result
+}
+
+// niceCommand wraps an ffmpeg/ffprobe invocation with nice and ionice
+// (idle I/O class) when ctx is background work, a nice level is configured
+// and the tools exist. The process is killed when ctx is done.
+func niceCommand(ctx context.Context, nice int, name string, args ...string) *exec.Cmd {
+	if nice <= 0 || !isBackground(ctx) {
+		return exec.CommandContext(ctx, name, args...)
+	}
+
+	var prefix []string
+	if path, err := exec.LookPath("nice"); err == nil {
+		prefix = append(prefix, path, "-n", strconv.Itoa(nice))
+	}
+	if path, err := exec.LookPath("ionice"); err == nil {
+		prefix = append(prefix, path, "-c", "3")
+	}
+	if len(prefix) == 0 {
+		return exec.CommandContext(ctx, name, args...)
+	}
+
+	return exec.CommandContext(ctx, prefix[0], append(append(prefix[1:], name), args...)...)
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package media
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestNiceCommandOnlyForBackground(t *testing.T) {
+	if _, err := exec.LookPath("nice"); err != nil {
+		t.Skip("nice not installed")
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		nice int
+		want bool
+	}{
+		{"background", background(context.Background()), 10, true},
+		{"interactive", context.Background(), 10, false},
+		{"interactive inside background", interactive(background(context.Background())), 10, false},
+		{"nice off", background(context.Background()), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := niceCommand(tt.ctx, tt.nice, "ffmpeg", "-version")
+			if niced := filepath.Base(cmd.Path) != "ffmpeg"; niced != tt.want {
+				t.Errorf("command %q niced = %v, want %v", cmd.Args, niced, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package media
+
+import (
+	"context"
+	"os/exec"
+)
+
+// runWithNice is a no-op wrapper on platforms without per-thread priorities
+func runWithNice(nice int, fn func() error) error {
+	return fn()
+}
+
+// niceCommand runs the command unchanged on unsupported platforms
+func niceCommand(ctx context.Context, nice int, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
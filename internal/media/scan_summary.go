@@ -0,0 +1,155 @@
+package media
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"rvcinemaview/internal/events"
+	"rvcinemaview/internal/storage"
+)
+
+// ScanSummary collects counters for a single library scan
+type ScanSummary struct {
+	Path           string    `json:"path"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	DurationMs     int64     `json:"duration_ms"`
+	Added          int       `json:"added"`
+	Updated        int       `json:"updated"`
+	Unchanged      int       `json:"unchanged"`
+	Deleted        int       `json:"deleted"`
+	Moved          int       `json:"moved"` // found at a new path with stable IDs, also counted as updated
+	FoldersCreated int       `json:"folders_created"`
+	FoldersDeleted int       `json:"folders_deleted"`
+	Skipped        int       `json:"skipped"` // hidden directories left out
+	Errors         int       `json:"errors"`
+	Retries        int       `json:"retries"`  // storage retries after mount errors
+	Degraded       bool      `json:"degraded"` // library storage dropped during the scan
+	Error          string    `json:"error,omitempty"`
+}
+
+// ScanProgress describes how far the current (or last) scan has got
+type ScanProgress struct {
+	Active          bool      `json:"active"`
+	StartedAt       time.Time `json:"started_at"`
+	FilesDiscovered int       `json:"files_discovered"` // video files found so far
+	FilesProcessed  int       `json:"files_processed"`  // video files saved (or failed)
+	CurrentPath     string    `json:"current_path"`     // directory being scanned
+	Degraded        bool      `json:"degraded"`         // library storage dropped and was retried
+	RescanQueued    bool      `json:"rescan_queued"`    // another scan runs when this one finishes
+}
+
+// Progress returns a snapshot of the scan progress
+func (s *Scanner) Progress() ScanProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	progress := s.progress
+	progress.Active = s.scanning
+	progress.RescanQueued = s.queued != nil
+	if s.summary != nil {
+		progress.Degraded = s.summary.Degraded
+	}
+	return progress
+}
+
+// LastResult returns the summary of the last finished scan, or nil if no
+// scan has finished since startup
+func (s *Scanner) LastResult() *ScanSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil {
+		return nil
+	}
+	last := *s.last
+	return &last
+}
+
+// track applies a change to the scan progress under the scanner lock
+func (s *Scanner) track(fn func(p *ScanProgress)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(&s.progress)
+}
+
+// record applies a change to the current scan summary under the scanner lock.
+// Outside a scan (single-file updates) the last summary is left untouched.
+func (s *Scanner) record(fn func(sum *ScanSummary)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.summary != nil && s.scanning {
+		fn(s.summary)
+	}
+}
+
+// finishSummary closes the current summary, logs it as a single event and
+// posts it to the scan webhook if configured
+func (s *Scanner) finishSummary(scanErr error) {
+	s.mu.Lock()
+	sum := s.summary
+	if sum == nil {
+		s.mu.Unlock()
+		return
+	}
+	sum.FinishedAt = time.Now()
+	sum.DurationMs = sum.FinishedAt.Sub(sum.StartedAt).Milliseconds()
+	if scanErr != nil {
+		sum.Error = scanErr.Error()
+	}
+	summary := *sum
+	s.last = &summary
+	s.mu.Unlock()
+
+	s.logger.Info().
+		Str("path", summary.Path).
+		Int("added", summary.Added).
+		Int("updated", summary.Updated).
+		Int("unchanged", summary.Unchanged).
+		Int("deleted", summary.Deleted).
+		Int("moved", summary.Moved).
+		Int("folders_created", summary.FoldersCreated).
+		Int("folders_deleted", summary.FoldersDeleted).
+		Int("skipped", summary.Skipped).
+		Int("errors", summary.Errors).
+		Int("retries", summary.Retries).
+		Bool("degraded", summary.Degraded).
+		Int64("duration_ms", summary.DurationMs).
+		Str("error", summary.Error).
+		Msg("scan summary")
+
+	s.publish(events.ScanCompleted, "", summary)
+
+	detail := fmt.Sprintf("%d added, %d updated, %d deleted, %d moved, %d folders created, %d folders deleted, %d errors",
+		summary.Added, summary.Updated, summary.Deleted, summary.Moved, summary.FoldersCreated, summary.FoldersDeleted, summary.Errors)
+	if summary.Error != "" {
+		detail += ": " + summary.Error
+	}
+	s.audit(storage.AuditScanFinished, summary.Path, detail)
+
+	if s.scanWebhook != "" {
+		go s.postSummary(summary)
+	}
+}
+
+// postSummary sends the summary to the scan webhook (best effort)
+func (s *Scanner) postSummary(summary ScanSummary) {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(s.scanWebhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		s.logger.Warn().Err(err).Msg("scan webhook failed")
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		s.logger.Warn().Int("status", resp.StatusCode).Msg("scan webhook returned error status")
+	}
+}
@@ -3,6 +3,8 @@ package media
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,20 +12,136 @@ import (
 	"time"
 
 	"github.com/rs/zerolog"
+	"rvcinemaview/internal/events"
 	"rvcinemaview/internal/storage"
 )
 
 type Scanner struct {
 	storage  *storage.SQLiteStorage
+	titles   *TitleCleaner // nil = use raw filenames as titles
+	readNFO  bool
+	nice     int
+	enricher *Enricher   // nil = no enrichment webhook
+	events   *events.Bus // nil = no scan events
 	logger   zerolog.Logger
 	scanning bool
+	queued   *scanRequest // scan requested while one was running, nil = none
+	summary  *ScanSummary // summary of the current (or last) scan
+	last     *ScanSummary // copy of the last finished summary
+	progress ScanProgress // progress of the current (or last) scan
 	mu       sync.Mutex
+
+	scanWebhook string
+
+	root            string // library root of the current scan
+	mountRetries    int
+	mountRetryDelay time.Duration
+
+	trashRetention time.Duration // how long missing media is kept soft-deleted
+	stableIDs      bool          // recognize moved files by content hash
+
+	monitor    *LibraryMonitor // nil = no reachability probes before cleanup
+	maxMissing float64         // share of known media allowed to go missing at once
+
+	onPurge func(mediaID string) // removes files derived from purged media, nil = none
 }
 
-func NewScanner(store *storage.SQLiteStorage, logger zerolog.Logger) *Scanner {
+// scanRequest is a ScanPath call waiting for the running scan to finish
+type scanRequest struct {
+	path, name string
+}
+
+// defaultTrashRetention is used until SetTrashRetention is called
+const defaultTrashRetention = 7 * 24 * time.Hour
+
+// defaultMaxMissing is used until SetCleanupMaxMissing is called
+const defaultMaxMissing = 0.5
+
+// Libraries with fewer known media than cleanupMinKnown are cleaned up
+// whatever share of them is missing
+const cleanupMinKnown = 10
+
+func NewScanner(store *storage.SQLiteStorage, titles *TitleCleaner, readNFO bool, logger zerolog.Logger) *Scanner {
 	return &Scanner{
-		storage: store,
-		logger:  logger,
+		storage:        store,
+		titles:         titles,
+		readNFO:        readNFO,
+		logger:         logger,
+		trashRetention: defaultTrashRetention,
+		maxMissing:     defaultMaxMissing,
+	}
+}
+
+// SetNice runs scans on an OS thread with a lowered priority (0 = unchanged).
+// Only supported on Linux; a no-op elsewhere.
+func (s *Scanner) SetNice(nice int) {
+	s.nice = nice
+}
+
+// SetScanWebhook posts a scan summary to the URL after every scan
+func (s *Scanner) SetScanWebhook(url string) {
+	s.scanWebhook = url
+}
+
+// SetTrashRetention sets how long media whose file went missing stays
+// soft-deleted before it is purged (non-positive keeps the default)
+func (s *Scanner) SetTrashRetention(retention time.Duration) {
+	if retention > 0 {
+		s.trashRetention = retention
+	}
+}
+
+// SetStableIDs recognizes files moved within the library by a partial
+// content hash, so they keep their ID (and with it playback progress and
+// thumbnails) instead of being re-added. Hashing reads up to 128 KiB of
+// each new or changed file.
+func (s *Scanner) SetStableIDs(enabled bool) {
+	s.stableIDs = enabled
+}
+
+// SetLibraryMonitor skips cleanup while the monitor reports the library
+// offline
+func (s *Scanner) SetLibraryMonitor(monitor *LibraryMonitor) {
+	s.monitor = monitor
+}
+
+// SetCleanupMaxMissing sets the share of known media (0 - 1) that may go
+// missing before cleanup refuses to remove it, guarding against a library
+// that is only partly reachable. 1 never refuses; other values outside
+// (0, 1] keep the default.
+func (s *Scanner) SetCleanupMaxMissing(ratio float64) {
+	if ratio > 0 && ratio <= 1 {
+		s.maxMissing = ratio
+	}
+}
+
+// SetPurgeHook calls fn for every media item purged from the trash, to
+// remove its thumbnails, subtitles and other files kept outside the library
+func (s *Scanner) SetPurgeHook(fn func(mediaID string)) {
+	s.onPurge = fn
+}
+
+// SetEnricher enables the metadata enrichment webhook for new items
+func (s *Scanner) SetEnricher(enricher *Enricher) {
+	s.enricher = enricher
+}
+
+// SetEventBus publishes scan, folder and media events to the bus
+func (s *Scanner) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// publish sends an event if an event bus is set
+func (s *Scanner) publish(eventType, id string, data interface{}) {
+	if s.events != nil {
+		s.events.Publish(events.Event{Type: eventType, ID: id, Data: data})
+	}
+}
+
+// audit records a library change in the audit log (best effort)
+func (s *Scanner) audit(action, target, detail string) {
+	if err := s.storage.AppendAudit(action, target, detail); err != nil {
+		s.logger.Warn().Err(err).Str("action", action).Msg("failed to write audit log")
 	}
 }
 
@@ -33,33 +151,68 @@ func (s *Scanner) IsScanning() bool {
 	return s.scanning
 }
 
-// ScanPath scans a single library path with the given display name
+// ScanPath scans a single library path with the given display name. If a
+// scan is already running it returns nil at once and the running scan is
+// followed by one more; any number of requests in the meantime collapse
+// into that single rescan, which uses the latest path and name.
 func (s *Scanner) ScanPath(libraryPath, libraryName string) error {
 	s.mu.Lock()
 	if s.scanning {
+		s.queued = &scanRequest{path: libraryPath, name: libraryName}
 		s.mu.Unlock()
 		return nil
 	}
 	s.scanning = true
 	s.mu.Unlock()
 
-	defer func() {
+	for {
+		err := s.scan(libraryPath, libraryName)
+
 		s.mu.Lock()
-		s.scanning = false
+		next := s.queued
+		s.queued = nil
+		if next == nil {
+			s.scanning = false
+			s.progress.CurrentPath = ""
+			s.mu.Unlock()
+			return err
+		}
 		s.mu.Unlock()
-	}()
+
+		if err != nil {
+			s.logger.Error().Err(err).Msg("scan failed")
+		}
+		s.logger.Info().Msg("running rescan requested during the last scan")
+		libraryPath, libraryName = next.path, next.name
+	}
+}
+
+// scan runs one scan of a library path; the caller holds the scanning flag
+func (s *Scanner) scan(libraryPath, libraryName string) (err error) {
+	s.mu.Lock()
+	s.root = filepath.Clean(libraryPath)
+	s.summary = &ScanSummary{Path: libraryPath, StartedAt: time.Now()}
+	s.progress = ScanProgress{StartedAt: s.summary.StartedAt}
+	s.mu.Unlock()
+
+	// Every scan that started a summary finishes it, failed ones included
+	defer func() { s.finishSummary(err) }()
 
 	if libraryPath == "" {
-		s.logger.Warn().Msg("no library path configured")
-		return nil
+		return errors.New("no library path configured")
 	}
 
-	info, err := os.Stat(libraryPath)
+	var info os.FileInfo
+	err = s.withMountRetry(libraryPath, func() error {
+		var err error
+		info, err = os.Stat(libraryPath)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 	if !info.IsDir() {
-		return nil
+		return fmt.Errorf("library path %s is not a directory", libraryPath)
 	}
 
 	libraryPath = filepath.Clean(libraryPath)
@@ -67,54 +220,60 @@ func (s *Scanner) ScanPath(libraryPath, libraryName string) error {
 		Str("path", libraryPath).
 		Str("name", libraryName).
 		Msg("scanning library")
+	s.publish(events.ScanStarted, "", map[string]string{"path": libraryPath})
+	s.audit(storage.AuditScanStarted, libraryPath, "")
+
+	return runWithNice(s.nice, func() error {
+		// Cleanup deleted files first
+		if err := s.CleanupDeletedFiles(); errors.Is(err, ErrLibraryUnavailable) {
+			return err
+		} else if err != nil {
+			s.logger.Warn().Err(err).Msg("cleanup failed, continuing with scan")
+			s.record(func(sum *ScanSummary) {
+				sum.Errors++
+				sum.Error = "cleanup skipped: " + err.Error()
+			})
+		}
 
-	// Cleanup deleted files first
-	if err := s.CleanupDeletedFiles(); err != nil {
-		s.logger.Warn().Err(err).Msg("cleanup failed, continuing with scan")
-	}
-
-	// Scan the library directory directly - subfolders become root folders
-	return s.scanLibraryRoot(libraryPath, libraryName)
+		// Scan the library directory directly - subfolders become root folders
+		return s.scanLibraryRoot(libraryPath, libraryName)
+	})
 }
 
 // scanLibraryRoot scans the root library directory
 // Subfolders of the library become "root" folders (parent_id = NULL)
 // Media files in the root have empty folder_id and are returned at root level
 func (s *Scanner) scanLibraryRoot(libraryPath, libraryName string) error {
-	entries, err := os.ReadDir(libraryPath)
+	s.track(func(p *ScanProgress) { p.CurrentPath = libraryPath })
+
+	entries, err := s.readDir(libraryPath)
 	if err != nil {
 		return err
 	}
 
+	// Folders and media are saved in one transaction each per directory,
+	// before descending into the subfolders
+	var folders []*storage.Folder
+	var items []*storage.MediaItem
+
 	for _, entry := range entries {
 		fullPath := filepath.Join(libraryPath, entry.Name())
 
 		if entry.IsDir() {
 			// Skip hidden directories
 			if strings.HasPrefix(entry.Name(), ".") {
+				s.record(func(sum *ScanSummary) { sum.Skipped++ })
 				continue
 			}
 
 			// Create folder as root folder (parent_id = NULL)
-			folderID := generateID(fullPath)
-			folder := &storage.Folder{
-				ID:        folderID,
+			folders = append(folders, &storage.Folder{
+				ID:        generateID(fullPath),
 				Name:      entry.Name(),
 				Path:      fullPath,
 				ParentID:  nil, // Root level folder
 				CreatedAt: time.Now(),
-			}
-
-			if err := s.storage.CreateFolder(folder); err != nil {
-				s.logger.Error().Err(err).Str("path", fullPath).Msg("failed to create folder")
-				continue
-			}
-
-			// Recursively scan subfolder
-			if err := s.scanDirectory(fullPath, folderID); err != nil {
-				s.logger.Error().Err(err).Str("path", fullPath).Msg("failed to scan subfolder")
-			}
-
+			})
 			continue
 		}
 
@@ -122,46 +281,38 @@ func (s *Scanner) scanLibraryRoot(libraryPath, libraryName string) error {
 		if !IsSupportedVideo(entry.Name()) {
 			continue
 		}
+		s.track(func(p *ScanProgress) { p.FilesDiscovered++ })
 
 		// Get file info
-		info, err := entry.Info()
+		info, err := s.entryInfo(fullPath, entry)
+		if errors.Is(err, ErrLibraryUnavailable) {
+			return err
+		}
 		if err != nil {
 			s.logger.Error().Err(err).Str("path", fullPath).Msg("failed to get file info")
+			s.record(func(sum *ScanSummary) { sum.Errors++ })
 			continue
 		}
 
 		// Create media item with empty folder_id (root-level media)
-		mediaID := generateID(fullPath)
-		title := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
-
-		mediaItem := &storage.MediaItem{
-			ID:         mediaID,
-			FolderID:   "", // Empty = root level
-			Title:      title,
-			Path:       fullPath,
-			Size:       info.Size(),
-			ModifiedAt: info.ModTime(),
-			CreatedAt:  time.Now(),
-		}
-
-		if err := s.storage.CreateMediaItem(mediaItem); err != nil {
-			s.logger.Error().Err(err).Str("path", fullPath).Msg("failed to create media item")
-			continue
-		}
-
-		s.logger.Debug().Str("title", title).Int64("size", info.Size()).Msg("added root media item")
+		items = append(items, s.newMediaItem(fullPath, info, ""))
 	}
 
-	return nil
+	s.saveMediaItems("", items)
+	return s.scanSubfolders(s.saveFolders(nil, folders))
 }
 
 func (s *Scanner) scanDirectory(dirPath string, parentID string) error {
-	entries, err := os.ReadDir(dirPath)
+	s.track(func(p *ScanProgress) { p.CurrentPath = dirPath })
+
+	entries, err := s.readDir(dirPath)
 	if err != nil {
 		return err
 	}
 
-	var mediaCount int
+	var folders []*storage.Folder
+	var items []*storage.MediaItem
+	var videoNames []string
 
 	for _, entry := range entries {
 		fullPath := filepath.Join(dirPath, entry.Name())
@@ -169,29 +320,18 @@ func (s *Scanner) scanDirectory(dirPath string, parentID string) error {
 		if entry.IsDir() {
 			// Skip hidden directories
 			if strings.HasPrefix(entry.Name(), ".") {
+				s.record(func(sum *ScanSummary) { sum.Skipped++ })
 				continue
 			}
 
 			// Create subfolder
-			folderID := generateID(fullPath)
-			folder := &storage.Folder{
-				ID:        folderID,
+			folders = append(folders, &storage.Folder{
+				ID:        generateID(fullPath),
 				Name:      entry.Name(),
 				Path:      fullPath,
 				ParentID:  &parentID,
 				CreatedAt: time.Now(),
-			}
-
-			if err := s.storage.CreateFolder(folder); err != nil {
-				s.logger.Error().Err(err).Str("path", fullPath).Msg("failed to create folder")
-				continue
-			}
-
-			// Recursively scan subfolder
-			if err := s.scanDirectory(fullPath, folderID); err != nil {
-				s.logger.Error().Err(err).Str("path", fullPath).Msg("failed to scan subfolder")
-			}
-
+			})
 			continue
 		}
 
@@ -199,99 +339,609 @@ func (s *Scanner) scanDirectory(dirPath string, parentID string) error {
 		if !IsSupportedVideo(entry.Name()) {
 			continue
 		}
+		videoNames = append(videoNames, entry.Name())
+		s.track(func(p *ScanProgress) { p.FilesDiscovered++ })
 
 		// Get file info
-		info, err := entry.Info()
+		info, err := s.entryInfo(fullPath, entry)
+		if errors.Is(err, ErrLibraryUnavailable) {
+			return err
+		}
 		if err != nil {
 			s.logger.Error().Err(err).Str("path", fullPath).Msg("failed to get file info")
+			s.record(func(sum *ScanSummary) { sum.Errors++ })
 			continue
 		}
 
 		// Create media item
-		mediaID := generateID(fullPath)
-		title := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		items = append(items, s.newMediaItem(fullPath, info, parentID))
+	}
+
+	mediaCount := s.saveMediaItems(parentID, items)
 
-		mediaItem := &storage.MediaItem{
-			ID:         mediaID,
-			FolderID:   parentID,
-			Title:      title,
-			Path:       fullPath,
-			Size:       info.Size(),
-			ModifiedAt: info.ModTime(),
-			CreatedAt:  time.Now(),
+	// Update folder item count
+	if mediaCount > 0 {
+		if err := s.storage.UpdateFolderItemCount(parentID, mediaCount); err != nil {
+			s.logger.Error().Err(err).Msg("failed to update folder item count")
 		}
+	}
 
-		if err := s.storage.CreateMediaItem(mediaItem); err != nil {
-			s.logger.Error().Err(err).Str("path", fullPath).Msg("failed to create media item")
-			continue
+	if err := s.storage.SetFolderSeries(parentID, LooksLikeSeries(videoNames)); err != nil {
+		s.logger.Error().Err(err).Str("path", dirPath).Msg("failed to update folder series flag")
+	}
+
+	return s.scanSubfolders(s.saveFolders(&parentID, folders))
+}
+
+// scanSubfolders recursively scans saved folders. Errors are counted and
+// skipped, except ErrLibraryUnavailable which aborts the scan.
+func (s *Scanner) scanSubfolders(folders []*storage.Folder) error {
+	for _, folder := range folders {
+		err := s.scanDirectory(folder.Path, folder.ID)
+		if errors.Is(err, ErrLibraryUnavailable) {
+			return err
+		}
+		if err != nil {
+			s.logger.Error().Err(err).Str("path", folder.Path).Msg("failed to scan subfolder")
+			s.record(func(sum *ScanSummary) { sum.Errors++ })
+		}
+	}
+	return nil
+}
+
+// newMediaItem builds a media item for a video file, deriving the title from
+// the filename and, if enabled, an NFO sidecar
+func (s *Scanner) newMediaItem(fullPath string, info os.FileInfo, folderID string) *storage.MediaItem {
+	name := filepath.Base(fullPath)
+
+	item := &storage.MediaItem{
+		ID:         generateID(fullPath),
+		FolderID:   folderID, // Empty = root level
+		Title:      s.titles.Clean(strings.TrimSuffix(name, filepath.Ext(name))),
+		Path:       fullPath,
+		Size:       info.Size(),
+		ModifiedAt: info.ModTime(),
+		CreatedAt:  time.Now(),
+	}
+
+	if season, episode, ok := ParseEpisode(name); ok {
+		item.Season = &season
+		item.Episode = &episode
+		if show := ParseSeriesName(name); show != "" {
+			item.SeriesName = &show
+		}
+	}
+
+	if s.readNFO {
+		nfo, err := ReadNFO(fullPath)
+		if err != nil {
+			s.logger.Debug().Err(err).Str("path", fullPath).Msg("skipping malformed nfo")
+		} else if nfo != nil {
+			if nfo.Title != "" {
+				item.Title = nfo.Title
+			}
+			if nfo.Year > 0 {
+				item.Year = &nfo.Year
+			}
+			if nfo.Plot != "" {
+				item.Plot = &nfo.Plot
+			}
+			item.Genres = nfo.Genres
+		}
+	}
+
+	return item
+}
+
+// saveFolder upserts a folder and announces newly discovered ones
+func (s *Scanner) saveFolder(folder *storage.Folder) error {
+	existing, err := s.storage.GetFolder(folder.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.CreateFolder(folder); err != nil {
+		return err
+	}
+
+	if existing == nil {
+		s.record(func(sum *ScanSummary) { sum.FoldersCreated++ })
+		s.publish(events.FolderAdded, folder.ID, folder)
+	}
+	return nil
+}
+
+// saveFolders upserts the subfolders of parentID (nil for the library root)
+// in one transaction and returns the ones that were saved. If the batch
+// fails the folders are retried one by one, so one bad row doesn't drop
+// its siblings.
+func (s *Scanner) saveFolders(parentID *string, folders []*storage.Folder) []*storage.Folder {
+	if len(folders) == 0 {
+		return nil
+	}
+
+	var existing []storage.Folder
+	var err error
+	if parentID == nil {
+		existing, err = s.storage.GetRootFolders()
+	} else {
+		existing, err = s.storage.GetSubFolders(*parentID)
+	}
+	if err == nil {
+		err = s.storage.CreateFoldersBatch(folders)
+	}
+	if err != nil {
+		s.logger.Warn().Err(err).Int("folders", len(folders)).Msg("batch folder save failed, saving one by one")
+		var saved []*storage.Folder
+		for _, folder := range folders {
+			if err := s.saveFolder(folder); err != nil {
+				s.logger.Error().Err(err).Str("path", folder.Path).Msg("failed to create folder")
+				s.record(func(sum *ScanSummary) { sum.Errors++ })
+				continue
+			}
+			saved = append(saved, folder)
+		}
+		return saved
+	}
+
+	known := make(map[string]bool, len(existing))
+	for _, f := range existing {
+		known[f.ID] = true
+	}
+	for _, folder := range folders {
+		if !known[folder.ID] {
+			s.record(func(sum *ScanSummary) { sum.FoldersCreated++ })
+			s.publish(events.FolderAdded, folder.ID, folder)
+		}
+	}
+	return folders
+}
+
+// saveMediaItems upserts the media items of one folder ("" for the library
+// root) in one transaction, counts them in the scan summary and returns how
+// many were saved. Like saveFolders it falls back to one by one saves.
+func (s *Scanner) saveMediaItems(folderID string, items []*storage.MediaItem) int {
+	if len(items) == 0 {
+		return 0
+	}
+
+	existing, err := s.storage.GetMediaItemsByFolder(folderID, storage.MediaListOptions{})
+	var previous []*storage.MediaItem
+	if err == nil {
+		byPath := make(map[string]*storage.MediaItem, len(existing))
+		for i := range existing {
+			byPath[existing[i].Path] = &existing[i]
+		}
+		previous = make([]*storage.MediaItem, len(items))
+		for i, item := range items {
+			previous[i] = s.resolveMediaItem(item, byPath[item.Path])
 		}
+		err = s.storage.CreateMediaItemsBatch(items)
+	}
+	if err != nil {
+		s.logger.Warn().Err(err).Int("items", len(items)).Msg("batch media save failed, saving one by one")
+		saved := 0
+		for _, item := range items {
+			if err := s.saveMediaItem(item); err != nil {
+				s.logger.Error().Err(err).Str("path", item.Path).Msg("failed to create media item")
+				s.record(func(sum *ScanSummary) { sum.Errors++ })
+				continue
+			}
+			saved++
+		}
+		return saved
+	}
 
-		mediaCount++
+	for i, item := range items {
+		s.recordUpsert(s.mediaSaved(item, previous[i]))
+		s.track(func(p *ScanProgress) { p.FilesProcessed++ })
 		s.logger.Debug().
-			Str("title", title).
-			Int64("size", info.Size()).
+			Str("title", item.Title).
+			Int64("size", item.Size).
 			Msg("added media item")
 	}
+	return len(items)
+}
 
-	// Update folder item count
-	if mediaCount > 0 {
-		if err := s.storage.UpdateFolderItemCount(parentID, mediaCount); err != nil {
-			s.logger.Error().Err(err).Msg("failed to update folder item count")
-		}
+// saveMediaItem upserts a media item and counts it in the scan summary
+func (s *Scanner) saveMediaItem(item *storage.MediaItem) error {
+	defer s.track(func(p *ScanProgress) { p.FilesProcessed++ })
+
+	result, err := s.upsertMediaItem(item)
+	if err != nil {
+		return err
 	}
 
+	s.recordUpsert(result)
 	return nil
 }
 
+// recordUpsert counts an upsertMediaItem outcome in the scan summary
+func (s *Scanner) recordUpsert(result int) {
+	switch result {
+	case upsertAdded:
+		s.record(func(sum *ScanSummary) { sum.Added++ })
+	case upsertUpdated:
+		s.record(func(sum *ScanSummary) { sum.Updated++ })
+	default:
+		s.record(func(sum *ScanSummary) { sum.Unchanged++ })
+	}
+}
+
+// Outcomes of upsertMediaItem
+const (
+	upsertUnchanged = iota
+	upsertAdded
+	upsertUpdated
+)
+
+// upsertMediaItem saves a media item, announcing and queueing newly added
+// items for enrichment
+func (s *Scanner) upsertMediaItem(item *storage.MediaItem) (int, error) {
+	existing, err := s.storage.GetMediaItemByPath(item.Path)
+	if err != nil {
+		return 0, err
+	}
+	existing = s.resolveMediaItem(item, existing)
+
+	if err := s.storage.CreateMediaItem(item); err != nil {
+		return 0, err
+	}
+
+	return s.mediaSaved(item, existing), nil
+}
+
+// resolveMediaItem finds the stored row for a scanned item: existing, the
+// row at the item's path (nil if none), or with stable IDs a row for the
+// same content whose file is gone, which is then moved to the item's path.
+// item.ID is set to the stored row's ID and the row as it was before the
+// scan is returned, nil for new items.
+func (s *Scanner) resolveMediaItem(item, existing *storage.MediaItem) *storage.MediaItem {
+	if existing != nil {
+		item.ID = existing.ID
+	}
+	if !s.stableIDs {
+		return existing
+	}
+
+	// The stored hash is still good while the file is unchanged
+	if existing != nil && existing.ContentHash != nil &&
+		existing.Size == item.Size && existing.ModifiedAt.Equal(item.ModifiedAt) {
+		item.ContentHash = existing.ContentHash
+		return existing
+	}
+
+	hash, err := ContentHash(item.Path, item.Size)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", item.Path).Msg("failed to hash media file")
+		return existing
+	}
+	item.ContentHash = &hash
+	if existing != nil {
+		return existing
+	}
+
+	candidates, err := s.storage.FindMediaByContentHash(hash, item.Size, item.Path)
+	if err != nil {
+		s.logger.Error().Err(err).Str("path", item.Path).Msg("failed to look up moved media")
+		return nil
+	}
+	for i := range candidates {
+		moved := &candidates[i]
+		// A copy, not a move
+		if _, err := os.Stat(moved.Path); !os.IsNotExist(err) {
+			continue
+		}
+		if err := s.storage.MoveMediaItem(moved.ID, item.Path, item.FolderID); err != nil {
+			s.logger.Error().Err(err).Str("path", item.Path).Msg("failed to move media item")
+			return nil
+		}
+		item.ID = moved.ID
+		s.record(func(sum *ScanSummary) { sum.Moved++ })
+		s.audit(storage.AuditMediaMoved, item.Path, "moved from "+moved.Path)
+		s.logger.Info().Str("from", moved.Path).Str("to", item.Path).Msg("media file moved, keeping its ID")
+		return moved
+	}
+	return nil
+}
+
+// mediaSaved classifies a saved item against the row it replaced (nil if
+// it is new), announcing and queueing new items for enrichment
+func (s *Scanner) mediaSaved(item, existing *storage.MediaItem) int {
+	switch {
+	case existing == nil:
+		s.publish(events.MediaAdded, item.ID, item)
+		if s.enricher != nil {
+			s.enricher.Enqueue(*item)
+		}
+		return upsertAdded
+	case existing.Path != item.Path || existing.Size != item.Size || !existing.ModifiedAt.Equal(item.ModifiedAt):
+		return upsertUpdated
+	default:
+		return upsertUnchanged
+	}
+}
+
+// ScanFile adds or updates a single video file without a full scan. Missing
+// folders between the library root and the file are created, and the parent
+// folder's item count and series flag are refreshed.
+func (s *Scanner) ScanFile(path string) (*storage.MediaItem, error) {
+	s.mu.Lock()
+	root := s.root
+	s.mu.Unlock()
+	if root == "" {
+		return nil, fmt.Errorf("library has not been scanned yet")
+	}
+
+	path = filepath.Clean(path)
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("%s is outside the library", path)
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") {
+			return nil, fmt.Errorf("%s is hidden", path)
+		}
+	}
+	if !IsSupportedVideo(path) {
+		return nil, fmt.Errorf("%s is not a supported video", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	folderID, err := s.ensureFolders(root, filepath.Dir(rel))
+	if err != nil {
+		return nil, err
+	}
+
+	item := s.newMediaItem(path, info, folderID)
+	result, err := s.upsertMediaItem(item)
+	if err != nil {
+		return nil, err
+	}
+	if folderID != "" {
+		s.refreshFolder(filepath.Dir(path), folderID)
+	}
+
+	if result != upsertUnchanged {
+		s.logger.Info().Str("path", path).Int64("size", info.Size()).Msg("file scanned")
+	}
+	return item, nil
+}
+
+// ensureFolders creates the folder chain for a directory relative to the
+// library root and returns the ID of the innermost folder ("" for the root)
+func (s *Scanner) ensureFolders(root, relDir string) (string, error) {
+	if relDir == "." {
+		return "", nil
+	}
+
+	var parentID *string
+	dir := root
+	for _, name := range strings.Split(relDir, string(filepath.Separator)) {
+		dir = filepath.Join(dir, name)
+		folderID := generateID(dir)
+		folder := &storage.Folder{
+			ID:        folderID,
+			Name:      name,
+			Path:      dir,
+			ParentID:  parentID, // nil for root folders
+			CreatedAt: time.Now(),
+		}
+		if err := s.saveFolder(folder); err != nil {
+			return "", err
+		}
+		parentID = &folderID
+	}
+	return *parentID, nil
+}
+
+// refreshFolder recomputes a folder's item count and series flag from the
+// videos currently in its directory
+func (s *Scanner) refreshFolder(dirPath, folderID string) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("path", dirPath).Msg("failed to read folder")
+		return
+	}
+
+	var videoNames []string
+	for _, entry := range entries {
+		if !entry.IsDir() && IsSupportedVideo(entry.Name()) {
+			videoNames = append(videoNames, entry.Name())
+		}
+	}
+
+	if err := s.storage.UpdateFolderItemCount(folderID, len(videoNames)); err != nil {
+		s.logger.Error().Err(err).Msg("failed to update folder item count")
+	}
+	if err := s.storage.SetFolderSeries(folderID, LooksLikeSeries(videoNames)); err != nil {
+		s.logger.Error().Err(err).Str("path", dirPath).Msg("failed to update folder series flag")
+	}
+}
+
+// RemovePath drops the database entries for a file or folder that has been
+// removed from disk. Removed folders are cleaned up together with everything
+// below them.
+func (s *Scanner) RemovePath(path string) error {
+	path = filepath.Clean(path)
+
+	media, err := s.storage.GetMediaItemByPath(path)
+	if err != nil {
+		return err
+	}
+	if media != nil {
+		if err := s.storage.SoftDeleteMediaItem(media.ID); err != nil {
+			return err
+		}
+		if media.FolderID != "" {
+			s.refreshFolder(filepath.Dir(path), media.FolderID)
+		}
+		s.audit(storage.AuditMediaRemoved, path, "file removed or moved away while watching the library")
+		s.logger.Info().Str("path", path).Msg("removed file, media item moved to trash")
+		return nil
+	}
+
+	folder, err := s.storage.GetFolder(generateID(path))
+	if err != nil || folder == nil {
+		return err
+	}
+	s.logger.Info().Str("path", path).Msg("removed folder, cleaning up")
+	return s.CleanupDeletedFiles()
+}
+
+// RescanFile re-evaluates a single known file: its size, mtime and title are
+// refreshed and ffprobe results are cleared so they are extracted again.
+// If the file no longer exists the item is soft-deleted and nil is returned.
+func (s *Scanner) RescanFile(path, folderID string) (*storage.MediaItem, error) {
+	existing, err := s.storage.GetMediaItemByPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if existing == nil {
+			return nil, nil
+		}
+		if err := s.storage.SoftDeleteMediaItem(existing.ID); err != nil {
+			return nil, err
+		}
+		s.audit(storage.AuditMediaRemoved, path, "file not found when rescanned")
+		s.logger.Info().Str("path", path).Msg("rescanned file is gone, media item moved to trash")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	item := s.newMediaItem(path, info, folderID)
+	s.resolveMediaItem(item, existing)
+	if err := s.storage.CreateMediaItem(item); err != nil {
+		return nil, err
+	}
+	if err := s.storage.ClearMediaMetadata(item.ID); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info().Str("path", path).Int64("size", info.Size()).Msg("file rescanned")
+	return s.storage.GetMediaItem(item.ID)
+}
+
 func generateID(path string) string {
 	hash := sha256.Sum256([]byte(path))
 	return hex.EncodeToString(hash[:8])
 }
 
-// CleanupDeletedFiles removes database entries for files that no longer exist
+// CleanupDeletedFiles soft-deletes media whose files no longer exist, removes
+// missing folders and purges media that has been in the trash longer than
+// the retention window. Nothing is removed while the library looks
+// unreachable (ErrLibraryUnavailable) or when more than the allowed share
+// of known media is missing.
 func (s *Scanner) CleanupDeletedFiles() error {
-	// Cleanup media items
 	mediaPaths, err := s.storage.GetAllMediaPaths()
 	if err != nil {
 		return err
 	}
+	folderPaths, err := s.storage.GetAllFolderPaths()
+	if err != nil {
+		return err
+	}
 
+	missingMedia := missingPaths(mediaPaths)
+	missingFolders := missingPaths(folderPaths)
+	if len(missingMedia) > 0 || len(missingFolders) > 0 {
+		if err := s.checkCleanup(len(missingMedia), len(mediaPaths)); err != nil {
+			return err
+		}
+	}
+
+	// Cleanup media items
 	deletedMedia := 0
-	for id, path := range mediaPaths {
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			if err := s.storage.DeleteMediaItem(id); err != nil {
-				s.logger.Error().Err(err).Str("path", path).Msg("failed to delete media item")
-			} else {
-				deletedMedia++
-				s.logger.Debug().Str("path", path).Msg("deleted missing media item")
-			}
+	for id, path := range missingMedia {
+		if err := s.storage.SoftDeleteMediaItem(id); err != nil {
+			s.logger.Error().Err(err).Str("path", path).Msg("failed to delete media item")
+		} else {
+			deletedMedia++
+			s.audit(storage.AuditMediaRemoved, path, "file not found during scan cleanup")
+			s.logger.Debug().Str("path", path).Msg("moved missing media item to trash")
 		}
 	}
 
 	// Cleanup folders
-	folderPaths, err := s.storage.GetAllFolderPaths()
-	if err != nil {
-		return err
+	deletedFolders := 0
+	for id, path := range missingFolders {
+		if err := s.storage.DeleteFolder(id); err != nil {
+			s.logger.Error().Err(err).Str("path", path).Msg("failed to delete folder")
+		} else {
+			deletedFolders++
+			s.audit(storage.AuditFolderRemoved, path, "directory not found during scan cleanup")
+			s.logger.Debug().Str("path", path).Msg("deleted missing folder")
+		}
 	}
 
-	deletedFolders := 0
-	for id, path := range folderPaths {
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			if err := s.storage.DeleteFolder(id); err != nil {
-				s.logger.Error().Err(err).Str("path", path).Msg("failed to delete folder")
-			} else {
-				deletedFolders++
-				s.logger.Debug().Str("path", path).Msg("deleted missing folder")
-			}
+	purged, err := s.storage.PurgeDeletedMedia(time.Now().Add(-s.trashRetention))
+	if err != nil {
+		s.logger.Error().Err(err).Msg("failed to purge media from trash")
+	}
+	for id, path := range purged {
+		s.audit(storage.AuditMediaPurged, path, "in trash longer than the retention window")
+		if s.onPurge != nil {
+			s.onPurge(id)
 		}
 	}
 
-	if deletedMedia > 0 || deletedFolders > 0 {
+	s.record(func(sum *ScanSummary) {
+		sum.Deleted += deletedMedia
+		sum.FoldersDeleted += deletedFolders
+	})
+
+	if deletedMedia > 0 || deletedFolders > 0 || len(purged) > 0 {
 		s.logger.Info().
 			Int("media", deletedMedia).
 			Int("folders", deletedFolders).
+			Int("purged", len(purged)).
 			Msg("cleanup completed")
 	}
 
 	return nil
 }
+
+// missingPaths returns the entries of paths (ID -> path) that no longer
+// exist on disk
+func missingPaths(paths map[string]string) map[string]string {
+	missing := make(map[string]string)
+	for id, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			missing[id] = path
+		}
+	}
+	return missing
+}
+
+// checkCleanup returns an error if missing files shouldn't be removed: the
+// library root is empty or unreadable, the library monitor reports it
+// offline, or too large a share of the known media is missing at once
+func (s *Scanner) checkCleanup(missing, known int) error {
+	if root := s.libraryRoot(); root != "" {
+		entries, err := os.ReadDir(root)
+		if err != nil || len(entries) == 0 {
+			return fmt.Errorf("%w: library root %s is empty or unreadable", ErrLibraryUnavailable, root)
+		}
+	}
+
+	if s.monitor != nil && !s.monitor.Check() {
+		return fmt.Errorf("%w: library is offline", ErrLibraryUnavailable)
+	}
+
+	if known >= cleanupMinKnown && float64(missing) > s.maxMissing*float64(known) {
+		return fmt.Errorf("%d of %d media files are missing, more than library.cleanup_max_missing allows", missing, known)
+	}
+	return nil
+}
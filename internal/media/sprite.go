@@ -0,0 +1,154 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Sprite sheet defaults, see SetSprite
+const (
+	defaultSpriteTiles     = 100
+	defaultSpriteTileWidth = 160
+	spriteColumns          = 10
+)
+
+// SetSprite configures scrubbing sprite sheets: the number of frames and
+// the width of each tile in pixels. Non-positive values keep the defaults.
+func (t *ThumbnailGenerator) SetSprite(tiles, tileWidth int) {
+	if tiles > 0 {
+		t.spriteTiles = tiles
+	}
+	if tileWidth > 0 {
+		t.spriteTileWidth = tileWidth
+	}
+}
+
+// SpritePaths returns where the sprite image and its WebVTT cues are stored
+func (t *ThumbnailGenerator) SpritePaths(mediaID string) (imagePath, vttPath string) {
+	base := filepath.Join(t.outputDir, mediaID+"_sprite")
+	return base + ".jpg", base + ".vtt"
+}
+
+// GenerateSprite extracts evenly spaced frames, tiles them into one JPEG
+// grid and writes a WebVTT file mapping each time range to its tile. Cues
+// reference the image as "sprite.jpg", relative to the VTT URL.
+func (t *ThumbnailGenerator) GenerateSprite(videoPath, mediaID string, duration int64) error {
+	if duration <= 0 {
+		return fmt.Errorf("duration unknown")
+	}
+
+	imagePath, vttPath := t.SpritePaths(mediaID)
+	if fileNotEmpty(imagePath) && fileNotEmpty(vttPath) {
+		return nil
+	}
+
+	tmpDir, err := os.MkdirTemp(t.outputDir, "sprite-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// At least one second per tile for short clips
+	tiles := min(t.spriteTiles, int(duration))
+	interval := float64(duration) / float64(tiles)
+
+	// Seek to the middle of each interval; frames that fail stay black
+	frames := make([]image.Image, tiles)
+	tileW, tileH := 0, 0
+	for i := range frames {
+		framePath := filepath.Join(tmpDir, fmt.Sprintf("%d.jpg", i))
+		args := []string{
+			"-ss", fmt.Sprintf("%.3f", interval*(float64(i)+0.5)),
+			"-i", videoPath,
+			"-vf", fmt.Sprintf("scale=%d:-2", t.spriteTileWidth),
+			"-vframes", "1",
+			"-q:v", "4",
+			"-y",
+			framePath,
+		}
+		if err := t.run(context.Background(), args, videoPath); err != nil {
+			continue
+		}
+		data, err := os.ReadFile(framePath)
+		if err != nil {
+			continue
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		frames[i] = img
+		if tileH == 0 {
+			tileW, tileH = img.Bounds().Dx(), img.Bounds().Dy()
+		}
+	}
+	if tileH == 0 {
+		return fmt.Errorf("no frames extracted")
+	}
+
+	cols := min(tiles, spriteColumns)
+	rows := (tiles + cols - 1) / cols
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*tileW, rows*tileH))
+
+	var vtt strings.Builder
+	vtt.WriteString("WEBVTT\n\n")
+	for i, frame := range frames {
+		x, y := (i%cols)*tileW, (i/cols)*tileH
+		if frame != nil {
+			draw.Draw(sheet, image.Rect(x, y, x+tileW, y+tileH), frame, frame.Bounds().Min, draw.Src)
+		}
+		fmt.Fprintf(&vtt, "%s --> %s\nsprite.jpg#xywh=%d,%d,%d,%d\n\n",
+			vttTimestamp(interval*float64(i)), vttTimestamp(interval*float64(i+1)),
+			x, y, tileW, tileH)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, sheet, &jpeg.Options{Quality: 80}); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(imagePath, buf.Bytes()); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(vttPath, []byte(vtt.String())); err != nil {
+		os.Remove(imagePath)
+		return err
+	}
+
+	t.logger.Debug().
+		Str("video", videoPath).
+		Int("tiles", tiles).
+		Msg("sprite generated")
+	return nil
+}
+
+// DeleteSprite removes a media item's sprite image and cues
+func (t *ThumbnailGenerator) DeleteSprite(mediaID string) {
+	imagePath, vttPath := t.SpritePaths(mediaID)
+	os.Remove(imagePath)
+	os.Remove(vttPath)
+}
+
+// vttTimestamp formats seconds as a WebVTT timestamp (HH:MM:SS.mmm)
+func vttTimestamp(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, d.Milliseconds()%1000)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+// so readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
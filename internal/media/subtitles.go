@@ -0,0 +1,85 @@
+package media
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// SubtitleExtractor converts embedded subtitle tracks to WebVTT with ffmpeg.
+// Results are cached in the output directory keyed by media ID.
+type SubtitleExtractor struct {
+	ffmpegPath string
+	outputDir  string
+	nice       int
+	logger     zerolog.Logger
+}
+
+func NewSubtitleExtractor(outputDir string, logger zerolog.Logger) *SubtitleExtractor {
+	ffmpegPath := "ffmpeg"
+	if path, err := exec.LookPath("ffmpeg"); err == nil {
+		ffmpegPath = path
+	}
+
+	os.MkdirAll(outputDir, 0755)
+
+	return &SubtitleExtractor{
+		ffmpegPath: ffmpegPath,
+		outputDir:  outputDir,
+		logger:     logger,
+	}
+}
+
+func (e *SubtitleExtractor) IsAvailable() bool {
+	_, err := exec.LookPath(e.ffmpegPath)
+	return err == nil
+}
+
+// GetPath returns the cached WebVTT path for a media ID
+func (e *SubtitleExtractor) GetPath(mediaID string) string {
+	return filepath.Join(e.outputDir, mediaID+".vtt")
+}
+
+// Extract converts the first subtitle track of a video to WebVTT and
+// returns its path. A cached file is reused unless the video changed since.
+// Image-based tracks (PGS, VobSub) can't be converted and return an error.
+func (e *SubtitleExtractor) Extract(videoPath, mediaID string, videoModified time.Time) (string, error) {
+	outputPath := e.GetPath(mediaID)
+
+	if info, err := os.Stat(outputPath); err == nil && info.Size() > 0 && !info.ModTime().Before(videoModified) {
+		return outputPath, nil
+	}
+
+	// Write to a temp file so a failed run never leaves a partial cache entry
+	tmpPath := outputPath + ".tmp"
+	args := []string{"-y", "-v", "error", "-i", videoPath, "-map", "0:s:0", "-f", "webvtt", tmpPath}
+
+	cmd := exec.Command(e.ffmpegPath, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(tmpPath)
+		e.logger.Debug().
+			Err(err).
+			Str("video", videoPath).
+			Str("output", string(output)).
+			Msg("ffmpeg subtitle extraction failed")
+		return "", fmt.Errorf("ffmpeg failed: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+
+	e.logger.Debug().Str("video", videoPath).Str("subtitles", outputPath).Msg("subtitles extracted")
+	return outputPath, nil
+}
+
+// Delete removes cached subtitles for a media ID
+func (e *SubtitleExtractor) Delete(mediaID string) error {
+	return os.Remove(e.GetPath(mediaID))
+}
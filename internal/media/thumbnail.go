@@ -1,21 +1,72 @@
 package media
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog"
 )
 
+// Thumbnail sampling strategies
+const (
+	StrategyFixed           = "fixed"            // seek to a fixed timestamp
+	StrategyThumbnailFilter = "thumbnail_filter" // most representative frame in a window
+	StrategyScene           = "scene"            // first scene change after a minimum offset
+)
+
+// Thumbnail defaults, see SetFrameOptions
+const (
+	defaultSeekPercent      = 10  // percent into the video
+	defaultThumbnailWidth   = 320 // pixels
+	defaultThumbnailQuality = 2   // ffmpeg JPEG quality, 1 (best) - 31
+	defaultMaxSeek          = 5   // seconds, only with the default seek percent
+)
+
+// Thumbnail formats served to clients, see ThumbnailService.SetFormat.
+// Thumbnails are always generated as JPEG; WebP copies are encoded from
+// them on demand.
+const (
+	ThumbnailFormatJPEG = "jpeg"
+	ThumbnailFormatWebP = "webp"
+)
+
+// webpQuality is the libwebp quality (0 - 100) of WebP thumbnails
+const webpQuality = 75
+
+// ErrWebPUnsupported is returned when ffmpeg was built without libwebp
+var ErrWebPUnsupported = errors.New("ffmpeg has no WebP encoder")
+
+// sceneThreshold is the minimum scene score for the scene strategy (0.0 - 1.0)
+const sceneThreshold = 0.4
+
+// sceneWindow is how many seconds after the seek position the scene
+// strategy decodes looking for a scene change. Without a limit a video
+// without cuts is decoded to the end before the fixed seek is tried.
+const sceneWindow = 60
+
 type ThumbnailGenerator struct {
 	ffmpegPath string
 	outputDir  string
+	strategy   string
+	nice       int
 	logger     zerolog.Logger
+
+	seekPercent float64
+	width       int
+	quality     int
+
+	spriteTiles     int // frames per sprite sheet
+	spriteTileWidth int // pixels
 }
 
-func NewThumbnailGenerator(outputDir string, logger zerolog.Logger) *ThumbnailGenerator {
+func NewThumbnailGenerator(outputDir string, strategy string, logger zerolog.Logger) *ThumbnailGenerator {
 	// Try to find ffmpeg in PATH
 	ffmpegPath := "ffmpeg"
 	if path, err := exec.LookPath("ffmpeg"); err == nil {
@@ -25,10 +76,45 @@ func NewThumbnailGenerator(outputDir string, logger zerolog.Logger) *ThumbnailGe
 	// Ensure output directory exists
 	os.MkdirAll(outputDir, 0755)
 
+	switch strategy {
+	case StrategyFixed, StrategyThumbnailFilter, StrategyScene:
+	default:
+		logger.Warn().Str("strategy", strategy).Msg("unknown thumbnail strategy, using fixed")
+		strategy = StrategyFixed
+	}
+
 	return &ThumbnailGenerator{
 		ffmpegPath: ffmpegPath,
 		outputDir:  outputDir,
+		strategy:   strategy,
 		logger:     logger,
+
+		seekPercent: defaultSeekPercent,
+		width:       defaultThumbnailWidth,
+		quality:     defaultThumbnailQuality,
+
+		spriteTiles:     defaultSpriteTiles,
+		spriteTileWidth: defaultSpriteTileWidth,
+	}
+}
+
+// SetNice runs background ffmpeg work with a lowered CPU/IO priority
+// (0 = unchanged), see background
+func (t *ThumbnailGenerator) SetNice(nice int) {
+	t.nice = nice
+}
+
+// SetFrameOptions sets how far into the video thumbnails are taken
+// (percent of the duration), their width in pixels and JPEG quality
+// (1 = best, 31 = worst). With the default 10 percent the seek stays capped
+// at 5 seconds as before; other percentages are used as is.
+func (t *ThumbnailGenerator) SetFrameOptions(seekPercent float64, width, quality int) {
+	t.seekPercent = seekPercent
+	if width > 0 {
+		t.width = width
+	}
+	if quality > 0 {
+		t.quality = quality
 	}
 }
 
@@ -42,8 +128,9 @@ func (t *ThumbnailGenerator) GetOutputDir() string {
 }
 
 // Generate creates a thumbnail for the video file
-// Returns the path to the generated thumbnail
-func (t *ThumbnailGenerator) Generate(videoPath string, mediaID string, duration int64) (string, error) {
+// Returns the path to the generated thumbnail. When ctx is done ffmpeg is
+// killed, any partial output removed and ctx.Err() returned.
+func (t *ThumbnailGenerator) Generate(ctx context.Context, videoPath string, mediaID string, duration int64) (string, error) {
 	outputPath := filepath.Join(t.outputDir, mediaID+".jpg")
 
 	// Check if thumbnail already exists
@@ -51,35 +138,114 @@ func (t *ThumbnailGenerator) Generate(videoPath string, mediaID string, duration
 		return outputPath, nil
 	}
 
-	// Calculate timestamp for thumbnail (10% into video, or 5 seconds, whichever is smaller)
-	timestamp := int64(5)
+	timestamp := t.seekTimestamp(duration)
+
+	// Try the configured strategy first, falling back to a fixed seek
+	// if the smarter strategy fails or yields no frame
+	if t.strategy != StrategyFixed {
+		err := t.run(ctx, t.buildArgs(t.strategy, videoPath, outputPath, timestamp), videoPath)
+		if err == nil && fileNotEmpty(outputPath) {
+			t.logger.Debug().
+				Str("video", videoPath).
+				Str("strategy", t.strategy).
+				Msg("thumbnail generated")
+			return outputPath, nil
+		}
+		os.Remove(outputPath)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		t.logger.Debug().
+			Str("video", videoPath).
+			Str("strategy", t.strategy).
+			Msg("thumbnail strategy yielded no frame, falling back to fixed")
+	}
+
+	if err := t.run(ctx, t.buildArgs(StrategyFixed, videoPath, outputPath, timestamp), videoPath); err != nil {
+		// A partial file would be served as the thumbnail from now on
+		os.Remove(outputPath)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", err
+	}
+
+	// Verify thumbnail was created
+	if _, err := os.Stat(outputPath); err != nil {
+		return "", fmt.Errorf("thumbnail file not created")
+	}
+
+	t.logger.Debug().
+		Str("video", videoPath).
+		Str("thumbnail", outputPath).
+		Msg("thumbnail generated")
+
+	return outputPath, nil
+}
+
+// seekTimestamp returns the thumbnail position in seconds. By default that is
+// 10% into the video or 5 seconds, whichever is smaller.
+func (t *ThumbnailGenerator) seekTimestamp(duration int64) int64 {
+	if t.seekPercent != defaultSeekPercent {
+		if duration <= 0 {
+			return defaultMaxSeek
+		}
+		return int64(float64(duration) * t.seekPercent / 100)
+	}
+
+	timestamp := int64(defaultMaxSeek)
 	if duration > 0 {
-		tenPercent := duration / 10
-		if tenPercent > 0 && tenPercent < timestamp {
-			timestamp = tenPercent
+		percent := int64(float64(duration) * t.seekPercent / 100)
+		if percent > 0 && percent < timestamp {
+			timestamp = percent
 		}
 		if timestamp > duration {
 			timestamp = duration / 2
 		}
 	}
+	return timestamp
+}
 
-	// ffmpeg arguments for thumbnail generation
+// buildArgs returns ffmpeg arguments for the given sampling strategy
+func (t *ThumbnailGenerator) buildArgs(strategy, videoPath, outputPath string, timestamp int64) []string {
 	// -ss: seek to timestamp
 	// -i: input file
 	// -vframes 1: extract one frame
-	// -vf scale: resize maintaining aspect ratio (max 320px width)
-	// -q:v 2: quality (2 = high quality JPEG)
-	args := []string{
-		"-ss", fmt.Sprintf("%d", timestamp),
+	// -vf scale: resize maintaining aspect ratio (configured width)
+	// -q:v: JPEG quality (2 = high quality)
+	filter := fmt.Sprintf("scale=%d:-1", t.width)
+	switch strategy {
+	case StrategyThumbnailFilter:
+		// Pick the most representative frame out of the next 100 frames
+		filter = "thumbnail=100," + filter
+	case StrategyScene:
+		// Take the first frame whose scene score exceeds the threshold
+		filter = fmt.Sprintf("select='gt(scene,%.2f)',%s", sceneThreshold, filter)
+	}
+
+	args := []string{"-ss", fmt.Sprintf("%d", timestamp)}
+	if strategy == StrategyScene {
+		args = append(args, "-t", strconv.Itoa(sceneWindow))
+	}
+	args = append(args,
 		"-i", videoPath,
+		"-vf", filter,
+	)
+	if strategy == StrategyScene {
+		args = append(args, "-vsync", "vfr")
+	}
+	args = append(args,
 		"-vframes", "1",
-		"-vf", "scale=320:-1",
-		"-q:v", "2",
+		"-q:v", strconv.Itoa(t.quality),
 		"-y", // overwrite output
 		outputPath,
-	}
+	)
+	return args
+}
 
-	cmd := exec.Command(t.ffmpegPath, args...)
+// run executes ffmpeg with the given arguments, killing it when ctx is done
+func (t *ThumbnailGenerator) run(ctx context.Context, args []string, videoPath string) error {
+	cmd := niceCommand(ctx, t.nice, t.ffmpegPath, args...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		t.logger.Debug().
@@ -87,28 +253,99 @@ func (t *ThumbnailGenerator) Generate(videoPath string, mediaID string, duration
 			Str("video", videoPath).
 			Str("output", string(output)).
 			Msg("ffmpeg thumbnail generation failed")
-		return "", fmt.Errorf("ffmpeg failed: %w", err)
+		return fmt.Errorf("ffmpeg failed: %w", err)
 	}
+	return nil
+}
 
-	// Verify thumbnail was created
-	if _, err := os.Stat(outputPath); err != nil {
-		return "", fmt.Errorf("thumbnail file not created")
-	}
+func fileNotEmpty(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Size() > 0
+}
 
-	t.logger.Debug().
-		Str("video", videoPath).
-		Str("thumbnail", outputPath).
-		Msg("thumbnail generated")
+// EncodeWebP converts a JPEG thumbnail to WebP, stored next to it, and
+// returns the WebP data
+func (t *ThumbnailGenerator) EncodeWebP(ctx context.Context, mediaID string, jpeg []byte) ([]byte, error) {
+	outputPath := t.GetWebPPath(mediaID)
+	tmp := outputPath + ".tmp"
 
-	return outputPath, nil
+	cmd := niceCommand(ctx, t.nice, t.ffmpegPath,
+		"-f", "image2pipe",
+		"-i", "pipe:0",
+		"-c:v", "libwebp",
+		"-quality", strconv.Itoa(webpQuality),
+		"-f", "webp",
+		"-y",
+		tmp,
+	)
+	cmd.Stdin = bytes.NewReader(jpeg)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		os.Remove(tmp)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if strings.Contains(string(output), "Unknown encoder") {
+			return nil, ErrWebPUnsupported
+		}
+		t.logger.Debug().
+			Err(err).
+			Str("id", mediaID).
+			Str("output", string(output)).
+			Msg("ffmpeg WebP encoding failed")
+		return nil, fmt.Errorf("ffmpeg failed: %w", err)
+	}
+
+	data, err := os.ReadFile(tmp)
+	if err == nil && len(data) == 0 {
+		err = fmt.Errorf("WebP thumbnail is empty")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, outputPath); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return data, nil
 }
 
-// Delete removes a thumbnail file
+// Delete removes a thumbnail file along with its WebP copy
 func (t *ThumbnailGenerator) Delete(mediaID string) error {
+	if err := os.Remove(t.GetWebPPath(mediaID)); err != nil && !os.IsNotExist(err) {
+		t.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to delete WebP thumbnail")
+	}
 	outputPath := filepath.Join(t.outputDir, mediaID+".jpg")
 	return os.Remove(outputPath)
 }
 
+// DeleteAll removes every file in the output directory: thumbnails, their
+// WebP copies, sprites and extracted subtitles. Returns how many were
+// removed.
+func (t *ThumbnailGenerator) DeleteAll() (int, error) {
+	entries, err := os.ReadDir(t.outputDir)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		// Directories are in-progress sprite frames, cleaned up by their owner
+		if entry.IsDir() {
+			continue
+		}
+		if err := os.Remove(filepath.Join(t.outputDir, entry.Name())); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
 // Exists checks if thumbnail exists for the given media ID
 func (t *ThumbnailGenerator) Exists(mediaID string) bool {
 	outputPath := filepath.Join(t.outputDir, mediaID+".jpg")
@@ -116,7 +353,17 @@ func (t *ThumbnailGenerator) Exists(mediaID string) bool {
 	return err == nil
 }
 
+// Width returns the width of generated thumbnails
+func (t *ThumbnailGenerator) Width() int {
+	return t.width
+}
+
 // GetPath returns the thumbnail path for a media ID
 func (t *ThumbnailGenerator) GetPath(mediaID string) string {
 	return filepath.Join(t.outputDir, mediaID+".jpg")
 }
+
+// GetWebPPath returns the path of the WebP copy of a media item's thumbnail
+func (t *ThumbnailGenerator) GetWebPPath(mediaID string) string {
+	return filepath.Join(t.outputDir, mediaID+".webp")
+}
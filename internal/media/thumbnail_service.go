@@ -2,27 +2,98 @@ package media
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"math"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
 	"rvcinemaview/internal/cache"
+	"rvcinemaview/internal/events"
 	"rvcinemaview/internal/storage"
 )
 
 // ThumbnailService manages thumbnail generation and caching
 type ThumbnailService struct {
-	generator   *ThumbnailGenerator
-	metadata    *MetadataExtractor
-	storage     *storage.SQLiteStorage
-	cache       *cache.LRUCache
-	logger      zerolog.Logger
-	processing  map[string]bool
+	generator    *ThumbnailGenerator
+	metadata     *MetadataExtractor
+	storage      *storage.SQLiteStorage
+	cache        *cache.LRUCache
+	storeInDB    bool
+	logger       zerolog.Logger
+	processing   map[string]bool
+	priority     map[string]bool
+	failures     map[string]string // last failure reason per media ID
+	running      bool
+	draining     bool // a Prioritize worker empties the priority queue
 	processingMu sync.Mutex
+	events       *events.Bus // nil = no thumbnail events
+	spriteMu     sync.Mutex  // serializes sprite generation
+	maxAttempts  int         // failed generations before giving up, 0 = unlimited
+
+	prewarm        map[string]bool // IDs waiting for a prewarm worker
+	prewarmWorkers int             // running prewarm workers
+	maxPrewarm     int             // prewarm worker limit
+
+	slots chan struct{} // one entry per running ffmpeg, see acquire
+
+	throttleMin time.Duration // bounds of the background processing delay
+	throttleMax time.Duration
+	delay       atomic.Int64 // current background delay in nanoseconds, 0 = not running
+
+	format          string      // ThumbnailFormatJPEG or ThumbnailFormatWebP
+	webpUnsupported atomic.Bool // set once ffmpeg turned out to lack libwebp
+
+	generation atomic.Uint64 // bumped whenever a thumbnail is (re)generated
+	atlases    atlasCache
+
+	frames *cache.DirCache // frames extracted at arbitrary positions, nil = disabled
+
+	folderThumbs   map[string]string // folder ID -> representative media ID
+	folderThumbsMu sync.Mutex
 }
 
+// defaultMaxThumbnailAttempts is how often generation is tried for a file
+// before it is skipped, see SetMaxAttempts
+const defaultMaxThumbnailAttempts = 3
+
+// defaultPrewarmWorkers is how many thumbnails Prewarm generates at once
+const defaultPrewarmWorkers = 2
+
+// defaultMaxConcurrent is how many ffmpeg processes the service runs at
+// once, see SetMaxConcurrent
+const defaultMaxConcurrent = 2
+
+// Default bounds of the adaptive background processing delay, see
+// SetThrottle
+const (
+	defaultThrottleMin = 50 * time.Millisecond
+	defaultThrottleMax = 5 * time.Second
+)
+
+// Background items taking longer than throttleSlow double the delay before
+// the next one, a sign ffmpeg competes for a saturated CPU; items faster
+// than throttleFast halve it
+const (
+	throttleSlow = 2 * time.Second
+	throttleFast = 500 * time.Millisecond
+)
+
+// webpCacheSuffix keys WebP copies in the memory cache next to the JPEG
+const webpCacheSuffix = ".webp"
+
+// slotTimeout is how long on-demand generation waits for a free ffmpeg slot
+const slotTimeout = 10 * time.Second
+
+// ErrBusy is returned by on-demand generation when all ffmpeg slots stay
+// taken for slotTimeout; the request can be retried later
+var ErrBusy = errors.New("too many thumbnails being generated")
+
 // NewThumbnailService creates a new thumbnail service
 func NewThumbnailService(
 	generator *ThumbnailGenerator,
@@ -30,31 +101,172 @@ func NewThumbnailService(
 	store *storage.SQLiteStorage,
 	cacheCapacity int,
 	cacheMaxSize int64,
+	storeInDB bool,
 	logger zerolog.Logger,
 ) *ThumbnailService {
 	return &ThumbnailService{
-		generator:  generator,
-		metadata:   metadata,
-		storage:    store,
-		cache:      cache.NewLRUCache(cacheCapacity, cacheMaxSize),
-		logger:     logger,
-		processing: make(map[string]bool),
+		generator:   generator,
+		metadata:    metadata,
+		storage:     store,
+		cache:       cache.NewLRUCache(cacheCapacity, cacheMaxSize),
+		storeInDB:   storeInDB,
+		logger:      logger,
+		processing:  make(map[string]bool),
+		priority:    make(map[string]bool),
+		failures:    make(map[string]string),
+		maxAttempts: defaultMaxThumbnailAttempts,
+		prewarm:     make(map[string]bool),
+		maxPrewarm:  defaultPrewarmWorkers,
+		slots:       make(chan struct{}, defaultMaxConcurrent),
+		throttleMin: defaultThrottleMin,
+		throttleMax: defaultThrottleMax,
+		format:      ThumbnailFormatJPEG,
+		atlases:     atlasCache{entries: make(map[string]*atlasEntry)},
+
+		folderThumbs: make(map[string]string),
 	}
 }
 
-// GetThumbnail returns thumbnail data from cache or generates it
-func (s *ThumbnailService) GetThumbnail(mediaID string) ([]byte, error) {
-	// Check cache first
-	if data, ok := s.cache.Get(mediaID); ok {
-		s.logger.Debug().Str("id", mediaID).Msg("thumbnail from cache")
-		return data, nil
+// SetEventBus publishes thumbnail_ready events to the bus
+func (s *ThumbnailService) SetEventBus(bus *events.Bus) {
+	s.events = bus
+}
+
+// SetMaxAttempts sets how many failed generations a media item gets before
+// it is skipped until regenerated explicitly (0 = retry forever)
+func (s *ThumbnailService) SetMaxAttempts(n int) {
+	s.maxAttempts = n
+}
+
+// SetPrewarmWorkers limits how many thumbnails Prewarm generates in
+// parallel. Non-positive values keep the default.
+func (s *ThumbnailService) SetPrewarmWorkers(n int) {
+	if n > 0 {
+		s.maxPrewarm = n
+	}
+}
+
+// SetMaxConcurrent limits how many ffmpeg processes thumbnail and sprite
+// generation run at once, on demand and in the background together.
+// Non-positive values keep the default. Call before the service is used.
+func (s *ThumbnailService) SetMaxConcurrent(n int) {
+	if n > 0 {
+		s.slots = make(chan struct{}, n)
 	}
+}
 
-	// Check if file exists on disk
-	thumbnailPath := s.generator.GetPath(mediaID)
-	if data, err := os.ReadFile(thumbnailPath); err == nil {
-		s.logger.Debug().Str("id", mediaID).Str("path", thumbnailPath).Msg("thumbnail from disk")
-		s.cache.Set(mediaID, data)
+// SetThrottle bounds the delay background processing adapts between items.
+// Non-positive values keep the defaults; a ceiling below the floor is
+// raised to it.
+func (s *ThumbnailService) SetThrottle(minDelay, maxDelay time.Duration) {
+	if minDelay > 0 {
+		s.throttleMin = minDelay
+	}
+	if maxDelay > 0 {
+		s.throttleMax = maxDelay
+	}
+	if s.throttleMax < s.throttleMin {
+		s.throttleMax = s.throttleMin
+	}
+}
+
+// SetFormat sets the preferred thumbnail format. With ThumbnailFormatWebP
+// clients accepting WebP are served WebP copies; JPEG is always available.
+func (s *ThumbnailService) SetFormat(format string) {
+	s.format = format
+}
+
+// WebPEnabled reports whether WebP thumbnails are served to clients that
+// accept them
+func (s *ThumbnailService) WebPEnabled() bool {
+	return s.format == ThumbnailFormatWebP && !s.webpUnsupported.Load()
+}
+
+// SetFrameCache keeps frames extracted by Frame in the scratch cache
+func (s *ThumbnailService) SetFrameCache(c *cache.DirCache) {
+	s.frames = c
+}
+
+// adaptDelay returns the delay before the next background item given how
+// long the last one took
+func (s *ThumbnailService) adaptDelay(delay, elapsed time.Duration) time.Duration {
+	switch {
+	case elapsed > throttleSlow:
+		delay *= 2
+	case elapsed < throttleFast:
+		delay /= 2
+	}
+	return min(max(delay, s.throttleMin), s.throttleMax)
+}
+
+// acquire takes an ffmpeg slot, waiting at most timeout (0 = until ctx is
+// done). Every successful acquire must be followed by release.
+func (s *ThumbnailService) acquire(ctx context.Context, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-expired:
+		return ErrBusy
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *ThumbnailService) release() {
+	<-s.slots
+}
+
+// attemptsExhausted reports whether generation has failed too often for a
+// media item to be tried again
+func (s *ThumbnailService) attemptsExhausted(media *storage.MediaItem) bool {
+	return s.maxAttempts > 0 && media.ThumbAttempts >= s.maxAttempts
+}
+
+// generationFailed records a failed thumbnail generation
+func (s *ThumbnailService) generationFailed(mediaID string, err error) {
+	s.setFailure(mediaID, "thumbnail generation failed: "+err.Error())
+	attempts, err := s.storage.IncrementThumbnailAttempts(mediaID)
+	if err != nil {
+		s.logger.Error().Err(err).Str("id", mediaID).Msg("failed to count thumbnail attempt")
+		return
+	}
+	if s.maxAttempts > 0 && attempts >= s.maxAttempts {
+		s.logger.Warn().Str("id", mediaID).Int("attempts", attempts).Msg("giving up on thumbnail generation")
+	}
+}
+
+// thumbnailGenerated invalidates derived images and notifies subscribers
+// that a new thumbnail is available
+func (s *ThumbnailService) thumbnailGenerated(mediaID string) {
+	s.generation.Add(1)
+	if err := s.storage.BumpThumbnailVersion(mediaID); err != nil {
+		s.logger.Error().Err(err).Str("id", mediaID).Msg("failed to bump thumbnail version")
+	}
+	if err := s.storage.SetThumbnailGenerated(mediaID, true); err != nil {
+		s.logger.Error().Err(err).Str("id", mediaID).Msg("failed to mark thumbnail generated")
+	}
+	if s.events != nil {
+		s.events.Publish(events.Event{Type: events.ThumbnailReady, ID: mediaID})
+	}
+}
+
+// GetThumbnail returns thumbnail data from cache or generates it. Returns
+// ErrBusy if generation can't start within slotTimeout.
+func (s *ThumbnailService) GetThumbnail(mediaID string) ([]byte, error) {
+	return s.thumbnail(context.Background(), mediaID, slotTimeout)
+}
+
+// thumbnail is GetThumbnail waiting at most wait for an ffmpeg slot
+// (0 = as long as it takes)
+func (s *ThumbnailService) thumbnail(ctx context.Context, mediaID string, wait time.Duration) ([]byte, error) {
+	if data, ok := s.storedThumbnail(mediaID); ok {
 		return data, nil
 	}
 
@@ -69,6 +281,10 @@ func (s *ThumbnailService) GetThumbnail(mediaID string) ([]byte, error) {
 		return nil, nil
 	}
 
+	if s.attemptsExhausted(media) {
+		return nil, fmt.Errorf("thumbnail generation failed %d times", media.ThumbAttempts)
+	}
+
 	s.logger.Info().Str("id", mediaID).Str("path", media.Path).Msg("generating thumbnail on demand")
 
 	// Check if generator is available
@@ -83,11 +299,18 @@ func (s *ThumbnailService) GetThumbnail(mediaID string) ([]byte, error) {
 		duration = *media.Duration
 	}
 
-	thumbnailPath, err = s.generator.Generate(media.Path, mediaID, duration)
+	if err := s.acquire(ctx, wait); err != nil {
+		s.logger.Warn().Err(err).Str("id", mediaID).Msg("no free ffmpeg slot for thumbnail")
+		return nil, err
+	}
+	thumbnailPath, err := s.generator.Generate(ctx, media.Path, mediaID, duration)
+	s.release()
 	if err != nil {
 		s.logger.Error().Err(err).Str("id", mediaID).Str("video", media.Path).Msg("failed to generate thumbnail")
+		s.generationFailed(mediaID, err)
 		return nil, err
 	}
+	s.setFailure(mediaID, "")
 
 	// Read and cache
 	data, err := os.ReadFile(thumbnailPath)
@@ -97,16 +320,384 @@ func (s *ThumbnailService) GetThumbnail(mediaID string) ([]byte, error) {
 	}
 
 	s.cache.Set(mediaID, data)
+	s.saveToDB(mediaID, data)
+	s.thumbnailGenerated(mediaID)
 	s.logger.Info().Str("id", mediaID).Int("size", len(data)).Msg("thumbnail generated and cached")
 	return data, nil
 }
 
+// WebP returns the WebP copy of a media item's thumbnail, given its JPEG
+// data. The copy is encoded on first use and then kept in the cache and on
+// disk. Returns ErrBusy if encoding can't start within slotTimeout.
+func (s *ThumbnailService) WebP(mediaID string, jpeg []byte) ([]byte, error) {
+	key := mediaID + webpCacheSuffix
+	if data, ok := s.cache.Get(key); ok {
+		return data, nil
+	}
+	if data, err := os.ReadFile(s.generator.GetWebPPath(mediaID)); err == nil {
+		s.cache.Set(key, data)
+		return data, nil
+	}
+	if s.webpUnsupported.Load() {
+		return nil, ErrWebPUnsupported
+	}
+
+	if err := s.acquire(context.Background(), slotTimeout); err != nil {
+		return nil, err
+	}
+	data, err := s.generator.EncodeWebP(context.Background(), mediaID, jpeg)
+	s.release()
+	if errors.Is(err, ErrWebPUnsupported) {
+		if !s.webpUnsupported.Swap(true) {
+			s.logger.Warn().Msg("ffmpeg lacks libwebp, serving JPEG thumbnails only")
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache.Set(key, data)
+	return data, nil
+}
+
+// FolderThumbnail returns the thumbnail of a folder's representative media
+// item, the first ID returned by mediaIDs that has a stored thumbnail. No
+// thumbnails are generated. The choice is remembered per folder, so
+// mediaIDs is only called again once that thumbnail is gone. Returns an
+// empty ID if none of the media has a thumbnail.
+func (s *ThumbnailService) FolderThumbnail(folderID string, mediaIDs func() ([]string, error)) (string, []byte, error) {
+	s.folderThumbsMu.Lock()
+	cached, ok := s.folderThumbs[folderID]
+	s.folderThumbsMu.Unlock()
+	if ok {
+		if data, ok := s.storedThumbnail(cached); ok {
+			return cached, data, nil
+		}
+	}
+
+	ids, err := mediaIDs()
+	if err != nil {
+		return "", nil, err
+	}
+	for _, id := range ids {
+		if data, ok := s.storedThumbnail(id); ok {
+			s.folderThumbsMu.Lock()
+			s.folderThumbs[folderID] = id
+			s.folderThumbsMu.Unlock()
+			return id, data, nil
+		}
+	}
+
+	s.folderThumbsMu.Lock()
+	delete(s.folderThumbs, folderID)
+	s.folderThumbsMu.Unlock()
+	return "", nil, nil
+}
+
+// storedThumbnail looks up an existing thumbnail in the cache, on disk and
+// in the database, without generating one
+func (s *ThumbnailService) storedThumbnail(mediaID string) ([]byte, bool) {
+	// Check cache first
+	if data, ok := s.cache.Get(mediaID); ok {
+		s.logger.Debug().Str("id", mediaID).Msg("thumbnail from cache")
+		return data, true
+	}
+
+	// Check if file exists on disk
+	thumbnailPath := s.generator.GetPath(mediaID)
+	if data, err := os.ReadFile(thumbnailPath); err == nil {
+		s.logger.Debug().Str("id", mediaID).Str("path", thumbnailPath).Msg("thumbnail from disk")
+		s.cache.Set(mediaID, data)
+		return data, true
+	}
+
+	// Check database if thumbnails are persisted there
+	if s.storeInDB {
+		data, err := s.storage.GetThumbnailData(mediaID, s.generator.Width())
+		if err != nil {
+			s.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to read thumbnail from database")
+		} else if data != nil {
+			s.logger.Debug().Str("id", mediaID).Msg("thumbnail from database")
+			s.cache.Set(mediaID, data)
+			return data, true
+		}
+	}
+
+	return nil, false
+}
+
+// version identifies the current set of generated thumbnails
+func (s *ThumbnailService) version() string {
+	return strconv.FormatUint(s.generation.Load(), 10)
+}
+
+// Version returns the thumbnail version of a media item, bumped whenever
+// its thumbnail is generated
+func (s *ThumbnailService) Version(mediaID string) int {
+	version, err := s.storage.GetThumbnailVersion(mediaID)
+	if err != nil {
+		s.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to get thumbnail version")
+	}
+	return version
+}
+
+// Evict drops a thumbnail from the memory cache so it is read again
+func (s *ThumbnailService) Evict(mediaID string) {
+	s.cache.Delete(mediaID)
+	s.cache.Delete(mediaID + webpCacheSuffix)
+}
+
+// ETag identifies the current version of a media item's thumbnail for
+// conditional requests. It combines the thumbnail version with the file's
+// mtime so it changes when the thumbnail is regenerated; thumbnails only
+// kept in the database use a checksum of data instead of the mtime.
+func (s *ThumbnailService) ETag(mediaID string, data []byte) string {
+	version := s.Version(mediaID)
+	if info, err := os.Stat(s.generator.GetPath(mediaID)); err == nil {
+		return fmt.Sprintf(`"%s-%d-%x"`, mediaID, version, info.ModTime().UnixNano())
+	}
+	return fmt.Sprintf(`"%s-%d-%08x"`, mediaID, version, crc32.ChecksumIEEE(data))
+}
+
 // HasThumbnail checks if thumbnail exists
 func (s *ThumbnailService) HasThumbnail(mediaID string) bool {
 	if _, ok := s.cache.Get(mediaID); ok {
 		return true
 	}
-	return s.generator.Exists(mediaID)
+	if s.generator.Exists(mediaID) {
+		return true
+	}
+	return s.hasInDB(mediaID)
+}
+
+// RemoveThumbnail deletes a thumbnail from the cache, disk and database so
+// it is generated again
+func (s *ThumbnailService) RemoveThumbnail(mediaID string) {
+	s.Evict(mediaID)
+	if err := s.generator.Delete(mediaID); err != nil && !os.IsNotExist(err) {
+		s.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to delete thumbnail file")
+	}
+	if s.storeInDB {
+		if err := s.storage.DeleteThumbnailData(mediaID); err != nil {
+			s.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to delete thumbnail from database")
+		}
+	}
+	s.generator.DeleteSprite(mediaID)
+	if err := s.storage.SetThumbnailGenerated(mediaID, false); err != nil {
+		s.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to clear thumbnail flag")
+	}
+	s.setFailure(mediaID, "")
+	s.generation.Add(1)
+}
+
+// ClearCache empties the memory cache and, with disk, deletes every file in
+// the thumbnail directory along with thumbnails stored in the database, so
+// all thumbnails are generated again. Returns the number of memory entries
+// and files removed.
+func (s *ThumbnailService) ClearCache(disk bool) (entries, files int, err error) {
+	entries = s.cache.Len()
+	s.cache.Clear()
+	s.generation.Add(1)
+
+	s.folderThumbsMu.Lock()
+	clear(s.folderThumbs)
+	s.folderThumbsMu.Unlock()
+
+	if !disk {
+		return entries, 0, nil
+	}
+
+	files, err = s.generator.DeleteAll()
+	if err != nil {
+		return entries, files, err
+	}
+	if s.storeInDB {
+		if err := s.storage.DeleteAllThumbnailData(); err != nil {
+			return entries, files, err
+		}
+	}
+	if err := s.storage.ClearThumbnailsGenerated(); err != nil {
+		return entries, files, err
+	}
+
+	s.processingMu.Lock()
+	clear(s.failures)
+	s.processingMu.Unlock()
+
+	return entries, files, nil
+}
+
+// SyncGeneratedFlags marks media whose thumbnail already exists on disk or
+// in the database as generated, for thumbnails created before the flag was
+// maintained. Returns the number of media marked.
+func (s *ThumbnailService) SyncGeneratedFlags() (int, error) {
+	ids, err := s.storage.GetMediaIDsWithoutThumbnailFlag()
+	if err != nil {
+		return 0, err
+	}
+
+	var found []string
+	for _, id := range ids {
+		if s.generator.Exists(id) || s.hasInDB(id) {
+			found = append(found, id)
+		}
+	}
+	if len(found) == 0 {
+		return 0, nil
+	}
+	if err := s.storage.MarkThumbnailsGenerated(found); err != nil {
+		return 0, err
+	}
+	return len(found), nil
+}
+
+// Regenerate deletes a media item's thumbnail, resets its failed attempts
+// and generates it again
+func (s *ThumbnailService) Regenerate(mediaID string) error {
+	if err := s.storage.ResetThumbnailAttempts(mediaID); err != nil {
+		return err
+	}
+	s.RemoveThumbnail(mediaID)
+	_, err := s.GetThumbnail(mediaID)
+	return err
+}
+
+// Prewarm loads stored thumbnails into the cache and queues the missing
+// ones for generation by a bounded pool of workers. It returns the IDs that
+// were already available and the ones queued.
+func (s *ThumbnailService) Prewarm(mediaIDs []string) (cached, queued []string) {
+	cached, queued = []string{}, []string{}
+	for _, id := range mediaIDs {
+		if _, ok := s.storedThumbnail(id); ok {
+			cached = append(cached, id)
+			continue
+		}
+
+		s.processingMu.Lock()
+		s.prewarm[id] = true
+		if s.prewarmWorkers < s.maxPrewarm {
+			s.prewarmWorkers++
+			go s.prewarmWorker()
+		}
+		s.processingMu.Unlock()
+		queued = append(queued, id)
+	}
+	return cached, queued
+}
+
+// prewarmWorker generates queued prewarm thumbnails until none are left
+func (s *ThumbnailService) prewarmWorker() {
+	for {
+		s.processingMu.Lock()
+		var mediaID string
+		for id := range s.prewarm {
+			mediaID = id
+			break
+		}
+		if mediaID == "" {
+			s.prewarmWorkers--
+			s.processingMu.Unlock()
+			return
+		}
+		delete(s.prewarm, mediaID)
+		s.processingMu.Unlock()
+
+		// Failures are logged and recorded by thumbnail; prewarming waits
+		// for a slot instead of giving up on a busy server
+		s.thumbnail(background(context.Background()), mediaID, 0)
+	}
+}
+
+// Sprite returns the paths of a media item's scrubbing sprite and its WebVTT
+// cues, generating them on first use. Generation runs ffmpeg once per tile,
+// so only one sprite is generated at a time.
+func (s *ThumbnailService) Sprite(media *storage.MediaItem) (imagePath, vttPath string, err error) {
+	imagePath, vttPath = s.generator.SpritePaths(media.ID)
+	if fileNotEmpty(imagePath) && fileNotEmpty(vttPath) {
+		return imagePath, vttPath, nil
+	}
+
+	if !s.generator.IsAvailable() {
+		return "", "", fmt.Errorf("ffmpeg not available")
+	}
+	if media.Duration == nil {
+		return "", "", fmt.Errorf("duration unknown")
+	}
+
+	s.spriteMu.Lock()
+	defer s.spriteMu.Unlock()
+
+	if err := s.acquire(context.Background(), slotTimeout); err != nil {
+		return "", "", err
+	}
+	defer s.release()
+
+	if err := s.generator.GenerateSprite(media.Path, media.ID, *media.Duration); err != nil {
+		return "", "", err
+	}
+	return imagePath, vttPath, nil
+}
+
+// Frame returns the path of a JPEG of the frame at the given position
+// (seconds) of a media item, at most width pixels wide. Positions past the
+// end are clamped to the last second. Frames are kept in the scratch cache,
+// so repeated requests don't run ffmpeg again. Returns ErrBusy if
+// extraction can't start within slotTimeout.
+func (s *ThumbnailService) Frame(media *storage.MediaItem, seconds float64, width int) (string, error) {
+	if s.frames == nil {
+		return "", fmt.Errorf("frame cache not configured")
+	}
+	if media.Duration != nil && *media.Duration > 0 {
+		seconds = min(seconds, float64(*media.Duration-1))
+	}
+	seconds = max(seconds, 0)
+
+	millis := int64(math.Round(seconds * 1000))
+	path, err := s.frames.Path("frames", media.ID, fmt.Sprintf("%d-%d.jpg", millis, width))
+	if err != nil {
+		return "", err
+	}
+	if fileNotEmpty(path) {
+		s.frames.Touch(path)
+		return path, nil
+	}
+
+	if !s.generator.IsAvailable() {
+		return "", fmt.Errorf("ffmpeg not available")
+	}
+	if err := s.acquire(context.Background(), slotTimeout); err != nil {
+		return "", err
+	}
+	defer s.release()
+
+	if err := s.generator.ExtractFrame(context.Background(), media.Path, path, float64(millis)/1000, width); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// saveToDB persists thumbnail bytes when database storage is enabled
+func (s *ThumbnailService) saveToDB(mediaID string, data []byte) {
+	if !s.storeInDB {
+		return
+	}
+	if err := s.storage.SaveThumbnailData(mediaID, s.generator.Width(), data); err != nil {
+		s.logger.Error().Err(err).Str("id", mediaID).Msg("failed to store thumbnail in database")
+	}
+}
+
+// hasInDB checks whether the thumbnail is persisted in the database
+func (s *ThumbnailService) hasInDB(mediaID string) bool {
+	if !s.storeInDB {
+		return false
+	}
+	exists, err := s.storage.HasThumbnailData(mediaID, s.generator.Width())
+	if err != nil {
+		s.logger.Warn().Err(err).Str("id", mediaID).Msg("failed to check thumbnail in database")
+		return false
+	}
+	return exists
 }
 
 // ProcessMediaItem extracts metadata and generates thumbnail for a media item
@@ -127,7 +718,23 @@ func (s *ThumbnailService) ProcessMediaItem(ctx context.Context, media *storage.
 
 	// Extract metadata if available
 	if s.metadata.IsAvailable() && media.Duration == nil {
-		meta, err := s.metadata.Extract(media.Path)
+		meta, err := s.metadata.Extract(ctx, media.Path)
+		if err != nil {
+			s.setFailure(media.ID, "metadata extraction failed: "+err.Error())
+			// Don't retry files ffprobe can't handle on every pass; an
+			// unreachable file may come back, so it isn't marked
+			if _, statErr := os.Stat(media.Path); statErr == nil {
+				if err := s.storage.MarkMetadataFailed(media.ID); err != nil {
+					s.logger.Error().Err(err).Str("id", media.ID).Msg("failed to mark metadata failure")
+				}
+			}
+		}
+		if err == nil && meta != nil && meta.DurationUnknown {
+			s.countDuration(ctx, media, meta)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
 		if err == nil && meta != nil {
 			// Update storage with metadata
 			if err := s.storage.UpdateMediaMetadata(
@@ -138,6 +745,7 @@ func (s *ThumbnailService) ProcessMediaItem(ctx context.Context, media *storage.
 				meta.VideoCodec,
 				meta.AudioCodec,
 				meta.AudioChannels,
+				meta.Bitrate,
 			); err != nil {
 				s.logger.Error().Err(err).Str("id", media.ID).Msg("failed to update metadata")
 			} else {
@@ -148,28 +756,195 @@ func (s *ThumbnailService) ProcessMediaItem(ctx context.Context, media *storage.
 					Int("height", meta.Height).
 					Msg("metadata extracted")
 			}
-			media.Duration = &meta.Duration
+			if err := s.storage.UpdateMediaSubtitleFlag(media.ID, meta.HasSubtitles); err != nil {
+				s.logger.Error().Err(err).Str("id", media.ID).Msg("failed to save subtitle flag")
+			}
+			if err := s.storage.SetAudioTrackCount(media.ID, meta.AudioTracks); err != nil {
+				s.logger.Error().Err(err).Str("id", media.ID).Msg("failed to save audio track count")
+			}
+			if err := s.storage.SetMediaTracks(media.ID, meta.Tracks); err != nil {
+				s.logger.Error().Err(err).Str("id", media.ID).Msg("failed to save tracks")
+			}
+			if start, end, ok := DetectIntro(meta.Chapters); ok {
+				if err := s.storage.SetDetectedIntroMarkers(media.ID, start, end); err != nil {
+					s.logger.Error().Err(err).Str("id", media.ID).Msg("failed to save intro markers")
+				}
+			}
+			if meta.DurationUnknown {
+				// Stored without a duration; don't probe and decode it
+				// again on every pass
+				if err := s.storage.MarkMetadataFailed(media.ID); err != nil {
+					s.logger.Error().Err(err).Str("id", media.ID).Msg("failed to mark metadata failure")
+				}
+			} else {
+				media.Duration = &meta.Duration
+			}
 		}
 	}
 
 	// Generate thumbnail if ffmpeg available
-	if s.generator.IsAvailable() && !s.generator.Exists(media.ID) {
+	if s.generator.IsAvailable() && !s.attemptsExhausted(media) && !s.generator.Exists(media.ID) && !s.hasInDB(media.ID) {
 		duration := int64(0)
 		if media.Duration != nil {
 			duration = *media.Duration
 		}
 
-		if _, err := s.generator.Generate(media.Path, media.ID, duration); err != nil {
+		if err := s.acquire(ctx, 0); err != nil {
+			return err
+		}
+		thumbnailPath, err := s.generator.Generate(ctx, media.Path, media.ID, duration)
+		s.release()
+		if ctx.Err() != nil {
+			// Interrupted, not a failure of the file
+			return ctx.Err()
+		}
+		if err != nil {
 			s.logger.Debug().Err(err).Str("id", media.ID).Msg("failed to generate thumbnail")
+			s.generationFailed(media.ID, err)
+		} else {
+			s.setFailure(media.ID, "")
+			s.thumbnailGenerated(media.ID)
+			if s.storeInDB {
+				if data, err := os.ReadFile(thumbnailPath); err == nil {
+					s.saveToDB(media.ID, data)
+				}
+			}
 		}
 	}
 
 	return nil
 }
 
-// StartBackgroundProcessing processes all media items in background
+// countDuration fills in the duration of a file ffprobe couldn't read one
+// from by decoding it, which takes an ffmpeg slot
+func (s *ThumbnailService) countDuration(ctx context.Context, media *storage.MediaItem, meta *Metadata) {
+	if err := s.acquire(ctx, 0); err != nil {
+		return
+	}
+	dur, err := s.metadata.CountDuration(ctx, media.Path)
+	s.release()
+	if err != nil {
+		if ctx.Err() == nil {
+			s.setFailure(media.ID, "duration unknown: "+err.Error())
+			s.logger.Debug().Err(err).Str("id", media.ID).Msg("failed to count duration")
+		}
+		return
+	}
+
+	s.logger.Debug().Str("id", media.ID).Int64("duration", dur).Msg("duration counted from frames")
+	meta.Duration = dur
+	meta.DurationUnknown = false
+}
+
+// ProcessingState reports whether a media item is being processed or queued
+// with priority, and the last recorded failure reason (empty if none)
+func (s *ThumbnailService) ProcessingState(mediaID string) (processing, queued bool, failure string) {
+	s.processingMu.Lock()
+	defer s.processingMu.Unlock()
+	return s.processing[mediaID], s.priority[mediaID], s.failures[mediaID]
+}
+
+// setFailure records (or clears, with an empty reason) the last failure for a media item
+func (s *ThumbnailService) setFailure(mediaID, reason string) {
+	s.processingMu.Lock()
+	defer s.processingMu.Unlock()
+	if reason == "" {
+		delete(s.failures, mediaID)
+	} else {
+		s.failures[mediaID] = reason
+	}
+}
+
+// Prioritize queues a media item to be processed ahead of the background batch.
+// If background processing is not running, the item is processed right away
+// by a single worker shared by all prioritized items.
+func (s *ThumbnailService) Prioritize(mediaID string) {
+	s.processingMu.Lock()
+	s.priority[mediaID] = true
+	start := !s.running && !s.draining
+	if start {
+		s.draining = true
+	}
+	s.processingMu.Unlock()
+
+	if start {
+		go s.drainPriority()
+	}
+}
+
+// drainPriority processes priority items until the queue stays empty. The
+// queue is checked and draining cleared under one lock, so items queued
+// meanwhile are never left without a worker.
+func (s *ThumbnailService) drainPriority() {
+	for {
+		s.processPriority(context.Background())
+
+		s.processingMu.Lock()
+		if len(s.priority) == 0 {
+			s.draining = false
+			s.processingMu.Unlock()
+			return
+		}
+		s.processingMu.Unlock()
+	}
+}
+
+// processPriority processes all queued priority items
+func (s *ThumbnailService) processPriority(ctx context.Context) {
+	for ctx.Err() == nil {
+		s.processingMu.Lock()
+		var mediaID string
+		for id := range s.priority {
+			mediaID = id
+			break
+		}
+		if mediaID != "" {
+			delete(s.priority, mediaID)
+		}
+		s.processingMu.Unlock()
+
+		if mediaID == "" {
+			return
+		}
+
+		media, err := s.storage.GetMediaItem(mediaID)
+		if err != nil || media == nil {
+			s.logger.Warn().Err(err).Str("id", mediaID).Msg("priority item not found")
+			continue
+		}
+
+		// Someone is waiting for priority items, so they run at normal
+		// priority even when picked up by background processing
+		if err := s.ProcessMediaItem(interactive(ctx), media); err != nil && ctx.Err() == nil {
+			s.logger.Error().Err(err).Str("id", mediaID).Msg("failed to process priority item")
+		} else {
+			s.logger.Debug().Str("id", mediaID).Msg("priority item processed")
+		}
+	}
+}
+
+// StartBackgroundProcessing processes all media items in background. delay
+// is the initial pause between items, adapted to how long items take within
+// the SetThrottle bounds.
 func (s *ThumbnailService) StartBackgroundProcessing(ctx context.Context, batchSize int, delay time.Duration) {
+	delay = min(max(delay, s.throttleMin), s.throttleMax)
+	ctx = background(ctx)
+
 	go func() {
+		s.processingMu.Lock()
+		s.running = true
+		s.processingMu.Unlock()
+		s.delay.Store(int64(delay))
+
+		defer func() {
+			s.processingMu.Lock()
+			s.running = false
+			s.processingMu.Unlock()
+			s.delay.Store(0)
+			// Pick up anything prioritized while the batch was finishing
+			s.processPriority(ctx)
+		}()
+
 		s.logger.Info().Msg("starting background thumbnail/metadata processing")
 
 		totalProcessed := 0
@@ -197,12 +972,21 @@ func (s *ThumbnailService) StartBackgroundProcessing(ctx context.Context, batchS
 					s.logger.Info().Int("processed", totalProcessed).Msg("background processing cancelled")
 					return
 				default:
+					// Priority items jump ahead of the batch
+					s.processPriority(ctx)
+
 					itemCopy := item
-					if err := s.ProcessMediaItem(ctx, &itemCopy); err != nil {
+					started := time.Now()
+					if err := s.ProcessMediaItem(ctx, &itemCopy); err != nil && ctx.Err() == nil {
 						s.logger.Error().Err(err).Str("id", item.ID).Msg("failed to process item")
 					}
 					totalProcessed++
-					time.Sleep(delay) // Rate limit to avoid overloading weak CPU
+
+					// Rate limit to avoid overloading weak CPUs, backing off
+					// while ffprobe/ffmpeg are slow
+					delay = s.adaptDelay(delay, time.Since(started))
+					s.delay.Store(int64(delay))
+					time.Sleep(delay)
 				}
 			}
 		}
@@ -211,7 +995,32 @@ func (s *ThumbnailService) StartBackgroundProcessing(ctx context.Context, batchS
 	}()
 }
 
+// CacheStats describes the in-memory thumbnail cache
+type CacheStats struct {
+	Entries  int     `json:"entries"`
+	Size     int64   `json:"size"` // bytes
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hit_ratio"`
+
+	ProcessingDelayMs int64 `json:"processing_delay_ms"` // current pause between background items, 0 when idle
+}
+
 // CacheStats returns cache statistics
-func (s *ThumbnailService) CacheStats() (count int, size int64) {
-	return s.cache.Len(), s.cache.Size()
+func (s *ThumbnailService) CacheStats() CacheStats {
+	hits, misses, ratio := s.cache.Stats()
+	return CacheStats{
+		Entries:  s.cache.Len(),
+		Size:     s.cache.Size(),
+		Hits:     hits,
+		Misses:   misses,
+		HitRatio: ratio,
+
+		ProcessingDelayMs: time.Duration(s.delay.Load()).Milliseconds(),
+	}
+}
+
+// ResetCacheStats zeroes the cache hit and miss counters
+func (s *ThumbnailService) ResetCacheStats() {
+	s.cache.ResetStats()
 }
@@ -0,0 +1,121 @@
+package media
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestBuildArgsSceneIsBounded(t *testing.T) {
+	g := NewThumbnailGenerator(t.TempDir(), StrategyScene, zerolog.Nop())
+
+	args := g.buildArgs(StrategyScene, "in.mkv", "out.jpg", 5)
+	i := slices.Index(args, "-t")
+	if i < 0 || i+1 >= len(args) || args[i+1] != strconv.Itoa(sceneWindow) {
+		t.Fatalf("scene args %q don't limit decoding to %d seconds", args, sceneWindow)
+	}
+	if input := slices.Index(args, "-i"); i > input {
+		t.Errorf("-t must come before -i to limit the input, got %q", args)
+	}
+
+	for _, strategy := range []string{StrategyFixed, StrategyThumbnailFilter} {
+		if args := g.buildArgs(strategy, "in.mkv", "out.jpg", 5); slices.Contains(args, "-t") {
+			t.Errorf("%s args %q shouldn't be time limited", strategy, args)
+		}
+	}
+
+	args = g.buildArgs(StrategyThumbnailFilter, "in.mkv", "out.jpg", 5)
+	if vf := slices.Index(args, "-vf"); vf < 0 || vf+1 >= len(args) || !strings.HasPrefix(args[vf+1], "thumbnail=100,") {
+		t.Errorf("thumbnail_filter args %q don't pick from 100 frames", args)
+	}
+}
+
+// blackIntroVideo writes a 12 second video that is black for the first 8
+// seconds and a test pattern after that
+func blackIntroVideo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+
+	path := filepath.Join(t.TempDir(), "intro.mkv")
+	cmd := exec.Command("ffmpeg",
+		"-f", "lavfi", "-i", "color=c=black:s=320x240:r=25:d=8",
+		"-f", "lavfi", "-i", "testsrc=s=320x240:r=25:d=4",
+		"-filter_complex", "[0:v][1:v]concat=n=2:v=1:a=0",
+		"-c:v", "mpeg4", "-q:v", "2",
+		"-y", path,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("creating test video: %v\n%s", err, output)
+	}
+	return path
+}
+
+// meanLuma returns the average brightness (0 - 255) of a JPEG file
+func meanLuma(t *testing.T, path string) float64 {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	img, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return averageGray(img)
+}
+
+func averageGray(img image.Image) float64 {
+	bounds := img.Bounds()
+	var sum float64
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+	}
+	return sum / float64(bounds.Dx()*bounds.Dy())
+}
+
+func TestGenerateBlackIntro(t *testing.T) {
+	video := blackIntroVideo(t)
+
+	tests := []struct {
+		strategy string
+		black    bool
+	}{
+		// The default seek (1 second into 12) lands in the intro
+		{StrategyFixed, true},
+		// The first scene change is the cut to the test pattern
+		{StrategyScene, false},
+		// The 100 frames after the seek (4 seconds at 25 fps) are all black
+		{StrategyThumbnailFilter, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			g := NewThumbnailGenerator(t.TempDir(), tt.strategy, zerolog.Nop())
+			path, err := g.Generate(context.Background(), video, "intro", 12)
+			if err != nil {
+				t.Fatalf("Generate: %v", err)
+			}
+
+			luma := meanLuma(t, path)
+			if black := luma < 30; black != tt.black {
+				t.Errorf("mean luma = %.1f, want black = %v", luma, tt.black)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package media
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultStripTokens are release tags commonly found in scene/P2P filenames.
+// Words that also appear in real titles (e.g. "web", "extended") are left out.
+var DefaultStripTokens = []string{
+	"2160p", "1080p", "1080i", "720p", "576p", "480p", "4k", "uhd",
+	"hdr", "hdr10", "dolbyvision",
+	"bluray", "blu-ray", "bdrip", "brrip", "bdremux", "remux",
+	"webrip", "web-dl", "webdl", "hdtv", "hdrip", "dvdrip", "dvdscr",
+	"x264", "x265", "h264", "h265", "hevc", "avc", "xvid", "divx", "10bit",
+	"aac", "ac3", "dts", "dts-hd", "truehd", "atmos", "ddp5", "dd5",
+	"yify", "yts", "rarbg", "repack",
+}
+
+var (
+	bracketedYearRe = regexp.MustCompile(`[\(\[]((?:19|20)\d{2})[\)\]]`)
+	trailingYearRe  = regexp.MustCompile(`\s+(?:19|20)\d{2}$`)
+	spacesRe        = regexp.MustCompile(`\s+`)
+)
+
+// TitleCleaner derives display titles from release-style filenames
+type TitleCleaner struct {
+	tokens     map[string]bool
+	stripYears bool
+}
+
+// NewTitleCleaner creates a cleaner that removes the given tokens
+// (case-insensitive) and optionally strips release years
+func NewTitleCleaner(tokens []string, stripYears bool) *TitleCleaner {
+	set := make(map[string]bool, len(tokens))
+	for _, t := range tokens {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			set[t] = true
+		}
+	}
+	return &TitleCleaner{tokens: set, stripYears: stripYears}
+}
+
+// Clean turns a filename (without extension) into a display title.
+// Everything from the first release tag onwards is dropped. If cleaning
+// would leave nothing, the original name is returned unchanged.
+func (c *TitleCleaner) Clean(name string) string {
+	if c == nil {
+		return name
+	}
+
+	title := name
+
+	// Dots/underscores as separators only when the name has no real spaces
+	if !strings.Contains(title, " ") {
+		title = strings.NewReplacer(".", " ", "_", " ").Replace(title)
+	}
+
+	words := strings.Fields(title)
+	for i, word := range words {
+		if i > 0 && c.isJunk(word) {
+			words = words[:i]
+			break
+		}
+	}
+	title = strings.Join(words, " ")
+
+	if c.stripYears {
+		title = bracketedYearRe.ReplaceAllString(title, "")
+		title = trailingYearRe.ReplaceAllString(strings.TrimSpace(title), "")
+	} else {
+		// Normalize "(1999)" / "[1999]" spacing but keep the year
+		title = bracketedYearRe.ReplaceAllString(title, "($1)")
+	}
+
+	title = spacesRe.ReplaceAllString(title, " ")
+	title = strings.Trim(title, " -[(")
+
+	if title == "" {
+		return name
+	}
+	return title
+}
+
+// isJunk reports whether a word is (or starts with) a release tag,
+// e.g. "1080p", "[YIFY]" or "x264-GROUP"
+func (c *TitleCleaner) isJunk(word string) bool {
+	w := strings.ToLower(strings.Trim(word, "[](){}-"))
+	if c.tokens[w] {
+		return true
+	}
+	if i := strings.Index(w, "-"); i > 0 && c.tokens[w[:i]] {
+		return true
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package media
+
+import "testing"
+
+func TestTitleCleanerClean(t *testing.T) {
+	tests := []struct {
+		name       string
+		tokens     []string
+		stripYears bool
+		in         string
+		want       string
+	}{
+		{"dotted release", DefaultStripTokens, false, "The.Matrix.1999.1080p.BluRay.x264-YIFY", "The Matrix 1999"},
+		{"underscores", DefaultStripTokens, false, "Blade_Runner_2049_2160p_HDR", "Blade Runner 2049"},
+		{"strip trailing year", DefaultStripTokens, true, "The.Matrix.1999.1080p.BluRay.x264-YIFY", "The Matrix"},
+		{"bracketed year kept", DefaultStripTokens, false, "Alien [1979] 720p", "Alien (1979)"},
+		{"bracketed year stripped", DefaultStripTokens, true, "Alien (1979) BluRay", "Alien"},
+		{"spaces keep dots", DefaultStripTokens, false, "Mr. Robot S01E01 720p", "Mr. Robot S01E01"},
+		{"bracketed tag", DefaultStripTokens, false, "Heat (1995) [YIFY]", "Heat (1995)"},
+		{"tag with group suffix", DefaultStripTokens, false, "Inception x265-RARBG", "Inception"},
+		{"case insensitive", DefaultStripTokens, false, "Up.1080P.WEBRIP", "Up"},
+		{"first word is never stripped", DefaultStripTokens, false, "4K.Restoration.Documentary", "4K Restoration Documentary"},
+		{"clean name unchanged", DefaultStripTokens, false, "Some Home Video", "Some Home Video"},
+		{"only year with stripping falls back", DefaultStripTokens, true, "(2001)", "(2001)"},
+		{"custom tokens", []string{"proper"}, false, "Movie.PROPER.1080p", "Movie"},
+		{"custom tokens leave defaults", []string{"proper"}, false, "Movie.1080p", "Movie 1080p"},
+		{"no tokens", nil, false, "Movie.1080p", "Movie 1080p"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewTitleCleaner(tt.tokens, tt.stripYears)
+			if got := c.Clean(tt.in); got != tt.want {
+				t.Errorf("Clean(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTitleCleanerNil(t *testing.T) {
+	var c *TitleCleaner
+	if got := c.Clean("The.Matrix.1080p"); got != "The.Matrix.1080p" {
+		t.Errorf("nil cleaner Clean = %q, want the name unchanged", got)
+	}
+}
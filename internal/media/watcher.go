@@ -0,0 +1,183 @@
+package media
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog"
+)
+
+// watchDebounce is how long a path must stay quiet before it is scanned, so
+// files still being copied are picked up once they are complete
+const watchDebounce = 2 * time.Second
+
+// LibraryWatcher watches the library tree and scans added, changed or
+// removed videos individually instead of running a full scan
+type LibraryWatcher struct {
+	root    string
+	scanner *Scanner
+	logger  zerolog.Logger
+	watcher *fsnotify.Watcher
+
+	pending map[string]*time.Timer // debounced paths
+	mu      sync.Mutex
+}
+
+// NewLibraryWatcher creates a watcher for the library root. The scanner
+// must have scanned the library before events are handled.
+func NewLibraryWatcher(root string, scanner *Scanner, logger zerolog.Logger) *LibraryWatcher {
+	return &LibraryWatcher{
+		root:    filepath.Clean(root),
+		scanner: scanner,
+		logger:  logger,
+		pending: make(map[string]*time.Timer),
+	}
+}
+
+// Start watches every directory below the root until ctx is done
+func (w *LibraryWatcher) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w.watcher = watcher
+
+	if err := w.addTree(w.root); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go w.run(ctx)
+	return nil
+}
+
+func (w *LibraryWatcher) run(ctx context.Context) {
+	defer w.watcher.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.mu.Lock()
+			for path, timer := range w.pending {
+				timer.Stop()
+				delete(w.pending, path)
+			}
+			w.mu.Unlock()
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.handle(event)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.logger.Warn().Err(err).Msg("library watcher error")
+		}
+	}
+}
+
+// addTree watches dir and all non-hidden directories below it.
+// fsnotify watches are not recursive.
+func (w *LibraryWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			w.logger.Warn().Err(err).Str("path", path).Msg("failed to read directory to watch")
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if err := w.watcher.Add(path); err != nil {
+			w.logger.Warn().Err(err).Str("path", path).Msg("failed to watch directory")
+		}
+		return nil
+	})
+}
+
+func (w *LibraryWatcher) handle(event fsnotify.Event) {
+	if event.Op == fsnotify.Chmod || strings.HasPrefix(filepath.Base(event.Name), ".") {
+		return
+	}
+
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			// Videos moved in together with a directory produce no events
+			// of their own
+			if err := w.addTree(event.Name); err != nil {
+				w.logger.Warn().Err(err).Str("path", event.Name).Msg("failed to watch new directory")
+			}
+			w.scheduleTree(event.Name)
+			return
+		}
+	}
+
+	// Removed directories are not videos but still need cleaning up
+	removed := event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)
+	if removed || IsSupportedVideo(event.Name) {
+		w.schedule(event.Name)
+	}
+}
+
+// scheduleTree schedules every video below a newly added directory
+func (w *LibraryWatcher) scheduleTree(dir string) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && IsSupportedVideo(d.Name()) {
+			w.schedule(path)
+		}
+		return nil
+	})
+}
+
+// schedule processes path once no further events arrived for watchDebounce
+func (w *LibraryWatcher) schedule(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if timer, ok := w.pending[path]; ok {
+		timer.Reset(watchDebounce)
+		return
+	}
+	w.pending[path] = time.AfterFunc(watchDebounce, func() {
+		w.mu.Lock()
+		delete(w.pending, path)
+		w.mu.Unlock()
+		w.process(path)
+	})
+}
+
+// process scans a path that exists or removes the entries of one that doesn't
+func (w *LibraryWatcher) process(path string) {
+	info, err := os.Stat(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := w.scanner.RemovePath(path); err != nil {
+			w.logger.Error().Err(err).Str("path", path).Msg("failed to remove deleted path")
+		}
+	case err != nil:
+		w.logger.Warn().Err(err).Str("path", path).Msg("failed to stat changed path")
+	case !info.IsDir() && IsSupportedVideo(path):
+		if _, err := w.scanner.ScanFile(path); err != nil {
+			w.logger.Error().Err(err).Str("path", path).Msg("failed to scan changed file")
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"rvcinemaview/internal/api"
+)
+
+// APIKeyHeader carries the API key; "Authorization: Bearer <key>" and an
+// api_key query parameter (for players and EventSource, which can't set
+// headers) work as well
+const APIKeyHeader = "X-API-Key"
+
+// APIKeyMiddleware answers 401 to requests without the API key (empty key =
+// no authentication). With allowShare, requests carrying a share token are
+// let through as well; the handler verifies the token, so only use it on
+// routes that do.
+func APIKeyMiddleware(key string, allowShare bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if key == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if allowShare && r.URL.Query().Get("token") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(requestAPIKey(r)), []byte(key)) != 1 {
+				writeUnauthorized(w)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestAPIKey returns the API key sent with a request, "" if none
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+func writeUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(api.ErrorResponse{
+		Error: api.ErrorDetail{
+			Code:      "UNAUTHORIZED",
+			Message:   "Missing or invalid API key",
+			RequestID: w.Header().Get(RequestIDHeader),
+		},
+	})
+}
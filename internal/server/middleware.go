@@ -1,12 +1,64 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog"
 )
 
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// requestIDRe limits which client-supplied IDs are trusted, so they can't
+// inject anything into logs
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// RequestIDMiddleware tags each request with an ID, taken from an incoming
+// X-Request-ID header or generated. The ID is stored in the request context
+// and set as a response header before the handler runs, which is where
+// error responses pick it up.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !requestIDRe.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestID returns the ID assigned by RequestIDMiddleware, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// LoggingMiddleware logs every request with its status, duration and the
+// bytes of body written. Requests for a media item also log its ID, and
+// partial requests the Range asked for.
 func LoggingMiddleware(logger zerolog.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -15,23 +67,45 @@ func LoggingMiddleware(logger zerolog.Logger) func(http.Handler) http.Handler {
 			wrapped := &responseWriter{ResponseWriter: w, status: http.StatusOK}
 			next.ServeHTTP(wrapped, r)
 
-			logger.Info().
+			event := logger.Info().
+				Str("request_id", RequestID(r.Context())).
 				Str("method", r.Method).
 				Str("path", r.URL.Path).
 				Int("status", wrapped.status).
+				Int64("bytes", wrapped.bytes).
 				Dur("duration", time.Since(start)).
-				Str("remote", r.RemoteAddr).
-				Msg("request")
+				Str("remote", r.RemoteAddr)
+			if mediaID := routeMediaID(r); mediaID != "" {
+				event = event.Str("media_id", mediaID)
+			}
+			if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+				event = event.Str("range", rangeHeader)
+			}
+			event.Msg("request")
 		})
 	}
 }
 
+// routeMediaID returns the {id} of a matched /media/{id} or /playback/{id}
+// route, "" for other requests. Only valid once the router has served r.
+func routeMediaID(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return ""
+	}
+	pattern := rctx.RoutePattern()
+	if !strings.Contains(pattern, "/media/{id}") && !strings.Contains(pattern, "/playback/{id}") {
+		return ""
+	}
+	return rctx.URLParam("id")
+}
+
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Range")
-		w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Range, X-API-Key, X-Request-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Accept-Ranges, X-Request-ID")
 
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
@@ -45,9 +119,167 @@ func CORSMiddleware(next http.Handler) http.Handler {
 type responseWriter struct {
 	http.ResponseWriter
 	status int
+	bytes  int64 // body bytes written
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
 	rw.status = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(p)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the sendfile path of http.ServeContent, which copies files
+// with io.Copy, while counting what was sent
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err := rf.ReadFrom(src)
+		rw.bytes += n
+		return n, err
+	}
+	return io.Copy(writerOnly{rw}, src)
+}
+
+// writerOnly hides ReadFrom so io.Copy goes through the counting Write
+type writerOnly struct {
+	io.Writer
+}
+
+// Flush allows streaming handlers (SSE) to flush through the wrapper
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack allows WebSocket upgrades through the wrapper
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return h.Hijack()
+}
+
+// maxDebugBody is the largest request body logged by DebugRequestsMiddleware
+const maxDebugBody = 4 << 10
+
+// debugHeaders are the request headers included in debug request logs
+var debugHeaders = []string{"Content-Type", "Content-Length", "Range", "User-Agent", "Authorization", "Cookie"}
+
+// redactedHeaders never have their values logged
+var redactedHeaders = map[string]bool{"Authorization": true, "Cookie": true}
+
+// sensitiveKeyRe matches query and JSON keys whose values are redacted
+var sensitiveKeyRe = regexp.MustCompile(`(?i)token|secret|password|key|signature`)
+
+// DebugRequestsMiddleware logs request details at debug level: headers and,
+// for small JSON bodies on write methods, the body itself. Credentials are
+// redacted. Bodies of other requests (streams, uploads) are never read.
+func DebugRequestsMiddleware(logger zerolog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			event := logger.Debug()
+			if !event.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			headers := zerolog.Dict()
+			for _, name := range debugHeaders {
+				value := r.Header.Get(name)
+				if value == "" {
+					continue
+				}
+				if redactedHeaders[name] {
+					value = "[redacted]"
+				}
+				headers.Str(name, value)
+			}
+
+			event.
+				Str("request_id", RequestID(r.Context())).
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Str("query", redactQuery(r.URL.Query())).
+				Dict("headers", headers)
+
+			if hasLoggableBody(r) {
+				body, err := io.ReadAll(io.LimitReader(r.Body, maxDebugBody+1))
+				// Hand the handler the full, untouched body
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+				if err == nil && len(body) <= maxDebugBody {
+					event.RawJSON("body", redactJSON(body))
+				} else {
+					event.Bool("body_truncated", true)
+				}
+			}
+
+			event.Msg("request details")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// hasLoggableBody reports whether a request is a write with a small text body
+func hasLoggableBody(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return false
+	}
+	if r.Body == nil || r.ContentLength == 0 || r.ContentLength > maxDebugBody {
+		return false
+	}
+	// Handlers decode JSON whatever the declared type, and misbehaving
+	// clients often send it as form data or text; skip only binary uploads
+	ct := r.Header.Get("Content-Type")
+	textual := strings.Contains(ct, "json") ||
+		strings.HasPrefix(ct, "application/x-www-form-urlencoded") || strings.HasPrefix(ct, "text/")
+	// A body of unknown length (chunked) is only read when declared as text,
+	// so untyped streams like a chunked poster upload are left alone
+	if r.ContentLength < 0 {
+		return textual
+	}
+	return ct == "" || textual
+}
+
+func redactQuery(query url.Values) string {
+	for key := range query {
+		if sensitiveKeyRe.MatchString(key) {
+			query.Set(key, "[redacted]")
+		}
+	}
+	return query.Encode()
+}
+
+// redactJSON blanks sensitive fields of a JSON object body. Non-JSON
+// bodies are logged as a string.
+func redactJSON(body []byte) []byte {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		if json.Valid(body) {
+			return body
+		}
+		quoted, _ := json.Marshal(string(body))
+		return quoted
+	}
+	for key := range obj {
+		if sensitiveKeyRe.MatchString(key) {
+			obj[key] = "[redacted]"
+		}
+	}
+	redacted, err := json.Marshal(obj)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
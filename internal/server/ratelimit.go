@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"rvcinemaview/internal/api"
+	"rvcinemaview/internal/clientip"
+)
+
+// bucketIdleTTL is how long a client's bucket is kept after its last request
+const bucketIdleTTL = 10 * time.Minute
+
+// newRateLimiter creates a limiter allowing rps requests per second per
+// client with bursts of up to burst requests (0 rps = disabled)
+func newRateLimiter(rps, burst int) *rateLimiter {
+	l := &rateLimiter{
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	l.SetLimits(rps, burst)
+	return l
+}
+
+// SetLimits changes the rate and burst at runtime; buckets start over
+// full. rps 0 disables limiting, burst 0 uses rps.
+func (l *rateLimiter) SetLimits(rps, burst int) {
+	if burst < 1 {
+		burst = rps
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = float64(rps)
+	l.burst = float64(burst)
+	l.buckets = make(map[string]*tokenBucket)
+}
+
+// Middleware answers 429 with Retry-After once a client's bucket is empty.
+// Clients are told apart by clientip.FromRequest.
+func (l *rateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.allow(clientip.FromRequest(r), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeRateLimited(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type rateLimiter struct {
+	rate      float64 // tokens added per second, 0 = unlimited
+	burst     float64 // bucket capacity
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// allow takes a token from the client's bucket. If none is left it returns
+// false and how long until the next token is available.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	if now.Sub(l.lastSweep) > bucketIdleTTL {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, lastSeen: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.lastSeen).Seconds()*l.rate)
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops buckets of clients that have been idle for bucketIdleTTL.
+// Such buckets would be full again anyway.
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if now.Sub(b.lastSeen) > bucketIdleTTL {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+func writeRateLimited(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(api.ErrorResponse{
+		Error: api.ErrorDetail{
+			Code:      "RATE_LIMITED",
+			Message:   "Too many requests",
+			RequestID: w.Header().Get(RequestIDHeader),
+		},
+	})
+}
@@ -3,24 +3,32 @@ package server
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog"
 	"rvcinemaview/internal/api"
+	"rvcinemaview/internal/clientip"
 	"rvcinemaview/internal/config"
+	"rvcinemaview/internal/events"
 	"rvcinemaview/internal/media"
 	"rvcinemaview/internal/storage"
+	"rvcinemaview/internal/streaming"
 )
 
 type Server struct {
 	cfg        *config.Config
 	logger     zerolog.Logger
 	httpServer *http.Server
+	socket     string // Unix socket path, empty when listening on TCP
 	router     *chi.Mux
 	storage    *storage.SQLiteStorage
 	handler    *api.Handler
+	limiter    *rateLimiter
 }
 
 func New(cfg *config.Config, logger zerolog.Logger, store *storage.SQLiteStorage) *Server {
@@ -28,6 +36,7 @@ func New(cfg *config.Config, logger zerolog.Logger, store *storage.SQLiteStorage
 		cfg:     cfg,
 		logger:  logger,
 		storage: store,
+		socket:  cfg.Server.UnixSocket,
 	}
 
 	s.router = chi.NewRouter()
@@ -39,45 +48,152 @@ func New(cfg *config.Config, logger zerolog.Logger, store *storage.SQLiteStorage
 		Handler:      s.router,
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
+		ConnContext:  clientip.ConnContext,
 	}
 
 	return s
 }
 
 func (s *Server) setupMiddleware() {
+	s.router.Use(RequestIDMiddleware)
+	s.router.Use(clientip.Middleware(s.cfg.Server.TrustProxy))
 	s.router.Use(CORSMiddleware)
 	s.router.Use(LoggingMiddleware(s.logger))
+	// Always installed so limits can be enabled by a config reload
+	s.limiter = newRateLimiter(s.cfg.Server.RateLimit.RPS, s.cfg.Server.RateLimit.Burst)
+	s.router.Use(s.limiter.Middleware)
+	if s.cfg.Logging.DebugRequests {
+		s.router.Use(DebugRequestsMiddleware(s.logger))
+	}
+	// Only JSON is compressed; images and video are already compressed and
+	// partial (Range) responses can't be gzipped as a whole
+	if level := s.cfg.Server.CompressionLevel; level > 0 {
+		s.router.Use(middleware.Compress(level, "application/json"))
+	}
 }
 
 func (s *Server) setupRoutes() {
-	s.handler = api.NewHandler(s.storage, s.logger, s.cfg.Library.Path, s.cfg.Library.Name)
+	s.handler = api.NewHandler(s.cfg, s.storage, s.logger)
 
 	s.router.Route("/api/v1", func(r chi.Router) {
 		r.Get("/health", s.handler.Health)
 
+		// With auth.api_key set everything else needs the key; streams
+		// also accept a signed share link instead
+		shared := r.With(APIKeyMiddleware(s.cfg.Auth.APIKey, true))
+		r = r.With(APIKeyMiddleware(s.cfg.Auth.APIKey, false))
+
+		r.Get("/events", s.handler.LibraryEvents)
+
 		r.Get("/library/tree", s.handler.GetLibraryTree)
+		r.Get("/library/stats", s.handler.GetLibraryStats)
 		r.Post("/library/scan", s.handler.ScanLibrary)
+		r.Get("/library/scan/status", s.handler.GetScanStatus)
+		r.Get("/library/scan/result", s.handler.GetScanResult)
+
+		r.Get("/search", s.handler.SearchMedia)
 
+		r.Get("/media", s.handler.GetAllMedia)
+		r.Get("/media/watched", s.handler.GetWatched)
+		r.Get("/media/next-up", s.handler.GetNextUp)
 		r.Get("/media/{id}", s.handler.GetMedia)
-		r.Get("/media/{id}/stream", s.handler.StreamMedia)
+		r.Delete("/media/{id}", s.handler.DeleteMedia)
+		shared.Get("/media/{id}/stream", s.handler.StreamMedia)
+		shared.Get("/media/{id}/stream.mp4", s.handler.StreamMediaAs(streaming.ContainerMP4))
+		shared.Get("/media/{id}/stream.mkv", s.handler.StreamMediaAs(streaming.ContainerMKV))
+		shared.Get("/media/{id}/hls/playlist.m3u8", s.handler.GetHLSPlaylist)
+		shared.Get("/media/{id}/hls/{segment}", s.handler.GetHLSSegment)
+		r.Get("/media/{id}/share", s.handler.ShareMedia)
+		r.Get("/media/{id}/checksum", s.handler.GetChecksum)
 		r.Get("/media/{id}/thumbnail", s.handler.GetThumbnail)
+		r.Post("/media/{id}/thumbnail/regenerate", s.handler.RegenerateThumbnail)
+		r.Get("/media/{id}/frame", s.handler.GetFrame)
+		r.Get("/media/{id}/sprite.jpg", s.handler.GetSpriteImage)
+		r.Get("/media/{id}/sprite.vtt", s.handler.GetSpriteVTT)
+		r.Get("/media/{id}/artwork/{type}", s.handler.GetArtwork)
+		r.Get("/media/{id}/poster", s.handler.GetPoster)
+		r.Put("/media/{id}/poster", s.handler.SetPoster)
+		r.Delete("/media/{id}/poster", s.handler.DeletePoster)
+		r.Get("/media/{id}/subtitles", s.handler.GetSubtitles)
+		r.Get("/media/{id}/tracks", s.handler.GetMediaTracks)
+		r.Post("/media/{id}/process", s.handler.ProcessMedia)
+		r.Post("/media/{id}/rescan", s.handler.RescanMedia)
+		r.Get("/media/{id}/status", s.handler.GetMediaStatus)
+		r.Post("/media/{id}/watched", s.handler.SetWatched)
+		r.Patch("/media/{id}/markers", s.handler.SetMediaMarkers)
+		r.Post("/media/{id}/tags", s.handler.AddMediaTags)
+		r.Delete("/media/{id}/tags", s.handler.RemoveMediaTags)
+		r.Post("/media/{id}/favorite", s.handler.AddFavorite)
+		r.Delete("/media/{id}/favorite", s.handler.RemoveFavorite)
+
+		r.Get("/tags/{tag}/media", s.handler.GetTagMedia)
+		r.Get("/favorites", s.handler.GetFavorites)
+
+		r.Patch("/folders/{id}", s.handler.UpdateFolder)
+		r.Post("/folders/{id}/rename", s.handler.RenameFolder)
+		r.Get("/folders/{id}/media", s.handler.GetFolderMedia)
+		r.Get("/folders/{id}/thumbnail", s.handler.GetFolderThumbnail)
+		r.Get("/folders/{id}/thumbnails/atlas", s.handler.GetFolderAtlas)
+		r.Get("/folders/{id}/thumbnails/atlas.jpg", s.handler.GetFolderAtlasImage)
+		r.Post("/thumbnails/prewarm", s.handler.PrewarmThumbnails)
 
 		// Playback progress
 		r.Post("/playback/{id}/position", s.handler.SavePlaybackPosition)
 		r.Get("/playback/{id}/position", s.handler.GetPlaybackPosition)
+		r.Delete("/playback/{id}/position", s.handler.DeletePlaybackPosition)
 		r.Get("/playback/continue", s.handler.GetContinueWatching)
+		r.Post("/playback/batch", s.handler.GetPlaybackBatch)
+		r.Get("/playback/events", s.handler.PlaybackEvents)
+
+		// Admin
+		r.Get("/admin/folders", s.handler.GetFolderReport)
+		r.Post("/admin/repair", s.handler.RepairLibrary)
+		r.Get("/admin/audit", s.handler.GetAuditLog)
+		r.Get("/admin/duplicates", s.handler.GetDuplicates)
+		r.Get("/admin/cache/stats", s.handler.GetCacheStats)
+		r.Post("/admin/cache/stats/reset", s.handler.ResetCacheStats)
+		r.Post("/admin/cache/clear", s.handler.ClearCache)
 	})
 }
 
+// SetRateLimit changes the per-client rate limit (0 rps = disabled)
+func (s *Server) SetRateLimit(rps, burst int) {
+	s.limiter.SetLimits(rps, burst)
+}
+
 func (s *Server) SetScanner(scanner api.ScannerInterface) {
 	s.handler.SetScanner(scanner)
 }
 
+func (s *Server) SetEventBus(bus *events.Bus) {
+	s.handler.SetEventBus(bus)
+}
+
+func (s *Server) SetLibraryMonitor(monitor *media.LibraryMonitor) {
+	s.handler.SetLibraryMonitor(monitor)
+}
+
+func (s *Server) SetSubtitleExtractor(extractor *media.SubtitleExtractor) {
+	s.handler.SetSubtitleExtractor(extractor)
+}
+
+func (s *Server) SetPosterStore(store *media.PosterStore) {
+	s.handler.SetPosterStore(store)
+}
+
+func (s *Server) SetHLSManager(manager *streaming.HLSManager) {
+	s.handler.SetHLSManager(manager)
+}
+
 func (s *Server) SetThumbnailService(service *media.ThumbnailService) {
 	s.handler.SetThumbnailService(service)
 }
 
 func (s *Server) Start() error {
+	if s.socket != "" {
+		return s.startUnix()
+	}
+
 	s.logger.Info().
 		Str("addr", s.httpServer.Addr).
 		Msg("starting server")
@@ -89,11 +205,53 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// socketMode lets the owner and group (e.g. a reverse proxy user added to
+// the server's group) connect to the Unix socket
+const socketMode = 0660
+
+// startUnix serves on the configured Unix socket, replacing a socket left
+// behind by an earlier run
+func (s *Server) startUnix() error {
+	if info, err := os.Lstat(s.socket); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("unix socket path %s exists and is not a socket", s.socket)
+		}
+		if err := os.Remove(s.socket); err != nil {
+			return fmt.Errorf("remove stale unix socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", s.socket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(s.socket, socketMode); err != nil {
+		listener.Close()
+		return fmt.Errorf("set unix socket permissions: %w", err)
+	}
+
+	s.logger.Info().
+		Str("socket", s.socket).
+		Msg("starting server")
+
+	if err := s.httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.Info().Msg("shutting down server")
 
 	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
-	return s.httpServer.Shutdown(shutdownCtx)
+	err := s.httpServer.Shutdown(shutdownCtx)
+	if s.socket != "" {
+		if rmErr := os.Remove(s.socket); rmErr != nil && !os.IsNotExist(rmErr) {
+			s.logger.Warn().Err(rmErr).Str("socket", s.socket).Msg("failed to remove unix socket")
+		}
+	}
+	return err
 }
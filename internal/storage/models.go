@@ -3,18 +3,84 @@ package storage
 import "time"
 
 type Folder struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Path      string    `json:"-"`
-	ParentID  *string   `json:"-"` // Internal use only
-	ItemCount int       `json:"-"` // Internal use only
-	CreatedAt time.Time `json:"-"`
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`                    // Display name if renamed, else the directory name
+	OriginalName *string   `json:"original_name,omitempty"` // Directory name, only set when renamed
+	Path         string    `json:"-"`
+	ParentID     *string   `json:"-"` // Internal use only
+	ItemCount    int       `json:"-"` // Internal use only
+	IsSeries     bool      `json:"is_series"`
+	CreatedAt    time.Time `json:"-"`
+}
+
+// FolderReportEntry is a folder with aggregated stats for the admin report
+type FolderReportEntry struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Path         string     `json:"path"`
+	ParentID     *string    `json:"parent_id"`
+	MediaCount   int        `json:"media_count"`
+	TotalSize    int64      `json:"total_size"`              // Bytes
+	LastModified *time.Time `json:"last_modified,omitempty"` // Newest file mtime, nil for empty folders
+}
+
+// LibraryStats are library-wide totals
+type LibraryStats struct {
+	MediaCount        int   `json:"media_count"`
+	FolderCount       int   `json:"folder_count"`
+	TotalSize         int64 `json:"total_size"`         // Bytes
+	TotalDuration     int64 `json:"total_duration"`     // Seconds, items without metadata count as 0
+	MissingMetadata   int   `json:"missing_metadata"`   // No duration yet, metadata not extracted
+	MissingThumbnails int   `json:"missing_thumbnails"` // No thumbnail generated yet
+}
+
+// DuplicateGroup is a set of media that look like copies of the same file
+type DuplicateGroup struct {
+	Size  int64           `json:"size"` // Bytes, shared by every item
+	Media []DuplicateItem `json:"media"`
+}
+
+// DuplicateItem is one candidate in a DuplicateGroup
+type DuplicateItem struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Path     string `json:"path"`
+	FolderID string `json:"folder_id"` // Empty for media in the library root
+}
+
+// FolderStats sums the media in a folder and its subfolders
+type FolderStats struct {
+	ItemCount     int   `json:"item_count"`
+	TotalSize     int64 `json:"total_size"`     // Bytes
+	TotalDuration int64 `json:"total_duration"` // Seconds, items without metadata count as 0
+}
+
+// Add accumulates another folder's stats
+func (s *FolderStats) Add(other FolderStats) {
+	s.ItemCount += other.ItemCount
+	s.TotalSize += other.TotalSize
+	s.TotalDuration += other.TotalDuration
+}
+
+// MediaTrack is one audio or subtitle stream of a media file
+type MediaTrack struct {
+	Index    int    `json:"index"`           // 0-based among streams of the same type, as used by ?audio=
+	Codec    string `json:"codec"`           // ffprobe codec name
+	Language string `json:"language"`        // ISO 639 code, "und" when untagged
+	Title    string `json:"title,omitempty"` // Stream title tag
+}
+
+// MediaTracks lists a media file's audio and subtitle streams
+type MediaTracks struct {
+	Audio     []MediaTrack `json:"audio"`
+	Subtitles []MediaTrack `json:"subtitles"`
 }
 
 type MediaItem struct {
 	ID            string    `json:"id"`
 	FolderID      string    `json:"-"` // Internal use only
 	Title         string    `json:"title"`
+	FileName      string    `json:"file_name"` // Raw filename, derived from Path
 	Path          string    `json:"-"`
 	Size          int64     `json:"size"`
 	Duration      *int64    `json:"duration,omitempty"`
@@ -23,16 +89,37 @@ type MediaItem struct {
 	VideoCodec    *string   `json:"video_codec,omitempty"`
 	AudioCodec    *string   `json:"audio_codec,omitempty"`
 	AudioChannels *int      `json:"audio_channels,omitempty"` // 2 = stereo, 6 = 5.1, 8 = 7.1
-	HasSubtitles  bool      `json:"-"`                        // Internal use only
+	AudioTracks   *int      `json:"audio_tracks,omitempty"`   // Number of audio streams
+	Bitrate       *int64    `json:"bitrate,omitempty"`        // Overall bits per second
+	Container     string    `json:"container"`                // Lowercase file extension, e.g. "mkv"
+	Year          *int      `json:"year,omitempty"`
+	Plot          *string   `json:"plot,omitempty"`
+	Genres        []string  `json:"genres,omitempty"`
+	PosterURL     *string   `json:"poster_url,omitempty"`
+	Tags          []string  `json:"tags,omitempty"`          // Lowercase, sorted
+	IntroStart    *float64  `json:"intro_start,omitempty"`   // Seconds
+	IntroEnd      *float64  `json:"intro_end,omitempty"`     // Seconds
+	Season        *int      `json:"season,omitempty"`        // Parsed from the filename when scanned
+	Episode       *int      `json:"episode,omitempty"`       // Parsed from the filename when scanned
+	SeriesName    *string   `json:"series_name,omitempty"`   // Show name before the episode marker
+	ContentHash   *string   `json:"-"`                       // Partial hash, set with library.stable_ids
+	HasSubtitles  bool      `json:"has_subtitles"`           // Embedded subtitle track, served at /subtitles
+	HasPoster     bool      `json:"has_poster"`              // Uploaded poster, served at /poster
+	ThumbAttempts int       `json:"-"`                       // Failed thumbnail generations, internal use only
+	ThumbVersion  int       `json:"thumbnail_version"`       // Bumped on every generation, used as ?v= to bust caches
+	DateAdded     string    `json:"date_added,omitempty"`    // RFC3339, read-only copy of CreatedAt
+	DateModified  string    `json:"date_modified,omitempty"` // RFC3339, read-only copy of ModifiedAt (file mtime)
 	ModifiedAt    time.Time `json:"-"`
 	CreatedAt     time.Time `json:"-"`
 }
 
 type PlaybackState struct {
 	MediaID   string    `json:"media_id"`
-	Position  int64     `json:"position"` // Seconds
-	Duration  int64     `json:"duration"` // Seconds
-	Progress  float64   `json:"progress"` // 0.0 - 1.0
+	Position  int64     `json:"position"`   // Seconds
+	Duration  int64     `json:"duration"`   // Seconds
+	Progress  float64   `json:"progress"`   // 0.0 - 1.0
+	IsWatched bool      `json:"is_watched"` // Watched flag or progress past the watched threshold
+	Watched   bool      `json:"-"`          // Explicitly marked watched, see SetWatched
 	UpdatedAt time.Time `json:"-"`
 }
 
@@ -41,3 +128,61 @@ type ContinueWatchingItem struct {
 	Media         MediaItem     `json:"media"`
 	PlaybackState PlaybackState `json:"playback_state"`
 }
+
+// FavoriteItem is a favorite media item with the time it was marked
+type FavoriteItem struct {
+	Media   MediaItem `json:"media"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+// MediaListOptions controls filtering and ordering of media listings
+type MediaListOptions struct {
+	HideWatched bool    // exclude items whose progress reached WatchedAt
+	WatchedAt   float64 // watched threshold (0.0 - 1.0)
+	Sort        string  // one of the MediaSort* keys, "" = title
+	Desc        bool    // reverse the sort order
+}
+
+// Media sort keys for MediaListOptions.Sort
+const (
+	MediaSortTitle        = "title"
+	MediaSortDateAdded    = "date_added"
+	MediaSortDateModified = "date_modified"
+	MediaSortSize         = "size"
+)
+
+// AuditEntry is one recorded library change
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"` // one of the Audit* actions
+	Target    string    `json:"target"` // usually the affected path
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Audit log actions
+const (
+	AuditScanStarted   = "scan_started"
+	AuditScanFinished  = "scan_finished"
+	AuditMediaRemoved  = "media_removed"  // file gone from disk
+	AuditFolderRemoved = "folder_removed" // directory gone from disk
+	AuditMediaDeleted  = "media_deleted"  // deleted through the API
+	AuditMediaPurged   = "media_purged"   // removed for good after the trash retention
+	AuditMediaMoved    = "media_moved"    // file found at a new path, same ID kept
+)
+
+// RepairReport summarizes referential problems found (and fixed unless
+// DryRun) by RepairConsistency
+type RepairReport struct {
+	DryRun             bool `json:"dry_run"`
+	OrphanedFolders    int  `json:"orphaned_folders"`    // parent_id points at a missing folder
+	FoldersReparented  int  `json:"folders_reparented"`  // moved under the folder matching their parent path
+	FoldersMadeRoot    int  `json:"folders_made_root"`   // no parent folder found, now top-level
+	OrphanedMedia      int  `json:"orphaned_media"`      // folder_id points at a missing folder
+	MediaReparented    int  `json:"media_reparented"`    // moved to the folder matching their directory
+	MediaMadeRoot      int  `json:"media_made_root"`     // no folder found, now at library root
+	OrphanedPlayback   int  `json:"orphaned_playback"`   // playback rows removed for missing media
+	OrphanedThumbnails int  `json:"orphaned_thumbnails"` // thumbnail blobs removed for missing media
+	OrphanedTags       int  `json:"orphaned_tags"`       // tag links removed for missing media
+	OrphanedFavorites  int  `json:"orphaned_favorites"`  // favorites removed for missing media
+}
@@ -1,16 +1,68 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
+	"github.com/rs/zerolog"
 	_ "modernc.org/sqlite"
 )
 
 type SQLiteStorage struct {
-	db *sql.DB
+	db         *sql.DB
+	onPlayback func(mediaID string) // see SetPlaybackHook
+}
+
+// defaultMaxOpenConns is the connection pool size, see SetMaxOpenConns
+const defaultMaxOpenConns = 4
+
+// SetMaxOpenConns sizes the connection pool. Reads run in parallel on
+// separate connections while SQLite still allows one writer at a time.
+// Non-positive values keep the current size.
+func (s *SQLiteStorage) SetMaxOpenConns(n int) {
+	if n <= 0 {
+		return
+	}
+	s.db.SetMaxOpenConns(n)
+	s.db.SetMaxIdleConns(n)
+}
+
+// Checkpoint copies the write-ahead log into the database and truncates it
+func (s *SQLiteStorage) Checkpoint() error {
+	var busy, logFrames, checkpointed int
+	return s.db.QueryRow("PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &logFrames, &checkpointed)
+}
+
+// StartCheckpointer checkpoints the WAL on every interval until ctx is
+// done, so it doesn't grow unbounded during write-heavy scans
+func (s *SQLiteStorage) StartCheckpointer(ctx context.Context, interval time.Duration, logger zerolog.Logger) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Checkpoint(); err != nil {
+					logger.Warn().Err(err).Msg("wal checkpoint failed")
+				}
+			}
+		}
+	}()
 }
 
 func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
@@ -19,15 +71,17 @@ func NewSQLiteStorage(dbPath string) (*SQLiteStorage, error) {
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
+	// Pragmas apply to every pooled connection. In WAL mode readers don't
+	// block behind the writer; immediate transactions take the write lock
+	// up front so concurrent writers wait (busy_timeout) instead of failing.
+	db, err := sql.Open("sqlite", dbPath+
+		"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate")
 	if err != nil {
 		return nil, err
 	}
 
-	db.SetMaxOpenConns(1)
-	db.SetMaxIdleConns(1)
-
 	s := &SQLiteStorage{db: db}
+	s.SetMaxOpenConns(defaultMaxOpenConns)
 
 	if err := s.migrate(); err != nil {
 		db.Close()
@@ -45,6 +99,9 @@ func (s *SQLiteStorage) migrate() error {
 		path TEXT NOT NULL UNIQUE,
 		parent_id TEXT REFERENCES folders(id),
 		item_count INTEGER DEFAULT 0,
+		is_series BOOLEAN DEFAULT FALSE,
+		series_locked BOOLEAN DEFAULT FALSE,
+		display_name TEXT,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
@@ -60,15 +117,43 @@ func (s *SQLiteStorage) migrate() error {
 		video_codec TEXT,
 		audio_codec TEXT,
 		audio_channels INTEGER,
+		audio_tracks INTEGER,
+		bitrate INTEGER,
 		has_subtitles BOOLEAN DEFAULT FALSE,
+		year INTEGER,
+		plot TEXT,
+		genres TEXT,
+		poster_url TEXT,
+		tags TEXT,
+		title_locked BOOLEAN DEFAULT FALSE,
+		checksum TEXT,
+		checksum_size INTEGER,
+		checksum_mtime DATETIME,
+		checksum_md5 TEXT,
+		checksum_md5_size INTEGER,
+		checksum_md5_mtime DATETIME,
+		intro_start REAL,
+		intro_end REAL,
+		intro_source TEXT,
 		thumbnail_generated BOOLEAN DEFAULT FALSE,
+		thumbnail_attempts INTEGER NOT NULL DEFAULT 0,
+		thumbnail_version INTEGER NOT NULL DEFAULT 0,
+		metadata_failed BOOLEAN DEFAULT FALSE,
+		tracks TEXT,
+		season INTEGER,
+		episode INTEGER,
+		series_name TEXT,
+		content_hash TEXT,
+		has_poster BOOLEAN DEFAULT FALSE,
 		file_modified_at DATETIME,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		deleted_at DATETIME
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_media_folder ON media_items(folder_id);
 	CREATE INDEX IF NOT EXISTS idx_media_title ON media_items(title);
+	CREATE INDEX IF NOT EXISTS idx_media_created ON media_items(created_at);
 	CREATE INDEX IF NOT EXISTS idx_folders_parent ON folders(parent_id);
 
 	CREATE TABLE IF NOT EXISTS playback_states (
@@ -76,10 +161,48 @@ func (s *SQLiteStorage) migrate() error {
 		position INTEGER NOT NULL,
 		duration INTEGER NOT NULL,
 		progress REAL NOT NULL,
+		watched BOOLEAN NOT NULL DEFAULT FALSE,
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_playback_updated ON playback_states(updated_at DESC);
+
+	CREATE TABLE IF NOT EXISTS thumbnails (
+		media_id TEXT NOT NULL REFERENCES media_items(id) ON DELETE CASCADE,
+		width INTEGER NOT NULL,
+		data BLOB NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (media_id, width)
+	);
+
+	CREATE TABLE IF NOT EXISTS tags (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE
+	);
+
+	CREATE TABLE IF NOT EXISTS media_tags (
+		media_id TEXT NOT NULL REFERENCES media_items(id) ON DELETE CASCADE,
+		tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (media_id, tag_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_media_tags_tag ON media_tags(tag_id);
+
+	CREATE TABLE IF NOT EXISTS favorites (
+		media_id TEXT PRIMARY KEY REFERENCES media_items(id) ON DELETE CASCADE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_favorites_created ON favorites(created_at DESC);
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL,
+		detail TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
 	`
 
 	_, err := s.db.Exec(schema)
@@ -87,44 +210,250 @@ func (s *SQLiteStorage) migrate() error {
 		return err
 	}
 
-	// Migration: add audio_channels column if it doesn't exist
-	_, _ = s.db.Exec("ALTER TABLE media_items ADD COLUMN audio_channels INTEGER")
+	// Columns added after the initial schema, for databases created earlier
+	for _, m := range columnMigrations {
+		if err := s.addColumn(m.table, m.column, m.definition); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
+	// Indexes on migrated columns can only be created once they exist
+	if _, err := s.db.Exec("CREATE INDEX IF NOT EXISTS idx_media_content_hash ON media_items(content_hash)"); err != nil {
+		return err
+	}
+
+	if err := s.migrateTagColumn(); err != nil {
+		return fmt.Errorf("migrate tags: %w", err)
+	}
 
 	return nil
 }
 
-func (s *SQLiteStorage) Close() error {
-	return s.db.Close()
+// migrateTagColumn moves tags stored in the old media_items.tags column into
+// the tags tables. The column is cleared afterwards, so this only does work
+// once.
+func (s *SQLiteStorage) migrateTagColumn() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, tags FROM media_items WHERE tags IS NOT NULL AND tags != ''")
+	if err != nil {
+		return err
+	}
+	legacy := make(map[string][]string)
+	for rows.Next() {
+		var id, tags string
+		if err := rows.Scan(&id, &tags); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy[id] = strings.Split(tags, genreSeparator)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	for id, tags := range legacy {
+		if err := addTags(tx, id, tags); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE media_items SET tags = NULL"); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// Folders
-func (s *SQLiteStorage) GetRootFolders() ([]Folder, error) {
+// columnMigrations lists columns added to existing tables over time
+var columnMigrations = []struct {
+	table, column, definition string
+}{
+	{"media_items", "audio_channels", "INTEGER"},
+
+	// NFO metadata
+	{"media_items", "year", "INTEGER"},
+	{"media_items", "plot", "TEXT"},
+	{"media_items", "genres", "TEXT"},
+
+	// Enrichment
+	{"media_items", "poster_url", "TEXT"},
+	{"media_items", "tags", "TEXT"},
+	{"media_items", "title_locked", "BOOLEAN DEFAULT FALSE"},
+
+	// Cached checksum
+	{"media_items", "checksum", "TEXT"},
+	{"media_items", "checksum_size", "INTEGER"},
+	{"media_items", "checksum_mtime", "DATETIME"},
+	{"media_items", "checksum_md5", "TEXT"},
+	{"media_items", "checksum_md5_size", "INTEGER"},
+	{"media_items", "checksum_md5_mtime", "DATETIME"},
+
+	// Series flag
+	{"folders", "is_series", "BOOLEAN DEFAULT FALSE"},
+	{"folders", "series_locked", "BOOLEAN DEFAULT FALSE"},
+
+	// Audio stream count
+	{"media_items", "audio_tracks", "INTEGER"},
+
+	// Intro skip markers
+	{"media_items", "intro_start", "REAL"},
+	{"media_items", "intro_end", "REAL"},
+	{"media_items", "intro_source", "TEXT"},
+
+	// Failed metadata extraction
+	{"media_items", "metadata_failed", "BOOLEAN DEFAULT FALSE"},
+
+	// Failed thumbnail generations
+	{"media_items", "thumbnail_attempts", "INTEGER NOT NULL DEFAULT 0"},
+
+	// Explicit watched flag
+	{"playback_states", "watched", "BOOLEAN NOT NULL DEFAULT FALSE"},
+
+	// Audio and subtitle streams (JSON)
+	{"media_items", "tracks", "TEXT"},
+
+	// Thumbnail cache busting
+	{"media_items", "thumbnail_version", "INTEGER NOT NULL DEFAULT 0"},
+
+	// Episode markers parsed from filenames
+	{"media_items", "season", "INTEGER"},
+	{"media_items", "episode", "INTEGER"},
+	{"media_items", "series_name", "TEXT"},
+
+	// Overall bitrate from ffprobe
+	{"media_items", "bitrate", "INTEGER"},
+
+	// Set when the file went missing, the row is purged after a retention window
+	{"media_items", "deleted_at", "DATETIME"},
+
+	// Partial content hash for recognizing moved files (library.stable_ids)
+	{"media_items", "content_hash", "TEXT"},
+
+	// Uploaded poster image
+	{"media_items", "has_poster", "BOOLEAN DEFAULT FALSE"},
+
+	// Folder name shown instead of the directory name
+	{"folders", "display_name", "TEXT"},
+}
+
+// addColumn adds a column unless the table already has it, so migrations
+// can run on every start and real ALTER failures are still reported
+func (s *SQLiteStorage) addColumn(table, column, definition string) error {
+	exists, err := s.columnExists(table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = s.db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
+// columnExists checks the table schema for a column
+func (s *SQLiteStorage) columnExists(table, column string) (bool, error) {
+	rows, err := s.db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// maxAuditEntries is how many audit log entries are kept, older ones are
+// dropped as new ones are appended
+const maxAuditEntries = 10000
+
+// AppendAudit records a library change, see the Audit* actions. target is
+// usually a path, detail a short human-readable explanation.
+func (s *SQLiteStorage) AppendAudit(action, target, detail string) error {
+	res, err := s.db.Exec(
+		"INSERT INTO audit_log (action, target, detail, created_at) VALUES (?, ?, ?, ?)",
+		action, target, nullIfEmpty(detail), time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("DELETE FROM audit_log WHERE id <= ?", id-maxAuditEntries)
+	return err
+}
+
+// GetAuditLog returns audit entries, newest first
+func (s *SQLiteStorage) GetAuditLog(limit, offset int) ([]AuditEntry, error) {
 	rows, err := s.db.Query(`
-		SELECT id, name, path, parent_id, item_count, created_at
-		FROM folders WHERE parent_id IS NULL ORDER BY name
-	`)
+		SELECT id, action, target, COALESCE(detail, ''), created_at
+		FROM audit_log
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var folders []Folder
+	var entries []AuditEntry
 	for rows.Next() {
-		var f Folder
-		if err := rows.Scan(&f.ID, &f.Name, &f.Path, &f.ParentID, &f.ItemCount, &f.CreatedAt); err != nil {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Action, &e.Target, &e.Detail, &e.CreatedAt); err != nil {
 			return nil, err
 		}
-		folders = append(folders, f)
+		entries = append(entries, e)
 	}
+	return entries, rows.Err()
+}
 
-	return folders, rows.Err()
+// Ping checks that the database can be reached
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
-func (s *SQLiteStorage) GetSubFolders(parentID string) ([]Folder, error) {
-	rows, err := s.db.Query(`
-		SELECT id, name, path, parent_id, item_count, created_at
-		FROM folders WHERE parent_id = ? ORDER BY name
-	`, parentID)
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+// Folders
+
+// folderColumns is the column list read by scanFolder
+const folderColumns = "id, name, display_name, path, parent_id, item_count, is_series, created_at"
+
+// scanFolder scans a row selected with folderColumns. A display name
+// replaces the directory name, which is kept as OriginalName.
+func scanFolder(row rowScanner) (*Folder, error) {
+	var f Folder
+	var displayName sql.NullString
+	if err := row.Scan(&f.ID, &f.Name, &displayName, &f.Path, &f.ParentID, &f.ItemCount, &f.IsSeries, &f.CreatedAt); err != nil {
+		return nil, err
+	}
+	if displayName.Valid {
+		original := f.Name
+		f.Name = displayName.String
+		f.OriginalName = &original
+	}
+	return &f, nil
+}
+
+// queryFolders runs a query selecting folderColumns
+func (s *SQLiteStorage) queryFolders(query string, args ...interface{}) ([]Folder, error) {
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -132,306 +461,1381 @@ func (s *SQLiteStorage) GetSubFolders(parentID string) ([]Folder, error) {
 
 	var folders []Folder
 	for rows.Next() {
-		var f Folder
-		if err := rows.Scan(&f.ID, &f.Name, &f.Path, &f.ParentID, &f.ItemCount, &f.CreatedAt); err != nil {
+		f, err := scanFolder(rows)
+		if err != nil {
 			return nil, err
 		}
-		folders = append(folders, f)
+		folders = append(folders, *f)
 	}
 
 	return folders, rows.Err()
 }
 
-func (s *SQLiteStorage) CreateFolder(f *Folder) error {
-	_, err := s.db.Exec(`
-		INSERT INTO folders (id, name, path, parent_id, item_count, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-		ON CONFLICT(path) DO UPDATE SET name = excluded.name
-	`, f.ID, f.Name, f.Path, f.ParentID, f.ItemCount, f.CreatedAt)
+func (s *SQLiteStorage) GetRootFolders() ([]Folder, error) {
+	return s.queryFolders(`
+		SELECT ` + folderColumns + `
+		FROM folders WHERE parent_id IS NULL ORDER BY COALESCE(display_name, name)
+	`)
+}
 
-	return err
+func (s *SQLiteStorage) GetSubFolders(parentID string) ([]Folder, error) {
+	return s.queryFolders(`
+		SELECT `+folderColumns+`
+		FROM folders WHERE parent_id = ? ORDER BY COALESCE(display_name, name)
+	`, parentID)
 }
 
-func (s *SQLiteStorage) UpdateFolderItemCount(id string, count int) error {
-	_, err := s.db.Exec("UPDATE folders SET item_count = ? WHERE id = ?", count, id)
+// GetFolder returns a folder by ID, or nil if it doesn't exist
+func (s *SQLiteStorage) GetFolder(id string) (*Folder, error) {
+	f, err := scanFolder(s.db.QueryRow(`
+		SELECT `+folderColumns+`
+		FROM folders WHERE id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return f, err
+}
+
+// RenameFolder sets the name a folder is shown with, leaving the directory
+// on disk alone. nil goes back to the directory name.
+func (s *SQLiteStorage) RenameFolder(id string, displayName *string) error {
+	_, err := s.db.Exec("UPDATE folders SET display_name = ? WHERE id = ?", displayName, id)
 	return err
 }
 
-// Media Items
-func (s *SQLiteStorage) GetMediaItem(id string) (*MediaItem, error) {
-	row := s.db.QueryRow(`
-		SELECT id, folder_id, title, path, size, duration, width, height,
-		       video_codec, audio_codec, audio_channels, has_subtitles, file_modified_at, created_at
-		FROM media_items WHERE id = ?
-	`, id)
+// upsertFolderSQL inserts a folder or renames the one at the same path
+const upsertFolderSQL = `
+	INSERT INTO folders (id, name, path, parent_id, item_count, created_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT(path) DO UPDATE SET name = excluded.name
+`
 
-	var m MediaItem
-	var modifiedAt sql.NullTime
-	err := row.Scan(
-		&m.ID, &m.FolderID, &m.Title, &m.Path, &m.Size,
-		&m.Duration, &m.Width, &m.Height,
-		&m.VideoCodec, &m.AudioCodec, &m.AudioChannels, &m.HasSubtitles,
-		&modifiedAt, &m.CreatedAt,
-	)
-	if err == sql.ErrNoRows {
-		return nil, nil
+func (s *SQLiteStorage) CreateFolder(f *Folder) error {
+	_, err := s.db.Exec(upsertFolderSQL, f.ID, f.Name, f.Path, f.ParentID, f.ItemCount, f.CreatedAt)
+	return err
+}
+
+// CreateFoldersBatch upserts folders like CreateFolder in one transaction
+func (s *SQLiteStorage) CreateFoldersBatch(folders []*Folder) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(upsertFolderSQL)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	defer stmt.Close()
 
-	if modifiedAt.Valid {
-		m.ModifiedAt = modifiedAt.Time
+	for _, f := range folders {
+		if _, err := stmt.Exec(f.ID, f.Name, f.Path, f.ParentID, f.ItemCount, f.CreatedAt); err != nil {
+			return err
+		}
 	}
 
-	return &m, nil
+	return tx.Commit()
 }
 
-func (s *SQLiteStorage) GetMediaItemByPath(path string) (*MediaItem, error) {
-	row := s.db.QueryRow(`
-		SELECT id, folder_id, title, path, size, duration, width, height,
-		       video_codec, audio_codec, audio_channels, has_subtitles, file_modified_at, created_at
-		FROM media_items WHERE path = ?
-	`, path)
-
-	var m MediaItem
-	var modifiedAt sql.NullTime
-	err := row.Scan(
-		&m.ID, &m.FolderID, &m.Title, &m.Path, &m.Size,
-		&m.Duration, &m.Width, &m.Height,
-		&m.VideoCodec, &m.AudioCodec, &m.AudioChannels, &m.HasSubtitles,
-		&modifiedAt, &m.CreatedAt,
+// SetFolderSeries stores the inferred series flag of a folder, unless it
+// was set manually
+func (s *SQLiteStorage) SetFolderSeries(id string, isSeries bool) error {
+	_, err := s.db.Exec(
+		"UPDATE folders SET is_series = ? WHERE id = ? AND NOT COALESCE(series_locked, FALSE)",
+		isSeries, id,
 	)
-	if err == sql.ErrNoRows {
-		return nil, nil
+	return err
+}
+
+// OverrideFolderSeries manually sets the series flag, which then survives
+// rescans. nil removes the override; the flag is re-inferred on the next scan.
+func (s *SQLiteStorage) OverrideFolderSeries(id string, isSeries *bool) error {
+	if isSeries == nil {
+		_, err := s.db.Exec("UPDATE folders SET series_locked = FALSE WHERE id = ?", id)
+		return err
 	}
+	_, err := s.db.Exec("UPDATE folders SET is_series = ?, series_locked = TRUE WHERE id = ?", *isSeries, id)
+	return err
+}
+
+func (s *SQLiteStorage) UpdateFolderItemCount(id string, count int) error {
+	_, err := s.db.Exec("UPDATE folders SET item_count = ? WHERE id = ?", count, id)
+	return err
+}
+
+// folderReportSorts maps report sort keys to ORDER BY expressions
+var folderReportSorts = map[string]string{
+	"name":          "f.name",
+	"path":          "f.path",
+	"media_count":   "media_count",
+	"total_size":    "total_size",
+	"last_modified": "last_modified",
+}
+
+// dbTimeLayout is how the sqlite driver stores time.Time values. Aggregates
+// like MAX() lose the column type, so they come back as text in this format.
+const dbTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// GetFolderStats returns item count, size and duration totals for a folder
+// including all of its subfolders
+func (s *SQLiteStorage) GetFolderStats(folderID string) (FolderStats, error) {
+	var stats FolderStats
+	err := s.db.QueryRow(`
+		WITH RECURSIVE tree(id) AS (
+			SELECT ?
+			UNION ALL
+			SELECT f.id FROM folders f JOIN tree t ON f.parent_id = t.id
+		)
+		SELECT COUNT(*), COALESCE(SUM(size), 0), COALESCE(SUM(duration), 0)
+		FROM media_items
+		WHERE folder_id IN (SELECT id FROM tree) AND deleted_at IS NULL
+	`, folderID).Scan(&stats.ItemCount, &stats.TotalSize, &stats.TotalDuration)
+	return stats, err
+}
+
+// GetFolderMediaIDs returns the IDs of all media in a folder and its
+// subfolders, the folder's own media first, then by depth and title
+func (s *SQLiteStorage) GetFolderMediaIDs(folderID string) ([]string, error) {
+	rows, err := s.db.Query(`
+		WITH RECURSIVE tree(id, depth) AS (
+			SELECT ?, 0
+			UNION ALL
+			SELECT f.id, t.depth + 1 FROM folders f JOIN tree t ON f.parent_id = t.id
+		)
+		SELECT m.id FROM media_items m
+		JOIN tree t ON m.folder_id = t.id
+		WHERE m.deleted_at IS NULL
+		ORDER BY t.depth, m.title, m.id
+	`, folderID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	if modifiedAt.Valid {
-		m.ModifiedAt = modifiedAt.Time
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
 	}
-
-	return &m, nil
+	return ids, rows.Err()
 }
 
-// GetRootMedia returns media items that are in the library root (folder_id is empty)
-func (s *SQLiteStorage) GetRootMedia() ([]MediaItem, error) {
+// GetDirectFolderStats returns the totals of the media directly in each
+// folder, keyed by folder ID, so a whole tree can be summed from one query.
+// Folders without media are left out.
+func (s *SQLiteStorage) GetDirectFolderStats() (map[string]FolderStats, error) {
 	rows, err := s.db.Query(`
-		SELECT id, folder_id, title, path, size, duration, width, height,
-		       video_codec, audio_codec, audio_channels, has_subtitles, file_modified_at, created_at
-		FROM media_items WHERE folder_id = '' ORDER BY title
+		SELECT folder_id, COUNT(*), COALESCE(SUM(size), 0), COALESCE(SUM(duration), 0)
+		FROM media_items
+		WHERE deleted_at IS NULL
+		GROUP BY folder_id
 	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var items []MediaItem
+	stats := make(map[string]FolderStats)
 	for rows.Next() {
-		var m MediaItem
-		var modifiedAt sql.NullTime
-		if err := rows.Scan(
-			&m.ID, &m.FolderID, &m.Title, &m.Path, &m.Size,
-			&m.Duration, &m.Width, &m.Height,
-			&m.VideoCodec, &m.AudioCodec, &m.AudioChannels, &m.HasSubtitles,
-			&modifiedAt, &m.CreatedAt,
-		); err != nil {
+		var folderID string
+		var st FolderStats
+		if err := rows.Scan(&folderID, &st.ItemCount, &st.TotalSize, &st.TotalDuration); err != nil {
 			return nil, err
 		}
-		if modifiedAt.Valid {
-			m.ModifiedAt = modifiedAt.Time
-		}
-		items = append(items, m)
+		stats[folderID] = st
 	}
-
-	return items, rows.Err()
+	return stats, rows.Err()
 }
 
-func (s *SQLiteStorage) GetMediaItemsByFolder(folderID string) ([]MediaItem, error) {
+// GetFolderReport returns every folder, including empty ones, with its
+// direct media count, summed size and newest file mtime. sort is one of
+// name, path, media_count, total_size, last_modified; a leading "-" sorts
+// descending. Unknown keys fall back to name.
+func (s *SQLiteStorage) GetFolderReport(limit, offset int, sort string) ([]FolderReportEntry, error) {
+	dir := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		dir = "DESC"
+		sort = sort[1:]
+	}
+	orderBy, ok := folderReportSorts[sort]
+	if !ok {
+		orderBy = folderReportSorts["name"]
+	}
+
 	rows, err := s.db.Query(`
-		SELECT id, folder_id, title, path, size, duration, width, height,
-		       video_codec, audio_codec, audio_channels, has_subtitles, file_modified_at, created_at
-		FROM media_items WHERE folder_id = ? ORDER BY title
-	`, folderID)
+		SELECT f.id, f.name, f.path, f.parent_id,
+			COUNT(m.id) AS media_count,
+			COALESCE(SUM(m.size), 0) AS total_size,
+			MAX(m.file_modified_at) AS last_modified
+		FROM folders f
+		LEFT JOIN media_items m ON m.folder_id = f.id AND m.deleted_at IS NULL
+		GROUP BY f.id
+		ORDER BY `+orderBy+` `+dir+`, f.path
+		LIMIT ? OFFSET ?
+	`, limit, offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var items []MediaItem
+	var entries []FolderReportEntry
 	for rows.Next() {
-		var m MediaItem
-		var modifiedAt sql.NullTime
-		if err := rows.Scan(
-			&m.ID, &m.FolderID, &m.Title, &m.Path, &m.Size,
-			&m.Duration, &m.Width, &m.Height,
-			&m.VideoCodec, &m.AudioCodec, &m.AudioChannels, &m.HasSubtitles,
-			&modifiedAt, &m.CreatedAt,
-		); err != nil {
+		var e FolderReportEntry
+		var lastModified sql.NullString
+		if err := rows.Scan(&e.ID, &e.Name, &e.Path, &e.ParentID, &e.MediaCount, &e.TotalSize, &lastModified); err != nil {
 			return nil, err
 		}
-		if modifiedAt.Valid {
-			m.ModifiedAt = modifiedAt.Time
+		if lastModified.Valid {
+			if t, err := time.Parse(dbTimeLayout, lastModified.String); err == nil {
+				e.LastModified = &t
+			}
 		}
-		items = append(items, m)
+		entries = append(entries, e)
 	}
 
-	return items, rows.Err()
-}
-
-func (s *SQLiteStorage) CreateMediaItem(m *MediaItem) error {
-	_, err := s.db.Exec(`
-		INSERT INTO media_items (
-			id, folder_id, title, path, size, duration, width, height,
-			video_codec, audio_codec, audio_channels, has_subtitles, file_modified_at, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-		ON CONFLICT(path) DO UPDATE SET
-			title = excluded.title,
-			size = excluded.size,
-			file_modified_at = excluded.file_modified_at,
-			updated_at = excluded.updated_at
-	`,
-		m.ID, m.FolderID, m.Title, m.Path, m.Size,
-		m.Duration, m.Width, m.Height,
-		m.VideoCodec, m.AudioCodec, m.AudioChannels, m.HasSubtitles,
-		m.ModifiedAt, m.CreatedAt, time.Now(),
-	)
-
-	return err
+	return entries, rows.Err()
 }
 
-// UpdateMediaMetadata updates metadata fields for a media item
-func (s *SQLiteStorage) UpdateMediaMetadata(id string, duration int64, width, height int, videoCodec, audioCodec string, audioChannels int) error {
-	_, err := s.db.Exec(`
-		UPDATE media_items SET
-			duration = ?,
-			width = ?,
-			height = ?,
-			video_codec = ?,
-			audio_codec = ?,
-			audio_channels = ?,
-			updated_at = ?
-		WHERE id = ?
-	`, duration, width, height, videoCodec, audioCodec, audioChannels, time.Now(), id)
-	return err
+// CountFolders returns the total number of folders
+func (s *SQLiteStorage) CountFolders() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM folders").Scan(&count)
+	return count, err
 }
 
-// GetMediaItemsWithoutMetadata returns media items without duration (metadata not extracted)
-func (s *SQLiteStorage) GetMediaItemsWithoutMetadata(limit int) ([]MediaItem, error) {
+// FindDuplicates groups media with the same file size, largest first. With
+// matchTitles a group is further split so only items whose titles are equal
+// ignoring case, spaces and punctuation stay together. Groups of one are
+// left out.
+func (s *SQLiteStorage) FindDuplicates(matchTitles bool) ([]DuplicateGroup, error) {
 	rows, err := s.db.Query(`
-		SELECT id, folder_id, title, path, size, duration, width, height,
-		       video_codec, audio_codec, audio_channels, has_subtitles, file_modified_at, created_at
-		FROM media_items WHERE duration IS NULL LIMIT ?
-	`, limit)
+		SELECT id, title, path, folder_id, size FROM media_items
+		WHERE deleted_at IS NULL AND size IN (
+			SELECT size FROM media_items
+			WHERE deleted_at IS NULL AND size > 0
+			GROUP BY size HAVING COUNT(*) > 1
+		)
+		ORDER BY size DESC, path
+	`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var items []MediaItem
+	var groups []DuplicateGroup
+	index := make(map[string]int) // size and title key -> position in groups
 	for rows.Next() {
-		var m MediaItem
-		var modifiedAt sql.NullTime
-		if err := rows.Scan(
-			&m.ID, &m.FolderID, &m.Title, &m.Path, &m.Size,
-			&m.Duration, &m.Width, &m.Height,
-			&m.VideoCodec, &m.AudioCodec, &m.AudioChannels, &m.HasSubtitles,
-			&modifiedAt, &m.CreatedAt,
-		); err != nil {
+		var item DuplicateItem
+		var size int64
+		if err := rows.Scan(&item.ID, &item.Title, &item.Path, &item.FolderID, &size); err != nil {
 			return nil, err
 		}
-		if modifiedAt.Valid {
-			m.ModifiedAt = modifiedAt.Time
+
+		key := strconv.FormatInt(size, 10)
+		if matchTitles {
+			key += "|" + duplicateTitleKey(item.Title)
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, DuplicateGroup{Size: size})
 		}
-		items = append(items, m)
+		groups[i].Media = append(groups[i].Media, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	return items, rows.Err()
+	duplicates := groups[:0]
+	for _, g := range groups {
+		if len(g.Media) > 1 {
+			duplicates = append(duplicates, g)
+		}
+	}
+	return duplicates, nil
 }
 
-// Playback State methods
+// duplicateTitleKey reduces a title to its lowercase letters and digits
+func duplicateTitleKey(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
 
-// SavePlaybackState saves or updates playback position for a media item
-func (s *SQLiteStorage) SavePlaybackState(state *PlaybackState) error {
-	_, err := s.db.Exec(`
-		INSERT INTO playback_states (media_id, position, duration, progress, updated_at)
-		VALUES (?, ?, ?, ?, ?)
-		ON CONFLICT(media_id) DO UPDATE SET
-			position = excluded.position,
-			duration = excluded.duration,
-			progress = excluded.progress,
-			updated_at = excluded.updated_at
-	`, state.MediaID, state.Position, state.Duration, state.Progress, time.Now())
+// Media Items
+
+// mediaColumnNames lists the columns read into a MediaItem, in scan order
+var mediaColumnNames = []string{
+	"id", "folder_id", "title", "path", "size", "duration", "width", "height",
+	"video_codec", "audio_codec", "audio_channels", "has_subtitles", "file_modified_at", "created_at",
+	"year", "plot", "genres", "poster_url", "tags",
+	"intro_start", "intro_end", "audio_tracks", "thumbnail_attempts",
+	"thumbnail_version", "season", "episode", "series_name", "bitrate",
+	"content_hash", "has_poster",
+}
+
+// mediaColumns returns the media column list, optionally qualified with a
+// table alias. tags is read from the tags tables, see mediaTagsColumn.
+func mediaColumns(alias string) string {
+	table := alias
+	if table == "" {
+		table = "media_items"
+	}
+	cols := make([]string, len(mediaColumnNames))
+	for i, c := range mediaColumnNames {
+		switch {
+		case c == "tags":
+			cols[i] = fmt.Sprintf(mediaTagsColumn, table)
+		case alias != "":
+			cols[i] = alias + "." + c
+		default:
+			cols[i] = c
+		}
+	}
+	return strings.Join(cols, ", ")
+}
+
+// mediaTagsColumn selects a media item's tags joined with tagSeparator;
+// %s is the media table or its alias
+const mediaTagsColumn = `(SELECT group_concat(t.name, char(31))
+	FROM media_tags mt JOIN tags t ON t.id = mt.tag_id
+	WHERE mt.media_id = %s.id)`
+
+// tagSeparator joins tags in mediaTagsColumn. Tags are free-form, so a
+// control character is used instead of genreSeparator.
+const tagSeparator = "\x1f"
+
+// genreSeparator joins list values (genres, tags) stored in a single column
+const genreSeparator = "|"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanMediaItem scans a row selected with mediaColumns. Extra destinations
+// are scanned after the media columns (used for joined queries).
+func scanMediaItem(row rowScanner, extra ...interface{}) (*MediaItem, error) {
+	var m MediaItem
+	var modifiedAt sql.NullTime
+	var genres, tags sql.NullString
+	dest := []interface{}{
+		&m.ID, &m.FolderID, &m.Title, &m.Path, &m.Size,
+		&m.Duration, &m.Width, &m.Height,
+		&m.VideoCodec, &m.AudioCodec, &m.AudioChannels, &m.HasSubtitles,
+		&modifiedAt, &m.CreatedAt,
+		&m.Year, &m.Plot, &genres, &m.PosterURL, &tags,
+		&m.IntroStart, &m.IntroEnd, &m.AudioTracks, &m.ThumbAttempts,
+		&m.ThumbVersion, &m.Season, &m.Episode, &m.SeriesName, &m.Bitrate,
+		&m.ContentHash, &m.HasPoster,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	if modifiedAt.Valid {
+		m.ModifiedAt = modifiedAt.Time
+		m.DateModified = m.ModifiedAt.UTC().Format(time.RFC3339)
+	}
+	if !m.CreatedAt.IsZero() {
+		m.DateAdded = m.CreatedAt.UTC().Format(time.RFC3339)
+	}
+	m.FileName = filepath.Base(m.Path)
+	m.Container = strings.TrimPrefix(strings.ToLower(filepath.Ext(m.Path)), ".")
+	if genres.String != "" {
+		m.Genres = strings.Split(genres.String, genreSeparator)
+	}
+	if tags.String != "" {
+		m.Tags = strings.Split(tags.String, tagSeparator)
+		sort.Strings(m.Tags)
+	}
+
+	return &m, nil
+}
+
+// queryMediaItems runs a query selecting mediaColumns and collects the rows
+func (s *SQLiteStorage) queryMediaItems(query string, args ...interface{}) ([]MediaItem, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []MediaItem
+	for rows.Next() {
+		m, err := scanMediaItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *m)
+	}
+
+	return items, rows.Err()
+}
+
+func (s *SQLiteStorage) GetMediaItem(id string) (*MediaItem, error) {
+	row := s.db.QueryRow(`
+		SELECT `+mediaColumns("")+`
+		FROM media_items WHERE id = ? AND deleted_at IS NULL
+	`, id)
+
+	m, err := scanMediaItem(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return m, err
+}
+
+func (s *SQLiteStorage) GetMediaItemByPath(path string) (*MediaItem, error) {
+	row := s.db.QueryRow(`
+		SELECT `+mediaColumns("")+`
+		FROM media_items WHERE path = ? AND deleted_at IS NULL
+	`, path)
+
+	m, err := scanMediaItem(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return m, err
+}
+
+// listMediaItems selects media matching a WHERE clause on media_items (aliased m),
+// applying the list options
+func (s *SQLiteStorage) listMediaItems(where string, opts MediaListOptions, args ...interface{}) ([]MediaItem, error) {
+	from, args := mediaListFrom(where, opts, args...)
+	return s.queryMediaItems("SELECT "+mediaColumns("m")+from+" ORDER BY "+mediaOrderBy(opts), args...)
+}
+
+// mediaListFrom builds the FROM and WHERE clauses selecting media matching
+// where (media_items aliased m) and the list options' filters
+func mediaListFrom(where string, opts MediaListOptions, args ...interface{}) (string, []interface{}) {
+	from := `
+		FROM media_items m
+		LEFT JOIN playback_states p ON p.media_id = m.id
+		WHERE m.deleted_at IS NULL AND ` + where
+
+	if opts.HideWatched {
+		// Items without a playback row are always shown
+		from += " AND (p.progress IS NULL OR (p.progress < ? AND NOT p.watched))"
+		args = append(args, opts.WatchedAt)
+	}
+	return from, args
+}
+
+// mediaSortColumns maps sort keys to media_items columns (aliased m)
+var mediaSortColumns = map[string]string{
+	MediaSortTitle:        "m.title",
+	MediaSortDateAdded:    "m.created_at",
+	MediaSortDateModified: "m.file_modified_at",
+	MediaSortSize:         "m.size",
+}
+
+// mediaOrderBy builds the ORDER BY clause for list options. Unknown sort
+// keys fall back to title; title and/or ID break ties so the order is stable.
+func mediaOrderBy(opts MediaListOptions) string {
+	column, ok := mediaSortColumns[opts.Sort]
+	if !ok {
+		column = mediaSortColumns[MediaSortTitle]
+	}
+	dir := "ASC"
+	if opts.Desc {
+		dir = "DESC"
+	}
+	// SQLite only walks an index here when a single ORDER BY term follows
+	// the indexed column. Titles are indexed and timestamps of when items
+	// were added practically never tie, so those break ties on ID alone.
+	switch column {
+	case mediaSortColumns[MediaSortTitle], mediaSortColumns[MediaSortDateAdded]:
+		return column + " " + dir + ", m.id"
+	}
+	return column + " " + dir + ", m.title, m.id"
+}
+
+// GetRootMedia returns media items that are in the library root (folder_id is empty)
+func (s *SQLiteStorage) GetRootMedia(opts MediaListOptions) ([]MediaItem, error) {
+	return s.listMediaItems("m.folder_id = ''", opts)
+}
+
+func (s *SQLiteStorage) GetMediaItemsByFolder(folderID string, opts MediaListOptions) ([]MediaItem, error) {
+	return s.listMediaItems("m.folder_id = ?", opts, folderID)
+}
+
+// GetAllMedia returns one page of all media regardless of folder, plus the
+// total count. sort is one of the MediaSort* keys and order "asc" or "desc";
+// unknown values fall back to title ascending.
+func (s *SQLiteStorage) GetAllMedia(limit, offset int, sort, order string) ([]MediaItem, int, error) {
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM media_items WHERE deleted_at IS NULL").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	opts := MediaListOptions{Sort: sort, Desc: order == "desc"}
+	items, err := s.queryMediaItems(`
+		SELECT `+mediaColumns("m")+`
+		FROM media_items m
+		WHERE m.deleted_at IS NULL
+		ORDER BY `+mediaOrderBy(opts)+`
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	return items, total, err
+}
+
+// GetMediaItemsByFolderPaged returns one page of a folder's media filtered
+// and ordered by the list options (ties are broken so pages never overlap),
+// plus the number of items matching the filters
+func (s *SQLiteStorage) GetMediaItemsByFolderPaged(folderID string, opts MediaListOptions, limit, offset int) ([]MediaItem, int, error) {
+	from, args := mediaListFrom("m.folder_id = ?", opts, folderID)
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	items, err := s.queryMediaItems(
+		"SELECT "+mediaColumns("m")+from+" ORDER BY "+mediaOrderBy(opts)+" LIMIT ? OFFSET ?",
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return items, total, nil
+}
+
+// likeEscaper escapes LIKE wildcards so user input matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// SearchMedia returns media whose title contains query (case-insensitive
+// for ASCII), ordered by title
+func (s *SQLiteStorage) SearchMedia(query string, limit int) ([]MediaItem, error) {
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	return s.queryMediaItems(`
+		SELECT `+mediaColumns("")+`
+		FROM media_items
+		WHERE title LIKE ? ESCAPE '\' AND deleted_at IS NULL
+		ORDER BY title, id
+		LIMIT ?
+	`, pattern, limit)
+}
+
+// upsertMediaItemSQL inserts a media item or refreshes the file-derived
+// fields of the one at the same path, see mediaItemArgs
+const upsertMediaItemSQL = `
+	INSERT INTO media_items (
+		id, folder_id, title, path, size, duration, width, height,
+		video_codec, audio_codec, audio_channels, has_subtitles, file_modified_at, created_at, updated_at,
+		year, plot, genres, season, episode, series_name, content_hash
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(path) DO UPDATE SET
+		title = CASE WHEN media_items.title_locked THEN media_items.title ELSE excluded.title END,
+		size = excluded.size,
+		year = excluded.year,
+		plot = excluded.plot,
+		genres = excluded.genres,
+		season = excluded.season,
+		episode = excluded.episode,
+		series_name = excluded.series_name,
+		content_hash = excluded.content_hash,
+		file_modified_at = excluded.file_modified_at,
+		updated_at = excluded.updated_at,
+		deleted_at = NULL
+`
+
+func mediaItemArgs(m *MediaItem) []interface{} {
+	return []interface{}{
+		m.ID, m.FolderID, m.Title, m.Path, m.Size,
+		m.Duration, m.Width, m.Height,
+		m.VideoCodec, m.AudioCodec, m.AudioChannels, m.HasSubtitles,
+		m.ModifiedAt, m.CreatedAt, time.Now(),
+		m.Year, m.Plot, nullIfEmpty(strings.Join(m.Genres, genreSeparator)),
+		m.Season, m.Episode, m.SeriesName, m.ContentHash,
+	}
+}
+
+func (s *SQLiteStorage) CreateMediaItem(m *MediaItem) error {
+	_, err := s.db.Exec(upsertMediaItemSQL, mediaItemArgs(m)...)
+	return err
+}
+
+// CreateMediaItemsBatch upserts media items like CreateMediaItem in one
+// transaction, which is much faster than one implicit transaction each
+func (s *SQLiteStorage) CreateMediaItemsBatch(items []*MediaItem) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(upsertMediaItemSQL)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, m := range items {
+		if _, err := stmt.Exec(mediaItemArgs(m)...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ApplyEnrichment applies overrides from the enrichment webhook. A title
+// override is locked so later rescans don't replace it with the filename.
+// Tags are added to the ones the item already has.
+func (s *SQLiteStorage) ApplyEnrichment(id string, title, posterURL *string, tags []string) error {
+	if title != nil {
+		if _, err := s.db.Exec(
+			"UPDATE media_items SET title = ?, title_locked = TRUE, updated_at = ? WHERE id = ?",
+			*title, time.Now(), id,
+		); err != nil {
+			return err
+		}
+	}
+
+	if posterURL != nil {
+		if _, err := s.db.Exec(
+			"UPDATE media_items SET poster_url = ?, updated_at = ? WHERE id = ?",
+			nullIfEmpty(*posterURL), time.Now(), id,
+		); err != nil {
+			return err
+		}
+	}
+
+	if len(tags) > 0 {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		if err := addTags(tx, id, tags); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	return nil
+}
+
+// NormalizeTag returns the stored form of a tag: trimmed and lowercase
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// addTags attaches tags to a media item, creating tags that don't exist
+// yet. Empty tags are ignored.
+func addTags(tx *sql.Tx, mediaID string, tags []string) error {
+	for _, tag := range tags {
+		tag = NormalizeTag(tag)
+		if tag == "" {
+			continue
+		}
+		if _, err := tx.Exec("INSERT INTO tags (name) VALUES (?) ON CONFLICT(name) DO NOTHING", tag); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT OR IGNORE INTO media_tags (media_id, tag_id, created_at)
+			SELECT ?, id, ? FROM tags WHERE name = ?
+		`, mediaID, time.Now(), tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddTag tags a media item; the tag is normalized with NormalizeTag
+func (s *SQLiteStorage) AddTag(mediaID, tag string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := addTags(tx, mediaID, []string{tag}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveTag removes a tag from a media item. Tags no longer used by any
+// media are dropped.
+func (s *SQLiteStorage) RemoveTag(mediaID, tag string) error {
+	tag = NormalizeTag(tag)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		DELETE FROM media_tags
+		WHERE media_id = ? AND tag_id = (SELECT id FROM tags WHERE name = ?)
+	`, mediaID, tag); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM tags
+		WHERE name = ? AND NOT EXISTS (SELECT 1 FROM media_tags WHERE tag_id = tags.id)
+	`, tag); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetMediaByTag returns one page of the media with a tag ordered by title
+// (ID breaks ties), plus the total number of media with the tag
+func (s *SQLiteStorage) GetMediaByTag(tag string, limit, offset int) ([]MediaItem, int, error) {
+	tag = NormalizeTag(tag)
+
+	var total int
+	if err := s.db.QueryRow(`
+		SELECT COUNT(*) FROM media_tags mt
+		JOIN tags t ON t.id = mt.tag_id
+		JOIN media_items m ON m.id = mt.media_id
+		WHERE t.name = ? AND m.deleted_at IS NULL
+	`, tag).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	items, err := s.queryMediaItems(`
+		SELECT `+mediaColumns("m")+`
+		FROM media_tags mt
+		JOIN tags t ON t.id = mt.tag_id
+		JOIN media_items m ON m.id = mt.media_id
+		WHERE t.name = ? AND m.deleted_at IS NULL
+		ORDER BY m.title, m.id
+		LIMIT ? OFFSET ?
+	`, tag, limit, offset)
+	return items, total, err
+}
+
+// nullIfEmpty stores empty strings as NULL
+func nullIfEmpty(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
+
+// UpdateMediaMetadata updates metadata fields for a media item. A zero
+// duration or bitrate (not reported by ffprobe) is stored as NULL.
+func (s *SQLiteStorage) UpdateMediaMetadata(id string, duration int64, width, height int, videoCodec, audioCodec string, audioChannels int, bitrate int64) error {
+	var dur, br interface{}
+	if duration > 0 {
+		dur = duration
+	}
+	if bitrate > 0 {
+		br = bitrate
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE media_items SET
+			duration = ?,
+			width = ?,
+			height = ?,
+			video_codec = ?,
+			audio_codec = ?,
+			audio_channels = ?,
+			bitrate = ?,
+			updated_at = ?
+		WHERE id = ?
+	`, dur, width, height, videoCodec, audioCodec, audioChannels, br, time.Now(), id)
+	return err
+}
+
+// ClearMediaMetadata forgets ffprobe results (and intro markers detected
+// from them) so the item is probed again. Manual markers are kept.
+func (s *SQLiteStorage) ClearMediaMetadata(id string) error {
+	_, err := s.db.Exec(`
+		UPDATE media_items SET
+			duration = NULL,
+			width = NULL,
+			height = NULL,
+			video_codec = NULL,
+			audio_codec = NULL,
+			audio_channels = NULL,
+			audio_tracks = NULL,
+			bitrate = NULL,
+			tracks = NULL,
+			intro_start = CASE WHEN intro_source = ? THEN intro_start END,
+			intro_end = CASE WHEN intro_source = ? THEN intro_end END,
+			intro_source = CASE WHEN intro_source = ? THEN intro_source END,
+			metadata_failed = FALSE,
+			updated_at = ?
+		WHERE id = ?
+	`, MarkerSourceManual, MarkerSourceManual, MarkerSourceManual, time.Now(), id)
+	return err
+}
+
+// UpdateMediaSubtitleFlag records whether a file has subtitle streams
+func (s *SQLiteStorage) UpdateMediaSubtitleFlag(id string, hasSubtitles bool) error {
+	_, err := s.db.Exec("UPDATE media_items SET has_subtitles = ? WHERE id = ?", hasSubtitles, id)
+	return err
+}
+
+// SetAudioTrackCount stores the number of audio streams found by ffprobe
+func (s *SQLiteStorage) SetAudioTrackCount(id string, count int) error {
+	_, err := s.db.Exec("UPDATE media_items SET audio_tracks = ? WHERE id = ?", count, id)
+	return err
+}
+
+// SetMediaTracks stores the audio and subtitle streams found by ffprobe
+func (s *SQLiteStorage) SetMediaTracks(id string, tracks MediaTracks) error {
+	data, err := json.Marshal(tracks)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("UPDATE media_items SET tracks = ? WHERE id = ?", string(data), id)
+	return err
+}
+
+// GetMediaTracks returns a media item's streams, or nil if it hasn't been
+// probed yet
+func (s *SQLiteStorage) GetMediaTracks(id string) (*MediaTracks, error) {
+	var data sql.NullString
+	err := s.db.QueryRow("SELECT tracks FROM media_items WHERE id = ?", id).Scan(&data)
+	if err == sql.ErrNoRows || (err == nil && !data.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks MediaTracks
+	if err := json.Unmarshal([]byte(data.String), &tracks); err != nil {
+		return nil, err
+	}
+	return &tracks, nil
+}
+
+// Intro marker sources
+const (
+	MarkerSourceManual   = "manual"
+	MarkerSourceDetected = "detected"
+)
+
+// SetIntroMarkers sets intro markers manually; they take precedence over
+// detected ones. Passing nil for both clears the override.
+func (s *SQLiteStorage) SetIntroMarkers(id string, start, end *float64) error {
+	var source interface{}
+	if start != nil || end != nil {
+		source = MarkerSourceManual
+	}
+	_, err := s.db.Exec(`
+		UPDATE media_items SET intro_start = ?, intro_end = ?, intro_source = ?, updated_at = ?
+		WHERE id = ?
+	`, start, end, source, time.Now(), id)
+	return err
+}
+
+// SetDetectedIntroMarkers stores markers found from chapter titles, unless
+// the markers were set manually
+func (s *SQLiteStorage) SetDetectedIntroMarkers(id string, start, end float64) error {
+	_, err := s.db.Exec(`
+		UPDATE media_items SET intro_start = ?, intro_end = ?, intro_source = ?, updated_at = ?
+		WHERE id = ? AND (intro_source IS NULL OR intro_source != ?)
+	`, start, end, MarkerSourceDetected, time.Now(), id, MarkerSourceManual)
+	return err
+}
+
+// GetMediaItemsWithoutMetadata returns media items without duration (metadata
+// not extracted), skipping items whose extraction failed
+func (s *SQLiteStorage) GetMediaItemsWithoutMetadata(limit int) ([]MediaItem, error) {
+	return s.queryMediaItems(`
+		SELECT `+mediaColumns("")+`
+		FROM media_items
+		WHERE duration IS NULL AND NOT COALESCE(metadata_failed, FALSE) AND deleted_at IS NULL
+		LIMIT ?
+	`, limit)
+}
+
+// IncrementThumbnailAttempts records a failed thumbnail generation and
+// returns the new number of failures
+func (s *SQLiteStorage) IncrementThumbnailAttempts(id string) (int, error) {
+	var attempts int
+	err := s.db.QueryRow(
+		"UPDATE media_items SET thumbnail_attempts = thumbnail_attempts + 1 WHERE id = ? RETURNING thumbnail_attempts",
+		id,
+	).Scan(&attempts)
+	return attempts, err
+}
+
+// ResetThumbnailAttempts clears the failure count so generation is retried
+func (s *SQLiteStorage) ResetThumbnailAttempts(id string) error {
+	_, err := s.db.Exec("UPDATE media_items SET thumbnail_attempts = 0 WHERE id = ?", id)
+	return err
+}
+
+// BumpThumbnailVersion records that a new thumbnail was generated
+func (s *SQLiteStorage) BumpThumbnailVersion(id string) error {
+	_, err := s.db.Exec("UPDATE media_items SET thumbnail_version = thumbnail_version + 1 WHERE id = ?", id)
+	return err
+}
+
+// SetThumbnailGenerated records whether a media item has a thumbnail
+func (s *SQLiteStorage) SetThumbnailGenerated(id string, generated bool) error {
+	_, err := s.db.Exec("UPDATE media_items SET thumbnail_generated = ? WHERE id = ?", generated, id)
+	return err
+}
+
+// ClearThumbnailsGenerated marks every media item as having no thumbnail
+func (s *SQLiteStorage) ClearThumbnailsGenerated() error {
+	_, err := s.db.Exec("UPDATE media_items SET thumbnail_generated = FALSE")
+	return err
+}
+
+// GetMediaIDsWithoutThumbnailFlag returns the IDs of media not marked as
+// having a thumbnail
+func (s *SQLiteStorage) GetMediaIDsWithoutThumbnailFlag() ([]string, error) {
+	rows, err := s.db.Query("SELECT id FROM media_items WHERE NOT COALESCE(thumbnail_generated, FALSE)")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// MarkThumbnailsGenerated marks media items as having a thumbnail in one
+// transaction
+func (s *SQLiteStorage) MarkThumbnailsGenerated(ids []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE media_items SET thumbnail_generated = TRUE WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, id := range ids {
+		if _, err := stmt.Exec(id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SetHasPoster records whether a media item has an uploaded poster
+func (s *SQLiteStorage) SetHasPoster(id string, hasPoster bool) error {
+	_, err := s.db.Exec("UPDATE media_items SET has_poster = ? WHERE id = ?", hasPoster, id)
+	return err
+}
+
+// GetThumbnailVersion returns the current thumbnail version of a media item
+// (0 if it doesn't exist)
+func (s *SQLiteStorage) GetThumbnailVersion(id string) (int, error) {
+	var version int
+	err := s.db.QueryRow("SELECT thumbnail_version FROM media_items WHERE id = ?", id).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+// MarkMetadataFailed excludes an item from background metadata extraction
+// until its metadata is cleared by a rescan
+func (s *SQLiteStorage) MarkMetadataFailed(id string) error {
+	_, err := s.db.Exec("UPDATE media_items SET metadata_failed = TRUE WHERE id = ?", id)
+	return err
+}
+
+// checksumColumns maps checksum algorithms to the checksum, size and mtime
+// columns caching them
+var checksumColumns = map[string][3]string{
+	"sha256": {"checksum", "checksum_size", "checksum_mtime"},
+	"md5":    {"checksum_md5", "checksum_md5_size", "checksum_md5_mtime"},
+}
+
+// GetMediaChecksum returns the cached checksum for an algorithm (sha256 or
+// md5) along with the file size and mtime it was computed for. Returns an
+// empty checksum if none is cached.
+func (s *SQLiteStorage) GetMediaChecksum(id, algorithm string) (checksum string, size int64, mtime time.Time, err error) {
+	cols, ok := checksumColumns[algorithm]
+	if !ok {
+		return "", 0, time.Time{}, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+
+	var sum sql.NullString
+	var sumSize sql.NullInt64
+	var sumMtime sql.NullTime
+	err = s.db.QueryRow(
+		"SELECT "+cols[0]+", "+cols[1]+", "+cols[2]+" FROM media_items WHERE id = ?", id,
+	).Scan(&sum, &sumSize, &sumMtime)
+	if err == sql.ErrNoRows {
+		return "", 0, time.Time{}, nil
+	}
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+	return sum.String, sumSize.Int64, sumMtime.Time, nil
+}
+
+// SetMediaChecksum caches a checksum for the given file size and mtime
+func (s *SQLiteStorage) SetMediaChecksum(id, algorithm, checksum string, size int64, mtime time.Time) error {
+	cols, ok := checksumColumns[algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+
+	_, err := s.db.Exec(
+		"UPDATE media_items SET "+cols[0]+" = ?, "+cols[1]+" = ?, "+cols[2]+" = ? WHERE id = ?",
+		checksum, size, mtime, id,
+	)
+	return err
+}
+
+// Playback State methods
+
+// SetPlaybackHook registers fn to be called with the media ID after a
+// playback state is saved, deleted or marked (un)watched. Call it before
+// the storage is used.
+func (s *SQLiteStorage) SetPlaybackHook(fn func(mediaID string)) {
+	s.onPlayback = fn
+}
+
+// playbackChanged runs the playback hook, if any
+func (s *SQLiteStorage) playbackChanged(mediaID string) {
+	if s.onPlayback != nil {
+		s.onPlayback(mediaID)
+	}
+}
+
+// upsertPlaybackSQL saves a position, clearing the watched flag
+const upsertPlaybackSQL = `
+	INSERT INTO playback_states (media_id, position, duration, progress, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(media_id) DO UPDATE SET
+		position = excluded.position,
+		duration = excluded.duration,
+		progress = excluded.progress,
+		watched = FALSE,
+		updated_at = excluded.updated_at
+`
+
+// SavePlaybackStateIfNewer saves a playback state recorded at
+// state.UpdatedAt unless the stored one was updated later. A stale update
+// is not saved and the stored state is returned with false.
+func (s *SQLiteStorage) SavePlaybackStateIfNewer(state *PlaybackState) (bool, *PlaybackState, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, nil, err
+	}
+	defer tx.Rollback()
+
+	current, err := scanPlaybackState(tx.QueryRow(playbackStateQuery, state.MediaID))
+	if err != nil && err != sql.ErrNoRows {
+		return false, nil, err
+	}
+	if current != nil && state.UpdatedAt.Before(current.UpdatedAt) {
+		return false, current, nil
+	}
+
+	if _, err := tx.Exec(upsertPlaybackSQL, state.MediaID, state.Position, state.Duration, state.Progress, state.UpdatedAt); err != nil {
+		return false, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, nil, err
+	}
+
+	s.playbackChanged(state.MediaID)
+	return true, nil, nil
+}
+
+// DeletePlaybackState forgets the saved position and watched flag of a
+// media item. Deleting a missing state is not an error.
+func (s *SQLiteStorage) DeletePlaybackState(mediaID string) error {
+	if _, err := s.db.Exec("DELETE FROM playback_states WHERE media_id = ?", mediaID); err != nil {
+		return err
+	}
+
+	s.playbackChanged(mediaID)
+	return nil
+}
+
+// SetWatched marks a media item as watched or unwatched. Marking it
+// unwatched also resets the saved position; saving a new position clears
+// the flag again.
+func (s *SQLiteStorage) SetWatched(mediaID string, watched bool) error {
+	update := "watched = TRUE"
+	if !watched {
+		update = "watched = FALSE, position = 0, progress = 0"
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO playback_states (media_id, position, duration, progress, watched, updated_at)
+		VALUES (?, 0, 0, 0, ?, ?)
+		ON CONFLICT(media_id) DO UPDATE SET `+update+`, updated_at = excluded.updated_at
+	`, mediaID, watched, time.Now())
+	if err != nil {
+		return err
+	}
+
+	s.playbackChanged(mediaID)
+	return nil
+}
+
+// GetWatched returns media marked watched or with progress of at least
+// watchedAt, most recently updated first
+func (s *SQLiteStorage) GetWatched(watchedAt float64, limit, offset int) ([]ContinueWatchingItem, error) {
+	rows, err := s.db.Query(`
+		SELECT
+			`+mediaColumns("m")+`,
+			p.media_id, p.position, p.duration, p.progress, p.watched, p.updated_at
+		FROM playback_states p
+		JOIN media_items m ON p.media_id = m.id
+		WHERE (p.watched OR p.progress >= ?) AND m.deleted_at IS NULL
+		ORDER BY p.updated_at DESC
+		LIMIT ? OFFSET ?
+	`, watchedAt, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanContinueWatchingItems(rows)
+}
+
+// GetNextUp returns the episode to watch next for each show (grouped on
+// series name, ignoring case) with a finished episode: the first one after
+// the latest finished episode by season and episode number that isn't
+// finished itself, provided it hasn't been started (those are in continue
+// watching). Missing episode numbers are simply skipped over. Finished
+// means marked watched or with progress of at least watchedAt. Specials
+// (season 0) are left out unless includeSpecials is set. Shows are ordered
+// by when their latest finished episode was watched, most recent first.
+func (s *SQLiteStorage) GetNextUp(watchedAt float64, limit int, includeSpecials bool) ([]MediaItem, error) {
+	return s.queryMediaItems(`
+		WITH episodes AS (
+			SELECT
+				m.id, LOWER(m.series_name) AS series, m.season, m.episode,
+				COALESCE(p.position, 0) > 0 AS started,
+				COALESCE(p.watched OR p.progress >= ?, FALSE) AS finished,
+				p.updated_at
+			FROM media_items m
+			LEFT JOIN playback_states p ON p.media_id = m.id
+			WHERE m.series_name IS NOT NULL AND m.season IS NOT NULL AND m.episode IS NOT NULL
+				AND (? OR m.season > 0) AND m.deleted_at IS NULL
+		),
+		last_finished AS (
+			SELECT series, season, episode, updated_at FROM (
+				SELECT series, season, episode, updated_at,
+					ROW_NUMBER() OVER (PARTITION BY series ORDER BY season DESC, episode DESC) AS rn
+				FROM episodes
+				WHERE finished
+			) WHERE rn = 1
+		),
+		next AS (
+			SELECT e.id, e.started, l.updated_at AS finished_at,
+				ROW_NUMBER() OVER (PARTITION BY e.series ORDER BY e.season, e.episode) AS rn
+			FROM episodes e
+			JOIN last_finished l ON e.series = l.series
+			WHERE (e.season, e.episode) > (l.season, l.episode) AND NOT e.finished
+		)
+		SELECT `+mediaColumns("m")+`
+		FROM next n
+		JOIN media_items m ON m.id = n.id
+		WHERE n.rn = 1 AND NOT n.started
+		ORDER BY n.finished_at DESC
+		LIMIT ?
+	`, watchedAt, includeSpecials, limit)
+}
+
+// Favorites
+
+// AddFavorite marks a media item as a favorite; marking it again keeps the
+// original time
+func (s *SQLiteStorage) AddFavorite(mediaID string) error {
+	_, err := s.db.Exec(
+		"INSERT INTO favorites (media_id, created_at) VALUES (?, ?) ON CONFLICT(media_id) DO NOTHING",
+		mediaID, time.Now(),
+	)
+	return err
+}
+
+// RemoveFavorite unmarks a favorite; unknown IDs are not an error
+func (s *SQLiteStorage) RemoveFavorite(mediaID string) error {
+	_, err := s.db.Exec("DELETE FROM favorites WHERE media_id = ?", mediaID)
 	return err
 }
 
+// IsFavorite reports whether a media item is marked as a favorite
+func (s *SQLiteStorage) IsFavorite(mediaID string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM favorites WHERE media_id = ?)", mediaID).Scan(&exists)
+	return exists, err
+}
+
+// GetFavorites returns favorite media, most recently added first
+func (s *SQLiteStorage) GetFavorites(limit, offset int) ([]FavoriteItem, error) {
+	rows, err := s.db.Query(`
+		SELECT `+mediaColumns("m")+`, f.created_at
+		FROM favorites f
+		JOIN media_items m ON f.media_id = m.id
+		WHERE m.deleted_at IS NULL
+		ORDER BY f.created_at DESC, m.id
+		LIMIT ? OFFSET ?
+	`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []FavoriteItem
+	for rows.Next() {
+		var addedAt time.Time
+		m, err := scanMediaItem(rows, &addedAt)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, FavoriteItem{Media: *m, AddedAt: addedAt})
+	}
+	return items, rows.Err()
+}
+
 // GetPlaybackState returns playback state for a media item
 func (s *SQLiteStorage) GetPlaybackState(mediaID string) (*PlaybackState, error) {
-	row := s.db.QueryRow(`
-		SELECT media_id, position, duration, progress, updated_at
-		FROM playback_states WHERE media_id = ?
-	`, mediaID)
-
-	var state PlaybackState
-	err := row.Scan(&state.MediaID, &state.Position, &state.Duration, &state.Progress, &state.UpdatedAt)
+	state, err := scanPlaybackState(s.db.QueryRow(playbackStateQuery, mediaID))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
+	return state, err
+}
+
+// playbackStateQuery selects the playback state of one media item
+const playbackStateQuery = `
+	SELECT media_id, position, duration, progress, watched, updated_at
+	FROM playback_states WHERE media_id = ?
+`
+
+func scanPlaybackState(row rowScanner) (*PlaybackState, error) {
+	var state PlaybackState
+	if err := row.Scan(&state.MediaID, &state.Position, &state.Duration, &state.Progress, &state.Watched, &state.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// GetPlaybackStates returns playback states for the given media IDs, keyed by media ID.
+// IDs without a saved state are absent from the result.
+func (s *SQLiteStorage) GetPlaybackStates(mediaIDs []string) (map[string]PlaybackState, error) {
+	states := make(map[string]PlaybackState, len(mediaIDs))
+	if len(mediaIDs) == 0 {
+		return states, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(mediaIDs)), ",")
+	args := make([]interface{}, len(mediaIDs))
+	for i, id := range mediaIDs {
+		args[i] = id
+	}
+
+	rows, err := s.db.Query(`
+		SELECT media_id, position, duration, progress, watched, updated_at
+		FROM playback_states WHERE media_id IN (`+placeholders+`)
+	`, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &state, nil
+	for rows.Next() {
+		var state PlaybackState
+		if err := rows.Scan(&state.MediaID, &state.Position, &state.Duration, &state.Progress, &state.Watched, &state.UpdatedAt); err != nil {
+			return nil, err
+		}
+		states[state.MediaID] = state
+	}
+
+	return states, rows.Err()
+}
+
+// Continue-watching orderings
+const (
+	ContinueOrderRecent   = "recent"   // most recently played first
+	ContinueOrderProgress = "progress" // furthest along first
+	ContinueOrderTitle    = "title"    // alphabetical
+)
+
+var continueOrderBy = map[string]string{
+	ContinueOrderRecent:   "p.updated_at DESC",
+	ContinueOrderProgress: "p.progress DESC, p.updated_at DESC",
+	ContinueOrderTitle:    "m.title COLLATE NOCASE, m.title",
 }
 
 // GetContinueWatching returns media items with playback progress (not finished)
-// Progress between 5% and 95% is considered "in progress"
-func (s *SQLiteStorage) GetContinueWatching(limit int) ([]ContinueWatchingItem, error) {
+// Progress strictly between minProgress and maxProgress is considered "in progress".
+// Unknown orders fall back to ContinueOrderRecent.
+func (s *SQLiteStorage) GetContinueWatching(limit int, order string, minProgress, maxProgress float64) ([]ContinueWatchingItem, error) {
+	orderBy, ok := continueOrderBy[order]
+	if !ok {
+		orderBy = continueOrderBy[ContinueOrderRecent]
+	}
+
 	rows, err := s.db.Query(`
 		SELECT
-			m.id, m.folder_id, m.title, m.path, m.size, m.duration, m.width, m.height,
-			m.video_codec, m.audio_codec, m.audio_channels, m.has_subtitles, m.file_modified_at, m.created_at,
-			p.media_id, p.position, p.duration, p.progress, p.updated_at
+			`+mediaColumns("m")+`,
+			p.media_id, p.position, p.duration, p.progress, p.watched, p.updated_at
 		FROM playback_states p
 		JOIN media_items m ON p.media_id = m.id
-		WHERE p.progress > 0.02 AND p.progress < 0.95
-		ORDER BY p.updated_at DESC
+		WHERE p.progress > ? AND p.progress < ? AND NOT p.watched AND m.deleted_at IS NULL
+		ORDER BY `+orderBy+`
 		LIMIT ?
-	`, limit)
+	`, minProgress, maxProgress, limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanContinueWatchingItems(rows)
+}
+
+// scanContinueWatchingItems reads rows of media columns followed by the
+// playback state columns
+func scanContinueWatchingItems(rows *sql.Rows) ([]ContinueWatchingItem, error) {
 	var items []ContinueWatchingItem
 	for rows.Next() {
 		var item ContinueWatchingItem
-		var modifiedAt sql.NullTime
-		if err := rows.Scan(
-			&item.Media.ID, &item.Media.FolderID, &item.Media.Title, &item.Media.Path,
-			&item.Media.Size, &item.Media.Duration, &item.Media.Width, &item.Media.Height,
-			&item.Media.VideoCodec, &item.Media.AudioCodec, &item.Media.AudioChannels, &item.Media.HasSubtitles,
-			&modifiedAt, &item.Media.CreatedAt,
+		m, err := scanMediaItem(rows,
 			&item.PlaybackState.MediaID, &item.PlaybackState.Position,
 			&item.PlaybackState.Duration, &item.PlaybackState.Progress,
-			&item.PlaybackState.UpdatedAt,
-		); err != nil {
+			&item.PlaybackState.Watched, &item.PlaybackState.UpdatedAt,
+		)
+		if err != nil {
 			return nil, err
 		}
-		if modifiedAt.Valid {
-			item.Media.ModifiedAt = modifiedAt.Time
-		}
+		item.Media = *m
 		items = append(items, item)
 	}
 
 	return items, rows.Err()
 }
 
-// GetAllMediaPaths returns all media file paths for cleanup
+// GetAllMediaPaths returns all media file paths for cleanup, leaving out
+// media already soft-deleted
 func (s *SQLiteStorage) GetAllMediaPaths() (map[string]string, error) {
-	rows, err := s.db.Query("SELECT id, path FROM media_items")
+	rows, err := s.db.Query("SELECT id, path FROM media_items WHERE deleted_at IS NULL")
 	if err != nil {
 		return nil, err
 	}
@@ -448,12 +1852,153 @@ func (s *SQLiteStorage) GetAllMediaPaths() (map[string]string, error) {
 	return paths, rows.Err()
 }
 
-// DeleteMediaItem removes a media item by ID
+// GetLibraryStats returns library-wide totals, soft-deleted media excluded
+func (s *SQLiteStorage) GetLibraryStats() (*LibraryStats, error) {
+	var stats LibraryStats
+	err := s.db.QueryRow(`
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(size), 0),
+			COALESCE(SUM(duration), 0),
+			COALESCE(SUM(duration IS NULL), 0),
+			COALESCE(SUM(NOT COALESCE(thumbnail_generated, FALSE)), 0)
+		FROM media_items
+		WHERE deleted_at IS NULL
+	`).Scan(&stats.MediaCount, &stats.TotalSize, &stats.TotalDuration, &stats.MissingMetadata, &stats.MissingThumbnails)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM folders").Scan(&stats.FolderCount); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// HasMediaItems reports whether the library contains any media
+func (s *SQLiteStorage) HasMediaItems() (bool, error) {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM media_items WHERE deleted_at IS NULL)").Scan(&exists)
+	return exists, err
+}
+
+// DeleteMediaItem removes a media item by ID together with its playback
+// state, stored thumbnails, tags and favorite in one transaction
 func (s *SQLiteStorage) DeleteMediaItem(id string) error {
-	_, err := s.db.Exec("DELETE FROM media_items WHERE id = ?", id)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteMediaRows(tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteMediaRows removes a media item and the rows referencing it. Foreign
+// keys aren't enforced, so ON DELETE CASCADE doesn't do this.
+func deleteMediaRows(tx *sql.Tx, id string) error {
+	for _, query := range []string{
+		"DELETE FROM playback_states WHERE media_id = ?",
+		"DELETE FROM thumbnails WHERE media_id = ?",
+		"DELETE FROM media_tags WHERE media_id = ?",
+		"DELETE FROM favorites WHERE media_id = ?",
+		"DELETE FROM media_items WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindMediaByContentHash returns the media with a content hash and size,
+// soft-deleted ones included, other than the one at excludePath. Live items
+// come first, then the most recently deleted.
+func (s *SQLiteStorage) FindMediaByContentHash(hash string, size int64, excludePath string) ([]MediaItem, error) {
+	return s.queryMediaItems(`
+		SELECT `+mediaColumns("")+`
+		FROM media_items
+		WHERE content_hash = ? AND size = ? AND path != ?
+		ORDER BY deleted_at IS NOT NULL, deleted_at DESC
+	`, hash, size, excludePath)
+}
+
+// MoveMediaItem points a media item at a new path and folder, restoring it
+// if it was soft-deleted. The item keeps its ID, so playback state,
+// thumbnails and tags stay attached. A soft-deleted row already at the new
+// path is dropped.
+func (s *SQLiteStorage) MoveMediaItem(id, path, folderID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"DELETE FROM media_items WHERE path = ? AND id != ? AND deleted_at IS NOT NULL",
+		path, id,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		UPDATE media_items SET path = ?, folder_id = ?, deleted_at = NULL, updated_at = ?
+		WHERE id = ?
+	`, path, folderID, time.Now(), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SoftDeleteMediaItem hides a media item whose file went missing. Its
+// playback state is kept and the item is restored if a scan finds the file
+// again; PurgeDeletedMedia removes it for good.
+func (s *SQLiteStorage) SoftDeleteMediaItem(id string) error {
+	_, err := s.db.Exec(
+		"UPDATE media_items SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL",
+		time.Now(), id,
+	)
 	return err
 }
 
+// PurgeDeletedMedia permanently removes media soft-deleted before cutoff,
+// together with their playback state and stored thumbnails. It returns the
+// purged items as ID -> path.
+func (s *SQLiteStorage) PurgeDeletedMedia(cutoff time.Time) (map[string]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id, path FROM media_items WHERE deleted_at < ?", cutoff)
+	if err != nil {
+		return nil, err
+	}
+	purged := make(map[string]string)
+	for rows.Next() {
+		var id, path string
+		if err := rows.Scan(&id, &path); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		purged[id] = path
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for id := range purged {
+		if err := deleteMediaRows(tx, id); err != nil {
+			return nil, err
+		}
+	}
+	return purged, tx.Commit()
+}
+
 // GetAllFolderPaths returns all folder paths for cleanup
 func (s *SQLiteStorage) GetAllFolderPaths() (map[string]string, error) {
 	rows, err := s.db.Query("SELECT id, path FROM folders")
@@ -478,3 +2023,192 @@ func (s *SQLiteStorage) DeleteFolder(id string) error {
 	_, err := s.db.Exec("DELETE FROM folders WHERE id = ?", id)
 	return err
 }
+
+// Thumbnail blobs (used when thumbnails are stored in the database)
+
+// SaveThumbnailData stores thumbnail bytes for a media item and width
+func (s *SQLiteStorage) SaveThumbnailData(mediaID string, width int, data []byte) error {
+	_, err := s.db.Exec(`
+		INSERT INTO thumbnails (media_id, width, data, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(media_id, width) DO UPDATE SET
+			data = excluded.data,
+			created_at = excluded.created_at
+	`, mediaID, width, data, time.Now())
+	return err
+}
+
+// GetThumbnailData returns stored thumbnail bytes, or nil if not stored
+func (s *SQLiteStorage) GetThumbnailData(mediaID string, width int) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRow(
+		"SELECT data FROM thumbnails WHERE media_id = ? AND width = ?",
+		mediaID, width,
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DeleteThumbnailData removes all stored thumbnail sizes for a media item
+func (s *SQLiteStorage) DeleteThumbnailData(mediaID string) error {
+	_, err := s.db.Exec("DELETE FROM thumbnails WHERE media_id = ?", mediaID)
+	return err
+}
+
+// DeleteAllThumbnailData removes the stored thumbnail bytes of all media
+func (s *SQLiteStorage) DeleteAllThumbnailData() error {
+	_, err := s.db.Exec("DELETE FROM thumbnails")
+	return err
+}
+
+// HasThumbnailData checks if thumbnail bytes are stored for a media item
+func (s *SQLiteStorage) HasThumbnailData(mediaID string, width int) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM thumbnails WHERE media_id = ? AND width = ?)",
+		mediaID, width,
+	).Scan(&exists)
+	return exists, err
+}
+
+// RepairConsistency finds rows whose references point at missing rows:
+// folders with a missing parent, media in a missing folder, and playback or
+// thumbnail rows for missing media. Orphaned folders and media are moved to
+// the folder matching their parent directory (or to the top level), the
+// rest is deleted. Everything runs in one transaction; with dryRun the
+// problems are only counted and the transaction is rolled back.
+func (s *SQLiteStorage) RepairConsistency(dryRun bool) (*RepairReport, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	report := &RepairReport{DryRun: dryRun}
+
+	folderIDs, err := pathIndex(tx, "SELECT id, path FROM folders")
+	if err != nil {
+		return nil, err
+	}
+
+	// Folders whose parent is gone
+	orphans, err := idPaths(tx, `
+		SELECT f.id, f.path FROM folders f
+		WHERE f.parent_id IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM folders p WHERE p.id = f.parent_id)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	report.OrphanedFolders = len(orphans)
+	for id, path := range orphans {
+		var parentID interface{}
+		if pid, ok := folderIDs[filepath.Dir(path)]; ok && pid != id {
+			parentID = pid
+			report.FoldersReparented++
+		} else {
+			report.FoldersMadeRoot++
+		}
+		if _, err := tx.Exec("UPDATE folders SET parent_id = ? WHERE id = ?", parentID, id); err != nil {
+			return nil, err
+		}
+	}
+
+	// Media in a folder that's gone
+	orphans, err = idPaths(tx, `
+		SELECT m.id, m.path FROM media_items m
+		WHERE m.folder_id != '' AND m.deleted_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM folders f WHERE f.id = m.folder_id)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	report.OrphanedMedia = len(orphans)
+	for id, path := range orphans {
+		folderID := ""
+		if fid, ok := folderIDs[filepath.Dir(path)]; ok {
+			folderID = fid
+			report.MediaReparented++
+		} else {
+			report.MediaMadeRoot++
+		}
+		if _, err := tx.Exec("UPDATE media_items SET folder_id = ? WHERE id = ?", folderID, id); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rows hanging off media that's gone
+	res, err := tx.Exec("DELETE FROM playback_states WHERE media_id NOT IN (SELECT id FROM media_items)")
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		report.OrphanedPlayback = int(n)
+	}
+
+	res, err = tx.Exec("DELETE FROM thumbnails WHERE media_id NOT IN (SELECT id FROM media_items)")
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		report.OrphanedThumbnails = int(n)
+	}
+
+	res, err = tx.Exec("DELETE FROM media_tags WHERE media_id NOT IN (SELECT id FROM media_items)")
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		report.OrphanedTags = int(n)
+	}
+
+	res, err = tx.Exec("DELETE FROM favorites WHERE media_id NOT IN (SELECT id FROM media_items)")
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		report.OrphanedFavorites = int(n)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+// idPaths runs a query selecting (id, path) and returns id -> path
+func idPaths(tx *sql.Tx, query string) (map[string]string, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]string)
+	for rows.Next() {
+		var id, path string
+		if err := rows.Scan(&id, &path); err != nil {
+			return nil, err
+		}
+		result[id] = path
+	}
+	return result, rows.Err()
+}
+
+// pathIndex runs a query selecting (id, path) and returns path -> id
+func pathIndex(tx *sql.Tx, query string) (map[string]string, error) {
+	byID, err := idPaths(tx, query)
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]string, len(byID))
+	for id, path := range byID {
+		byPath[path] = id
+	}
+	return byPath, nil
+}
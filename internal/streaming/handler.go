@@ -1,20 +1,56 @@
 package streaming
 
 import (
+	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/rs/zerolog"
+
+	"rvcinemaview/internal/clientip"
 	"rvcinemaview/internal/media"
 )
 
-type Handler struct{}
+type Handler struct {
+	limiter *StreamLimiter
+	logger  zerolog.Logger
+}
+
+// NewHandler creates a streaming handler allowing maxStreams concurrent
+// streams (0 = unlimited)
+func NewHandler(maxStreams int, logger zerolog.Logger) *Handler {
+	return &Handler{
+		limiter: NewStreamLimiter(maxStreams),
+		logger:  logger,
+	}
+}
 
-func NewHandler() *Handler {
-	return &Handler{}
+// ActiveStreams returns the number of streams currently in progress
+func (h *Handler) ActiveStreams() int {
+	return h.limiter.Active()
 }
 
 func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	// Range requests from the same client for the same file share one slot
+	key := clientip.FromRequest(r) + "|" + filePath
+	if !h.limiter.Acquire(key) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Too many concurrent streams", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.limiter.Release(key)
+
+	h.ServeCachedFile(w, r, filePath, media.GetContentType(filePath))
+}
+
+// ServeCachedFile serves a file with Range and conditional request support
+// (If-Modified-Since, If-None-Match, If-Range). Media streams, sprites,
+// subtitles and artwork all go through it so they share the same headers.
+// An empty contentType is derived from the file extension.
+func (h *Handler) ServeCachedFile(w http.ResponseWriter, r *http.Request, filePath, contentType string) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
@@ -28,9 +64,42 @@ func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request, filePath str
 		return
 	}
 
-	contentType := media.GetContentType(filePath)
-	w.Header().Set("Content-Type", contentType)
+	h.ServeCachedContent(w, r, filepath.Base(filePath), stat.ModTime(), file, contentType)
+}
+
+// ServeCachedContent is ServeCachedFile for content that isn't read from a
+// file, such as thumbnails held in memory. A zero modTime leaves out
+// Last-Modified, so callers should set an ETag instead.
+func (h *Handler) ServeCachedContent(w http.ResponseWriter, r *http.Request, name string, modTime time.Time, content io.ReadSeeker, contentType string) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		http.Error(w, "Cannot read file", http.StatusInternalServerError)
+		return
+	}
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
 	w.Header().Set("Accept-Ranges", "bytes")
 
-	http.ServeContent(w, r, filepath.Base(filePath), stat.ModTime(), file)
+	// Validate Range up front: malformed headers are ignored and the whole
+	// file is served, unsatisfiable ones get a clean 416. If-Range is left
+	// to ServeContent since a stale validator means the range is dropped.
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && r.Header.Get("If-Range") == "" {
+		switch err := validateRange(rangeHeader, size); err {
+		case errMalformedRange:
+			h.logger.Debug().Str("range", rangeHeader).Str("file", name).Msg("ignoring malformed range header")
+			r.Header.Del("Range")
+		case errUnsatisfiableRange:
+			h.logger.Debug().Str("range", rangeHeader).Int64("size", size).Str("file", name).Msg("unsatisfiable range")
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+
+	http.ServeContent(w, r, name, modTime, content)
 }
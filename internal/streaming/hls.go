@@ -0,0 +1,329 @@
+package streaming
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	hlsPlaylistName   = "playlist.m3u8"
+	hlsSegmentSeconds = 6
+	hlsStartupTimeout = 20 * time.Second // wait for the first segment
+)
+
+// Codecs browsers decode natively, as lowercase ffprobe codec names
+// (video_codec / audio_codec store them uppercased). Other streams are
+// transcoded for HLS, these are copied.
+var (
+	browserSafeVideoCodecs = map[string]bool{"h264": true}
+	browserSafeAudioCodecs = map[string]bool{"aac": true, "mp3": true}
+)
+
+// codecSafe reports whether a stored codec is in the allowlist. Unknown (nil)
+// codecs are treated as unsafe.
+func codecSafe(codec *string, allowed map[string]bool) bool {
+	return codec != nil && allowed[strings.ToLower(*codec)]
+}
+
+// HLSManager runs one ffmpeg HLS session per media item, writing segments to
+// a scratch directory. The playlist grows while ffmpeg works through the
+// file. Sessions not requested for idleTimeout are stopped and their
+// segments removed.
+type HLSManager struct {
+	dir         string
+	idleTimeout time.Duration
+	logger      zerolog.Logger
+
+	ctx      context.Context // parent of all sessions, set by Start
+	sessions map[string]*hlsSession
+	mu       sync.Mutex
+}
+
+type hlsSession struct {
+	dir        string
+	cancel     context.CancelFunc
+	done       chan struct{} // closed when ffmpeg exits
+	err        error         // ffmpeg exit error, set before done is closed
+	lastAccess time.Time
+}
+
+// NewHLSManager creates a manager writing sessions below dir
+func NewHLSManager(dir string, idleTimeout time.Duration, logger zerolog.Logger) *HLSManager {
+	return &HLSManager{
+		dir:         dir,
+		idleTimeout: idleTimeout,
+		logger:      logger,
+		ctx:         context.Background(),
+		sessions:    make(map[string]*hlsSession),
+	}
+}
+
+// IsAvailable checks if ffmpeg is installed
+func (m *HLSManager) IsAvailable() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+// Start removes leftovers from earlier runs and reaps idle sessions until
+// ctx is done, when all sessions are stopped
+func (m *HLSManager) Start(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.mu.Unlock()
+
+	os.RemoveAll(m.dir)
+
+	interval := m.idleTimeout / 2
+	if interval < 5*time.Second {
+		interval = 5 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				m.stopIdle(0)
+				return
+			case <-ticker.C:
+				m.stopIdle(m.idleTimeout)
+			}
+		}
+	}()
+}
+
+// ServePlaylist serves the session's playlist, starting ffmpeg on the first
+// request. Browser-safe streams are copied, everything else is transcoded
+// to H.264/AAC. A non-empty query is appended to the segment URIs.
+func (m *HLSManager) ServePlaylist(w http.ResponseWriter, r *http.Request, mediaID, filePath string, videoCodec, audioCodec *string, query string) {
+	if !m.IsAvailable() {
+		http.Error(w, "Transcoding is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	session, err := m.session(mediaID, filePath, videoCodec, audioCodec)
+	if err != nil {
+		m.logger.Error().Err(err).Str("id", mediaID).Msg("failed to start hls session")
+		http.Error(w, "Failed to start transcode", http.StatusInternalServerError)
+		return
+	}
+
+	playlist, err := session.waitForPlaylist(r.Context())
+	if err != nil {
+		m.logger.Warn().Err(err).Str("id", mediaID).Msg("hls playlist not ready")
+		http.Error(w, "Transcode failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(withSegmentQuery(playlist, query))
+}
+
+// ServeSegment serves a finished segment of a running session
+func (m *HLSManager) ServeSegment(w http.ResponseWriter, r *http.Request, mediaID string, index int) {
+	m.mu.Lock()
+	session := m.sessions[mediaID]
+	if session != nil {
+		session.lastAccess = time.Now()
+	}
+	m.mu.Unlock()
+
+	if session == nil {
+		http.Error(w, "No active transcode", http.StatusNotFound)
+		return
+	}
+
+	path := filepath.Join(session.dir, segmentName(index))
+	if _, err := os.Stat(path); err != nil {
+		http.Error(w, "Segment not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	http.ServeFile(w, r, path)
+}
+
+// session returns the running session for a media item or starts one
+func (m *HLSManager) session(mediaID, filePath string, videoCodec, audioCodec *string) (*hlsSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if session, ok := m.sessions[mediaID]; ok {
+		if !session.failed() {
+			session.lastAccess = time.Now()
+			return session, nil
+		}
+		// Retry failed transcodes instead of serving the error until idle
+		delete(m.sessions, mediaID)
+		os.RemoveAll(session.dir)
+	}
+
+	// Each session gets its own directory, so a stopped session still being
+	// removed can't delete the files of a new one for the same media
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(m.dir, mediaID+"-")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(m.ctx)
+	cmd := exec.CommandContext(ctx, "ffmpeg", hlsArgs(filePath, dir, videoCodec, audioCodec)...)
+	// ffmpeg's errors go to a file in the session directory
+	logFile, err := os.Create(filepath.Join(dir, "ffmpeg.log"))
+	if err != nil {
+		cancel()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	cmd.Stderr = logFile
+	err = cmd.Start()
+	logFile.Close()
+	if err != nil {
+		cancel()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	session := &hlsSession{
+		dir:        dir,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+		lastAccess: time.Now(),
+	}
+	m.sessions[mediaID] = session
+
+	go func() {
+		err := cmd.Wait()
+		session.err = err
+		close(session.done)
+		if err != nil && ctx.Err() == nil {
+			output, _ := os.ReadFile(filepath.Join(dir, "ffmpeg.log"))
+			m.logger.Warn().Err(err).Str("id", mediaID).Str("stderr", string(output)).Msg("hls transcode failed")
+		}
+	}()
+
+	m.logger.Info().
+		Str("id", mediaID).
+		Bool("copy_video", codecSafe(videoCodec, browserSafeVideoCodecs)).
+		Bool("copy_audio", codecSafe(audioCodec, browserSafeAudioCodecs)).
+		Msg("hls session started")
+	return session, nil
+}
+
+// stopIdle stops sessions not accessed within idle (0 = all sessions)
+func (m *HLSManager) stopIdle(idle time.Duration) {
+	m.mu.Lock()
+	var stopped []*hlsSession
+	for id, session := range m.sessions {
+		if idle > 0 && time.Since(session.lastAccess) < idle {
+			continue
+		}
+		delete(m.sessions, id)
+		stopped = append(stopped, session)
+		m.logger.Debug().Str("id", id).Msg("hls session stopped")
+	}
+	m.mu.Unlock()
+
+	for _, session := range stopped {
+		session.cancel()
+		<-session.done
+		os.RemoveAll(session.dir)
+	}
+}
+
+// failed reports whether ffmpeg has exited with an error
+func (s *hlsSession) failed() bool {
+	select {
+	case <-s.done:
+		return s.err != nil
+	default:
+		return false
+	}
+}
+
+// waitForPlaylist returns the playlist once it lists at least one segment
+func (s *hlsSession) waitForPlaylist(ctx context.Context) ([]byte, error) {
+	timeout := time.NewTimer(hlsStartupTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		data, err := os.ReadFile(filepath.Join(s.dir, hlsPlaylistName))
+		if err == nil && bytes.Contains(data, []byte("#EXTINF")) {
+			return data, nil
+		}
+
+		select {
+		case <-s.done:
+			// ffmpeg may have finished a short file between the read and now
+			if data, err := os.ReadFile(filepath.Join(s.dir, hlsPlaylistName)); err == nil {
+				return data, nil
+			}
+			return nil, errors.New("ffmpeg exited without a playlist")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout.C:
+			return nil, errors.New("timed out waiting for the first segment")
+		case <-ticker.C:
+		}
+	}
+}
+
+// withSegmentQuery appends query to every URI line of a playlist
+func withSegmentQuery(playlist []byte, query string) []byte {
+	if query == "" {
+		return playlist
+	}
+	lines := bytes.Split(playlist, []byte("\n"))
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 || line[0] == '#' {
+			continue
+		}
+		lines[i] = append(bytes.TrimRight(line, "\r"), "?"+query...)
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+func segmentName(index int) string {
+	return "segment" + strconv.Itoa(index) + ".ts"
+}
+
+func hlsArgs(filePath, dir string, videoCodec, audioCodec *string) []string {
+	args := []string{"-v", "error", "-i", filePath, "-map", "0:v:0", "-map", "0:a:0?"}
+
+	if codecSafe(videoCodec, browserSafeVideoCodecs) {
+		args = append(args, "-c:v", "copy")
+	} else {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p")
+	}
+	if codecSafe(audioCodec, browserSafeAudioCodecs) {
+		args = append(args, "-c:a", "copy")
+	} else {
+		args = append(args, "-c:a", "aac", "-ac", "2")
+	}
+
+	return append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(hlsSegmentSeconds),
+		"-hls_list_size", "0",
+		"-hls_playlist_type", "event",
+		"-hls_segment_filename", filepath.Join(dir, "segment%d.ts"),
+		filepath.Join(dir, hlsPlaylistName),
+	)
+}
@@ -0,0 +1,55 @@
+package streaming
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestWithSegmentQuery(t *testing.T) {
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.0,\nsegment0.ts\n#EXTINF:6.0,\r\nsegment1.ts\r\n"
+
+	got := string(withSegmentQuery([]byte(playlist), "api_key=secret"))
+	want := "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.0,\nsegment0.ts?api_key=secret\n#EXTINF:6.0,\r\nsegment1.ts?api_key=secret\n"
+	if got != want {
+		t.Errorf("withSegmentQuery() = %q, want %q", got, want)
+	}
+
+	if got := string(withSegmentQuery([]byte(playlist), "")); got != playlist {
+		t.Errorf("withSegmentQuery() with empty query = %q, want playlist unchanged", got)
+	}
+}
+
+func TestHLSSessionDirsAreUnique(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte("#!/bin/sh\nsleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	m := NewHLSManager(t.TempDir(), time.Minute, zerolog.Nop())
+	first, err := m.session("media", "movie.mkv", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.stopIdle(0)
+
+	second, err := m.session("media", "movie.mkv", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.stopIdle(0)
+
+	if first.dir == second.dir {
+		t.Fatalf("sessions share directory %s", first.dir)
+	}
+	if _, err := os.Stat(second.dir); err != nil {
+		t.Errorf("new session directory: %v", err)
+	}
+	if _, err := os.Stat(first.dir); !os.IsNotExist(err) {
+		t.Errorf("stopped session directory still exists: %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package streaming
+
+import (
+	"sync"
+	"time"
+)
+
+// sessionGrace keeps a stream slot reserved briefly after a request ends,
+// so the next Range request of the same playback doesn't lose its slot
+const sessionGrace = 30 * time.Second
+
+// StreamLimiter caps concurrent streams. Requests sharing a session key
+// (client + media) count as a single stream.
+type StreamLimiter struct {
+	max      int // 0 = unlimited
+	sessions map[string]*streamSession
+	mu       sync.Mutex
+}
+
+type streamSession struct {
+	refs     int
+	lastSeen time.Time
+}
+
+// NewStreamLimiter creates a limiter allowing max concurrent streams (0 = unlimited)
+func NewStreamLimiter(max int) *StreamLimiter {
+	return &StreamLimiter{
+		max:      max,
+		sessions: make(map[string]*streamSession),
+	}
+}
+
+// Acquire reserves a slot for the session key. Returns false if the cap is reached.
+func (l *StreamLimiter) Acquire(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expire()
+
+	if sess, ok := l.sessions[key]; ok {
+		sess.refs++
+		sess.lastSeen = time.Now()
+		return true
+	}
+
+	if l.max > 0 && len(l.sessions) >= l.max {
+		return false
+	}
+
+	l.sessions[key] = &streamSession{refs: 1, lastSeen: time.Now()}
+	return true
+}
+
+// Release frees a request's hold on the session; the slot lingers for sessionGrace
+func (l *StreamLimiter) Release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if sess, ok := l.sessions[key]; ok {
+		sess.refs--
+		sess.lastSeen = time.Now()
+	}
+}
+
+// Active returns the number of streams currently holding a slot
+func (l *StreamLimiter) Active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expire()
+	return len(l.sessions)
+}
+
+// expire drops idle sessions past the grace period. Caller must hold mu.
+func (l *StreamLimiter) expire() {
+	cutoff := time.Now().Add(-sessionGrace)
+	for key, sess := range l.sessions {
+		if sess.refs <= 0 && sess.lastSeen.Before(cutoff) {
+			delete(l.sessions, key)
+		}
+	}
+}
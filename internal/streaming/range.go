@@ -0,0 +1,67 @@
+package streaming
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	errMalformedRange     = errors.New("malformed range")
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+)
+
+// validateRange checks a Range header against the file size. It returns
+// errMalformedRange when the header cannot be parsed and
+// errUnsatisfiableRange when no range overlaps the file.
+func validateRange(header string, size int64) error {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return errMalformedRange
+	}
+
+	satisfiable := false
+	for _, spec := range strings.Split(header[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		startStr, endStr, ok := strings.Cut(spec, "-")
+		if !ok {
+			return errMalformedRange
+		}
+		startStr = strings.TrimSpace(startStr)
+		endStr = strings.TrimSpace(endStr)
+
+		if startStr == "" {
+			// Suffix range: last N bytes
+			n, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || n < 0 {
+				return errMalformedRange
+			}
+			if n > 0 && size > 0 {
+				satisfiable = true
+			}
+			continue
+		}
+
+		start, err := strconv.ParseInt(startStr, 10, 64)
+		if err != nil || start < 0 {
+			return errMalformedRange
+		}
+		if endStr != "" {
+			end, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || end < start {
+				return errMalformedRange
+			}
+		}
+		if start < size {
+			satisfiable = true
+		}
+	}
+
+	if !satisfiable {
+		return errUnsatisfiableRange
+	}
+	return nil
+}
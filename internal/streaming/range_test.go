@@ -0,0 +1,115 @@
+package streaming
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestValidateRange(t *testing.T) {
+	const size = 100
+
+	tests := []struct {
+		header string
+		want   error
+	}{
+		{"bytes=0-9", nil},
+		{"bytes=10-", nil},
+		{"bytes=-20", nil},
+		{"bytes=0-9, 50-", nil},
+		{"bytes=90-200", nil},
+		{"bytes=100-", errUnsatisfiableRange},
+		{"bytes=100-200, 150-", errUnsatisfiableRange},
+		{"bytes=-0", errUnsatisfiableRange},
+		{"items=0-9", errMalformedRange},
+		{"bytes=abc", errMalformedRange},
+		{"bytes=5-1", errMalformedRange},
+		{"bytes=-5-", errMalformedRange},
+		{"bytes=x-9", errMalformedRange},
+		{"bytes=0-y", errMalformedRange},
+	}
+
+	for _, tt := range tests {
+		if got := validateRange(tt.header, size); got != tt.want {
+			t.Errorf("validateRange(%q, %d) = %v, want %v", tt.header, size, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRangeEmptyFile(t *testing.T) {
+	if err := validateRange("bytes=-10", 0); err != errUnsatisfiableRange {
+		t.Errorf("suffix range on empty file = %v, want %v", err, errUnsatisfiableRange)
+	}
+}
+
+func TestServeCachedFileRanges(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	path := filepath.Join(t.TempDir(), "sprite.jpg")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(0, zerolog.Nop())
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"no range", "", http.StatusOK, content, ""},
+		{"single", "bytes=10-19", http.StatusPartialContent, content[10:20], "bytes 10-19/100"},
+		{"open-ended", "bytes=95-", http.StatusPartialContent, content[95:], "bytes 95-99/100"},
+		{"suffix", "bytes=-3", http.StatusPartialContent, content[97:], "bytes 97-99/100"},
+		{"end past size", "bytes=90-500", http.StatusPartialContent, content[90:], "bytes 90-99/100"},
+		{"malformed is dropped", "bytes=abc", http.StatusOK, content, ""},
+		{"reversed is dropped", "bytes=9-1", http.StatusOK, content, ""},
+		{"wrong unit is dropped", "lines=1-2", http.StatusOK, content, ""},
+		{"unsatisfiable", "bytes=100-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/sprite.jpg", nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+
+			h.ServeCachedFile(rec, req, path, "image/jpeg")
+
+			resp := rec.Result()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := resp.Header.Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Content-Range = %q, want %q", got, tt.contentRange)
+			}
+			if got := resp.Header.Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("Accept-Ranges = %q, want bytes", got)
+			}
+			if tt.status != http.StatusRequestedRangeNotSatisfiable && string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestServeCachedFileMissing(t *testing.T) {
+	h := NewHandler(0, zerolog.Nop())
+	req := httptest.NewRequest(http.MethodGet, "/missing.jpg", nil)
+	rec := httptest.NewRecorder()
+
+	h.ServeCachedFile(rec, req, filepath.Join(t.TempDir(), "missing.jpg"), "image/jpeg")
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
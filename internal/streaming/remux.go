@@ -0,0 +1,214 @@
+package streaming
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"rvcinemaview/internal/clientip"
+)
+
+// Stream containers that can be requested through the stream.<ext> aliases
+const (
+	ContainerMP4 = "mp4"
+	ContainerMKV = "mkv"
+)
+
+var containerContentTypes = map[string]string{
+	ContainerMP4: "video/mp4",
+	ContainerMKV: "video/x-matroska",
+}
+
+// mp4VideoCodecs are the video codecs MP4 carries; anything else is
+// re-encoded to H.264 when a file is served as MP4
+var mp4VideoCodecs = map[string]bool{"h264": true, "hevc": true, "av1": true, "vp9": true, "mpeg4": true}
+
+// remuxableContainers are source containers browsers refuse to play that
+// can be repackaged as MP4 without re-encoding
+var remuxableContainers = map[string]bool{ContainerMKV: true, "avi": true}
+
+// CanRemux reports whether a file in a container browsers refuse carries
+// video and audio codecs they play, so remuxing it to MP4 with stream copy
+// makes it playable. Unknown codecs are treated as unsafe.
+func CanRemux(filePath string, videoCodec, audioCodec *string) bool {
+	return remuxableContainers[SourceContainer(filePath)] &&
+		codecSafe(videoCodec, browserSafeVideoCodecs) &&
+		codecSafe(audioCodec, browserSafeAudioCodecs)
+}
+
+// NeedsAudioTranscode reports whether a file with browser-safe video has
+// audio browsers can't decode (DTS, TrueHD, ...), so it only plays with
+// sound once the audio is converted to AAC. Files whose audio codec isn't
+// known yet are left alone.
+func NeedsAudioTranscode(filePath string, videoCodec, audioCodec *string) bool {
+	container := SourceContainer(filePath)
+	return (container == ContainerMP4 || remuxableContainers[container]) &&
+		codecSafe(videoCodec, browserSafeVideoCodecs) &&
+		audioCodec != nil && !codecSafe(audioCodec, browserSafeAudioCodecs)
+}
+
+// SourceContainer returns the container of a file as named by the stream aliases
+func SourceContainer(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".mp4", ".m4v":
+		return ContainerMP4
+	case ".mkv":
+		return ContainerMKV
+	default:
+		return strings.TrimPrefix(strings.ToLower(filepath.Ext(filePath)), ".")
+	}
+}
+
+// ServeAs streams a file in the given container. Files already in that
+// container are served directly with Range support; anything else is
+// remuxed on the fly by ffmpeg, which produces a non-seekable stream.
+// Video MP4 can't carry (or of unknown codec) is re-encoded to H.264.
+// audioTrack selects the audio stream by index (-1 = first); picking a
+// track always remuxes, since a direct byte stream can't switch tracks.
+func (h *Handler) ServeAs(w http.ResponseWriter, r *http.Request, filePath, container string, audioTrack int, videoCodec *string) {
+	if SourceContainer(filePath) == container && audioTrack < 0 {
+		h.ServeFile(w, r, filePath)
+		return
+	}
+
+	copyVideo := container != ContainerMP4 || codecSafe(videoCodec, mp4VideoCodecs)
+	h.remux(w, r, filePath, container, audioTrack, copyVideo, false)
+}
+
+// ServeRemuxed streams a file as fragmented MP4, copying video and audio
+// as is; only use it for files where CanRemux is true. Without ffmpeg the
+// file is served directly instead.
+func (h *Handler) ServeRemuxed(w http.ResponseWriter, r *http.Request, filePath string) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		h.ServeFile(w, r, filePath)
+		return
+	}
+
+	h.remux(w, r, filePath, ContainerMP4, -1, true, true)
+}
+
+// ServeAudioTranscoded streams a file as fragmented MP4 with the video
+// copied and the audio converted to AAC. Without ffmpeg the file is served
+// directly instead.
+func (h *Handler) ServeAudioTranscoded(w http.ResponseWriter, r *http.Request, filePath string) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		h.ServeFile(w, r, filePath)
+		return
+	}
+
+	h.remux(w, r, filePath, ContainerMP4, -1, true, false)
+}
+
+// remuxOutputProbe is how much ffmpeg output is awaited before the
+// response headers are sent
+const remuxOutputProbe = 32 * 1024
+
+// remux pipes the file through ffmpeg in the given container. copyVideo
+// and copyAudio keep the streams as they are instead of converting them
+// for the container.
+func (h *Handler) remux(w http.ResponseWriter, r *http.Request, filePath, container string, audioTrack int, copyVideo, copyAudio bool) {
+	contentType, ok := containerContentTypes[container]
+	if !ok {
+		http.Error(w, "Unsupported container", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		http.Error(w, "Remuxing is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	key := clientip.FromRequest(r) + "|" + filePath
+	if !h.limiter.Acquire(key) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "Too many concurrent streams", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.limiter.Release(key)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(r.Context(), "ffmpeg", remuxArgs(filePath, container, audioTrack, copyVideo, copyAudio)...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		h.logger.Error().Err(err).Str("file", filePath).Msg("failed to start ffmpeg")
+		http.Error(w, "Remuxing failed", http.StatusInternalServerError)
+		return
+	}
+
+	// Headers are only sent once ffmpeg produced output, so files it can't
+	// open or convert get an error instead of an empty 200
+	first := make([]byte, remuxOutputProbe)
+	n, _ := io.ReadAtLeast(stdout, first, 1)
+	if n == 0 {
+		err := cmd.Wait()
+		if r.Context().Err() != nil {
+			return
+		}
+		h.logger.Error().Err(err).
+			Str("file", filePath).
+			Str("ffmpeg", strings.TrimSpace(stderr.String())).
+			Msg("remux produced no output")
+		http.Error(w, "Remuxing failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Accept-Ranges", "none")
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are already sent, so a failure just ends the stream early
+	_, err = w.Write(first[:n])
+	if err == nil {
+		_, err = io.Copy(w, stdout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+	}
+	if err := cmd.Wait(); err != nil && r.Context().Err() == nil {
+		h.logger.Warn().Err(err).
+			Str("file", filePath).
+			Str("ffmpeg", strings.TrimSpace(stderr.String())).
+			Msg("remux ended early")
+	}
+}
+
+func remuxArgs(filePath, container string, audioTrack int, copyVideo, copyAudio bool) []string {
+	audioMap := "0:a:0?"
+	if audioTrack >= 0 {
+		audioMap = "0:a:" + strconv.Itoa(audioTrack)
+	}
+	args := []string{"-v", "error", "-i", filePath, "-map", "0:v:0", "-map", audioMap}
+
+	switch container {
+	case ContainerMP4:
+		// MP4 can't carry every codec found in other containers, so streams
+		// are re-encoded unless known to be safe. The file is fragmented so
+		// it can be piped.
+		if copyVideo {
+			args = append(args, "-c:v", "copy")
+		} else {
+			args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-pix_fmt", "yuv420p")
+		}
+		audioCodec := "aac"
+		if copyAudio {
+			audioCodec = "copy"
+		}
+		args = append(args,
+			"-c:a", audioCodec,
+			"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+			"-f", "mp4",
+		)
+	case ContainerMKV:
+		args = append(args, "-c", "copy", "-f", "matroska")
+	}
+
+	return append(args, "pipe:1")
+}